@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/MondayHopscotch/SuperScope/watcher"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Provider is what the server reports on and controls. SimpleWatcher implements it
+type Provider interface {
+	Status() (watcher.Status, error)
+	CancelTracking(name string) error
+	ForceMatch(name string, completed string) error
+}
+
+type Server struct {
+	addr     string
+	provider Provider
+	mux      *http.ServeMux
+	server   *http.Server
+}
+
+type cancelRequest struct {
+	Name string `json:"name"`
+}
+
+type matchRequest struct {
+	Name      string `json:"name"`
+	Completed string `json:"completed"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer builds a server exposing:
+//
+//	GET  /api/status   everything below in one response
+//	GET  /api/watched  directories being watched for new torrents
+//	GET  /api/active   torrents waiting for their payload, with their age and original path
+//	GET  /api/ignored  completed dir entries that will never be matched
+//	GET  /api/history  recently linked, failed and cancelled torrents
+//	POST /api/cancel   {"name": "..."} stops tracking a torrent
+//	POST /api/match    {"name": "...", "completed": "..."} finalizes a torrent with the given completed dir entry
+func NewServer(addr string, provider Provider) *Server {
+	s := &Server{addr: addr, provider: provider, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("/api/status", s.status(func(status watcher.Status) interface{} { return status }))
+	s.mux.HandleFunc("/api/watched", s.status(func(status watcher.Status) interface{} { return status.WatchedDirs }))
+	s.mux.HandleFunc("/api/active", s.status(func(status watcher.Status) interface{} { return status.ActiveFiles }))
+	s.mux.HandleFunc("/api/ignored", s.status(func(status watcher.Status) interface{} { return status.IgnoreFiles }))
+	s.mux.HandleFunc("/api/history", s.status(func(status watcher.Status) interface{} { return status.History }))
+	s.mux.HandleFunc("/api/cancel", s.cancel)
+	s.mux.HandleFunc("/api/match", s.match)
+
+	s.server = &http.Server{Addr: addr, Handler: s.mux, ReadTimeout: time.Second * 30, WriteTimeout: time.Second * 30}
+	return s
+}
+
+// Handle adds another handler to the server, for things like metrics that live alongside the API
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start listens on the server's address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	log.Println("HTTP server listening on ", listener.Addr())
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("HTTP server stopped: ", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) status(pick func(watcher.Status) interface{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{Error: "use GET"})
+			return
+		}
+		status, err := s.provider.Status()
+		if err != nil {
+			writeJSON(rw, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(rw, http.StatusOK, pick(status))
+	}
+}
+
+func (s *Server) cancel(rw http.ResponseWriter, req *http.Request) {
+	var body cancelRequest
+	if !readJSON(rw, req, &body) {
+		return
+	}
+	err := s.provider.CancelTracking(body.Name)
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	log.Println("Tracking of ", body.Name, " cancelled through the API")
+	writeJSON(rw, http.StatusOK, body)
+}
+
+func (s *Server) match(rw http.ResponseWriter, req *http.Request) {
+	var body matchRequest
+	if !readJSON(rw, req, &body) {
+		return
+	}
+	err := s.provider.ForceMatch(body.Name, body.Completed)
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	log.Println(body.Name, " matched to ", body.Completed, " through the API")
+	writeJSON(rw, http.StatusOK, body)
+}
+
+func readJSON(rw http.ResponseWriter, req *http.Request, body interface{}) bool {
+	if req.Method != http.MethodPost {
+		writeJSON(rw, http.StatusMethodNotAllowed, errorResponse{Error: "use POST"})
+		return false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, 1<<20)).Decode(body)
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: "bad request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(rw http.ResponseWriter, code int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	err := json.NewEncoder(rw).Encode(body)
+	if err != nil {
+		log.Println("Failed to write response: ", err)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/MondayHopscotch/SuperScope/state"
+	"github.com/MondayHopscotch/SuperScope/watcher"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeProvider struct {
+	status    watcher.Status
+	cancelled []string
+	matched   map[string]string
+}
+
+func (f *fakeProvider) Status() (watcher.Status, error) {
+	return f.status, nil
+}
+
+func (f *fakeProvider) CancelTracking(name string) error {
+	if name != "active.torrent" {
+		return errors.New("not tracked")
+	}
+	f.cancelled = append(f.cancelled, name)
+	return nil
+}
+
+func (f *fakeProvider) ForceMatch(name string, completed string) error {
+	if name != "active.torrent" {
+		return errors.New("not tracked")
+	}
+	f.matched[name] = completed
+	return nil
+}
+
+func TestServer(t *testing.T) {
+
+	provider := &fakeProvider{
+		status: watcher.Status{
+			WatchedDirs: []string{"watch", "watch/tv"},
+			ActiveFiles: []watcher.ActiveStatus{{Record: state.Record{Name: "active.torrent", OrigPath: "watch/tv/active.torrent"}, Age: "1m0s"}},
+			IgnoreFiles: []string{"old.avi"},
+			History:     []state.Record{{Name: "done.torrent", Status: state.StatusLinked}},
+		},
+		matched: make(map[string]string, 0),
+	}
+	server := httptest.NewServer(NewServer("", provider).Handler())
+	defer server.Close()
+
+	Convey("Test watched dirs", t, func() {
+		var dirs []string
+		code := get(server.URL+"/api/watched", &dirs)
+		So(code, ShouldEqual, http.StatusOK)
+		So(dirs, ShouldResemble, []string{"watch", "watch/tv"})
+	})
+
+	Convey("Test active files", t, func() {
+		var active []map[string]interface{}
+		code := get(server.URL+"/api/active", &active)
+		So(code, ShouldEqual, http.StatusOK)
+		So(len(active), ShouldEqual, 1)
+		So(active[0]["name"], ShouldEqual, "active.torrent")
+		So(active[0]["origPath"], ShouldEqual, "watch/tv/active.torrent")
+		So(active[0]["age"], ShouldEqual, "1m0s")
+	})
+
+	Convey("Test ignored and history", t, func() {
+		var ignored []string
+		So(get(server.URL+"/api/ignored", &ignored), ShouldEqual, http.StatusOK)
+		So(ignored, ShouldResemble, []string{"old.avi"})
+
+		var history []state.Record
+		So(get(server.URL+"/api/history", &history), ShouldEqual, http.StatusOK)
+		So(history[0].Status, ShouldEqual, state.StatusLinked)
+	})
+
+	Convey("Test status rejects POST", t, func() {
+		resp, err := http.Post(server.URL+"/api/status", "application/json", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+
+	Convey("Test cancel", t, func() {
+		So(post(server.URL+"/api/cancel", `{"name": "active.torrent"}`), ShouldEqual, http.StatusOK)
+		So(provider.cancelled, ShouldResemble, []string{"active.torrent"})
+
+		So(post(server.URL+"/api/cancel", `{"name": "unknown.torrent"}`), ShouldEqual, http.StatusBadRequest)
+		So(post(server.URL+"/api/cancel", `not json`), ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Test match", t, func() {
+		So(post(server.URL+"/api/match", `{"name": "active.torrent", "completed": "Active.Payload"}`), ShouldEqual, http.StatusOK)
+		So(provider.matched["active.torrent"], ShouldEqual, "Active.Payload")
+	})
+}
+
+func get(url string, body interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(body)
+	return resp.StatusCode
+}
+
+func post(url string, body string) int {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,195 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Decoded values are one of: int64, string, []interface{} or map[string]interface{}
+
+// Decode parses a single bencoded value from data. Trailing data is an error
+func Decode(data []byte) (interface{}, error) {
+	d := decoder{data: data}
+	value, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("bencode: %v bytes of trailing data", len(d.data)-d.pos)
+	}
+	return value, nil
+}
+
+// DecodeDict parses data as a single bencoded dictionary. Alongside the decoded dictionary it returns the raw bytes
+// each value was encoded as in data, which is what to hash when the exact encoding matters (e.g. a torrent's info)
+func DecodeDict(data []byte) (map[string]interface{}, map[string][]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, nil, errors.New("bencode: not a dictionary")
+	}
+	d := decoder{data: data, raw: make(map[string][]byte)}
+	value, err := d.decode()
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, nil, fmt.Errorf("bencode: %v bytes of trailing data", len(d.data)-d.pos)
+	}
+	return value.(map[string]interface{}), d.raw, nil
+}
+
+// Encode produces the canonical bencoding of value. Dictionary keys are always written in sorted order
+func Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encode(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+
+	// raw, when set, collects the encoding of each value in the outermost dictionary
+	raw map[string][]byte
+}
+
+var errUnexpectedEnd = errors.New("bencode: unexpected end of data")
+
+func (d *decoder) decode() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errUnexpectedEnd
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		return d.readInt('e')
+	case c == 'l':
+		d.pos++
+		list := make([]interface{}, 0)
+		for {
+			if d.pos >= len(d.data) {
+				return nil, errUnexpectedEnd
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			item, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+	case c == 'd':
+		d.pos++
+		d.depth++
+		defer func() { d.depth-- }()
+		dict := make(map[string]interface{}, 0)
+		for {
+			if d.pos >= len(d.data) {
+				return nil, errUnexpectedEnd
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			value, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+			if d.raw != nil && d.depth == 1 {
+				d.raw[key] = d.data[start:d.pos]
+			}
+		}
+	case c >= '0' && c <= '9':
+		return d.readString()
+	default:
+		return nil, fmt.Errorf("bencode: unexpected character %q at offset %v", c, d.pos)
+	}
+}
+
+func (d *decoder) readInt(terminator byte) (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], terminator)
+	if end < 0 {
+		return 0, errUnexpectedEnd
+	}
+	value, err := strconv.ParseInt(string(d.data[d.pos:d.pos+end]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bencode: bad integer at offset %v: %v", d.pos, err)
+	}
+	d.pos += end + 1
+	return value, nil
+}
+
+func (d *decoder) readString() (string, error) {
+	length, err := d.readInt(':')
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int64(len(d.data)-d.pos) < length {
+		return "", errUnexpectedEnd
+	}
+	value := string(d.data[d.pos : d.pos+int(length)])
+	d.pos += int(length)
+	return value, nil
+}
+
+func encode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range v {
+			err := encode(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case []string:
+		buf.WriteByte('l')
+		for _, item := range v {
+			encode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			encode(buf, key)
+			err := encode(buf, v[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unable to encode %T", value)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package bencode
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestBencode(t *testing.T) {
+
+	Convey("Test decode integer", t, func() {
+		value, err := Decode([]byte("i-42e"))
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, int64(-42))
+	})
+
+	Convey("Test decode string", t, func() {
+		value, err := Decode([]byte("4:spam"))
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "spam")
+	})
+
+	Convey("Test decode nested list and dictionary", t, func() {
+		value, err := Decode([]byte("d4:listl1:ai2ee4:name3:fooe"))
+		So(err, ShouldBeNil)
+
+		dict, ok := value.(map[string]interface{})
+		So(ok, ShouldBeTrue)
+		So(dict["name"], ShouldEqual, "foo")
+		So(dict["list"], ShouldResemble, []interface{}{"a", int64(2)})
+	})
+
+	Convey("Test decode truncated data", t, func() {
+		_, err := Decode([]byte("d4:name10:short"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test decode trailing data", t, func() {
+		_, err := Decode([]byte("i1ei2e"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test decode bad character", t, func() {
+		_, err := Decode([]byte("x"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test decode dictionary keeps raw values", t, func() {
+		dict, raw, err := DecodeDict([]byte("d4:infod4:name1:a6:lengthi1ee3:zedi1ee"))
+		So(err, ShouldBeNil)
+		So(dict["zed"], ShouldEqual, int64(1))
+		So(string(raw["info"]), ShouldEqual, "d4:name1:a6:lengthi1ee")
+		So(string(raw["zed"]), ShouldEqual, "i1e")
+		So(raw, ShouldNotContainKey, "name")
+
+		_, _, err = DecodeDict([]byte("l1:ae"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test encode sorts keys", t, func() {
+		data, err := Encode(map[string]interface{}{"zed": int64(1), "alpha": []interface{}{"x"}})
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "d5:alphal1:xe3:zedi1ee")
+	})
+
+	Convey("Test encode round trip", t, func() {
+		original := "d4:infod6:lengthi12e4:name8:file.avi12:piece lengthi16384eee"
+		value, err := Decode([]byte(original))
+		So(err, ShouldBeNil)
+
+		data, err := Encode(value)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, original)
+	})
+
+	Convey("Test encode unsupported type", t, func() {
+		_, err := Encode(1.5)
+		So(err, ShouldNotBeNil)
+	})
+}
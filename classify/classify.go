@@ -0,0 +1,195 @@
+package classify
+
+import (
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is what a file in a payload is
+type Kind string
+
+const (
+	// Media is the feature itself: a movie, episode or track
+	Media Kind = "media"
+	// Sample is a short clip of the release
+	Sample Kind = "sample"
+	// Trailer is a trailer or teaser for the feature
+	Trailer Kind = "trailer"
+	// Extra is bonus material, like featurettes, deleted scenes or interviews
+	Extra Kind = "extra"
+	// Proof is a picture of the source disc some groups include
+	Proof Kind = "proof"
+	// Junk is never wanted, like site adverts, shortcuts and executables
+	Junk Kind = "junk"
+	// Other is everything else, like subtitles, .nfo files and artwork, which may still be placed as sidecars
+	Other Kind = "other"
+)
+
+var Kinds = []Kind{Media, Sample, Trailer, Extra, Proof, Junk, Other}
+
+// sampleRatio is how small compared to the largest video in a payload a video has to be to count as a sample
+const sampleRatio = 0.05
+
+var (
+	// folderKinds are directory names, anywhere in the payload, that say what the files inside them are
+	folderKinds = map[string]Kind{
+		"sample": Sample, "samples": Sample,
+		"trailer": Trailer, "trailers": Trailer,
+		"extra": Extra, "extras": Extra, "featurette": Extra, "featurettes": Extra, "bonus": Extra,
+		"behind the scenes": Extra, "deleted scenes": Extra, "interviews": Extra, "scenes": Extra, "shorts": Extra,
+		"proof": Proof, "proofs": Proof,
+	}
+
+	sampleName  = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])sample(?:$|[^a-z0-9])`)
+	trailerName = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:trailer|teaser)(?:$|[^a-z0-9])`)
+	extraName   = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:featurette|behind[ ._-]the[ ._-]scenes|deleted[ ._-]scenes?|interview|making[ ._-]of|bonus)(?:$|[^a-z0-9])`)
+	proofName   = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])proof(?:$|[^a-z0-9])`)
+
+	junkNames      = map[string]bool{"thumbs.db": true, ".ds_store": true, "desktop.ini": true}
+	junkExtensions = map[string]bool{".exe": true, ".url": true, ".lnk": true, ".bat": true, ".scr": true, ".torrent": true, ".!qb": true, ".part": true}
+	// junkAdverts are text files sites add to every release, like RARBG.txt
+	junkAdverts = regexp.MustCompile(`(?i)^(?:rarbg|(?:torrents? ?)?downloaded ?from|www\.).*\.txt$`)
+
+	videoExtensions = map[string]bool{
+		".mkv": true, ".avi": true, ".mp4": true, ".m4v": true, ".mov": true, ".wmv": true, ".mpg": true,
+		".mpeg": true, ".ts": true, ".m2ts": true, ".webm": true, ".flv": true,
+	}
+	imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true}
+)
+
+// IsKind returns true if kind is one of Kinds
+func IsKind(kind Kind) bool {
+	for _, known := range Kinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify works out what every file in a payload is. files maps paths to sizes, and roots are the directories
+// they're under, like the payload and where its archives were extracted, so only folders within them count. Videos
+// smaller than minSize are samples. If nothing turns out to be media, the largest video is, so a payload that's only
+// a trailer still has something to place
+func Classify(files map[string]int64, minSize int64, roots ...string) map[string]Kind {
+	var largestSize int64 = -1
+	for file, size := range files {
+		if videoExtensions[strings.ToLower(path.Ext(file))] && size > largestSize {
+			largestSize = size
+		}
+	}
+
+	kinds := make(map[string]Kind, len(files))
+	foundMedia := false
+	var largest string
+	for file, size := range files {
+		kind := classify(RelativePath(file, roots...), size, largestSize, minSize)
+		kinds[file] = kind
+		foundMedia = foundMedia || kind == Media
+		if size == largestSize && kind != Junk && videoExtensions[strings.ToLower(path.Ext(file))] {
+			largest = file
+		}
+	}
+	if !foundMedia && largest != "" {
+		kinds[largest] = Media
+	}
+	return kinds
+}
+
+func classify(relPath string, size int64, largestSize int64, minSize int64) Kind {
+	name := path.Base(relPath)
+	ext := strings.ToLower(path.Ext(name))
+	if junkNames[strings.ToLower(name)] || junkExtensions[ext] || junkAdverts.MatchString(name) {
+		return Junk
+	}
+
+	folderKind := Kind("")
+	for _, dir := range strings.Split(path.Dir(relPath), "/") {
+		if kind, ok := folderKinds[strings.ToLower(dir)]; ok {
+			folderKind = kind
+		}
+	}
+
+	if imageExtensions[ext] && (folderKind == Proof || proofName.MatchString(name)) {
+		return Proof
+	}
+	isVideo := videoExtensions[ext]
+	if !util.IsMediaFile(name) {
+		return Other
+	}
+
+	switch {
+	case folderKind != "" && folderKind != Proof:
+		return folderKind
+	case sampleName.MatchString(name):
+		return Sample
+	case trailerName.MatchString(name):
+		return Trailer
+	case extraName.MatchString(name):
+		return Extra
+	case isVideo && minSize > 0 && size < minSize:
+		return Sample
+	case isVideo && largestSize > 0 && float64(size) < float64(largestSize)*sampleRatio:
+		return Sample
+	}
+	return Media
+}
+
+// RelativePath is file's path within the first of roots that holds it, using '/'. Files under none of them are
+// just their name
+func RelativePath(file string, roots ...string) string {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, file)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path.Base(filepath.ToSlash(file))
+}
+
+// Size is a number of bytes read from strings like "50MB" or "1.5GB". Plain numbers are bytes
+type Size int64
+
+var sizePattern = regexp.MustCompile(`(?i)^\s*([0-9]+(?:\.[0-9]+)?)\s*([kmgt]i?b?|b)?\s*$`)
+
+// ParseSize reads a size like "50MB". Units are powers of 1024
+func ParseSize(value string) (Size, error) {
+	match := sizePattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	number, _ := strconv.ParseFloat(match[1], 64)
+	unit := strings.ToLower(match[2])
+	if unit != "" {
+		switch unit[0] {
+		case 'k':
+			number *= 1 << 10
+		case 'm':
+			number *= 1 << 20
+		case 'g':
+			number *= 1 << 30
+		case 't':
+			number *= 1 << 40
+		}
+	}
+	return Size(number), nil
+}
+
+func (s *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
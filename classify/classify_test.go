@@ -0,0 +1,95 @@
+package classify
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+const mb = 1 << 20
+
+func TestClassify(t *testing.T) {
+
+	Convey("Test a movie payload", t, func() {
+		kinds := Classify(map[string]int64{
+			"p/Movie.2010.1080p-GRP.mkv":                    4000 * mb,
+			"p/Sample/movie.2010.1080p-grp.sample.mkv":      40 * mb,
+			"p/movie-sample.mkv":                            30 * mb,
+			"p/Movie.2010.Trailer.mkv":                      90 * mb,
+			"p/Featurettes/Making the Movie.mkv":            600 * mb,
+			"p/Extras/Bloopers.mkv":                         300 * mb,
+			"p/Movie.2010.Behind.The.Scenes.mkv":            800 * mb,
+			"p/Proof/proof.jpg":                             1 * mb,
+			"p/movie-proof.png":                             1 * mb,
+			"p/poster.jpg":                                  1 * mb,
+			"p/movie.en.srt":                                1,
+			"p/RARBG.txt":                                   1,
+			"p/RARBG_DO_NOT_MIRROR.exe":                     1,
+			"p/Torrent Downloaded From ExtraTorrent.cc.txt": 1,
+			"p/Thumbs.db":                                   1,
+			"p/Movie.2010.iso":                              8000 * mb,
+		}, 0, "p")
+
+		So(kinds["p/Movie.2010.1080p-GRP.mkv"], ShouldEqual, Media)
+		So(kinds["p/Sample/movie.2010.1080p-grp.sample.mkv"], ShouldEqual, Sample)
+		So(kinds["p/movie-sample.mkv"], ShouldEqual, Sample)
+		So(kinds["p/Movie.2010.Trailer.mkv"], ShouldEqual, Trailer)
+		So(kinds["p/Featurettes/Making the Movie.mkv"], ShouldEqual, Extra)
+		So(kinds["p/Extras/Bloopers.mkv"], ShouldEqual, Extra)
+		So(kinds["p/Movie.2010.Behind.The.Scenes.mkv"], ShouldEqual, Extra)
+		So(kinds["p/Proof/proof.jpg"], ShouldEqual, Proof)
+		So(kinds["p/movie-proof.png"], ShouldEqual, Proof)
+		So(kinds["p/poster.jpg"], ShouldEqual, Other)
+		So(kinds["p/movie.en.srt"], ShouldEqual, Other)
+		So(kinds["p/RARBG.txt"], ShouldEqual, Junk)
+		So(kinds["p/RARBG_DO_NOT_MIRROR.exe"], ShouldEqual, Junk)
+		So(kinds["p/Torrent Downloaded From ExtraTorrent.cc.txt"], ShouldEqual, Junk)
+		So(kinds["p/Thumbs.db"], ShouldEqual, Junk)
+		So(kinds["p/Movie.2010.iso"], ShouldEqual, Other)
+	})
+
+	Convey("Test small videos are samples", t, func() {
+		kinds := Classify(map[string]int64{"p/movie.mkv": 2000 * mb, "p/clip.mkv": 50 * mb, "p/bigger clip.mkv": 150 * mb}, 0, "p")
+		So(kinds["p/clip.mkv"], ShouldEqual, Sample)
+		So(kinds["p/bigger clip.mkv"], ShouldEqual, Media)
+
+		kinds = Classify(map[string]int64{"p/movie.mkv": 2000 * mb, "p/bigger clip.mkv": 150 * mb}, 200*mb, "p")
+		So(kinds["p/bigger clip.mkv"], ShouldEqual, Sample)
+	})
+
+	Convey("Test audio is never a sample by size", t, func() {
+		kinds := Classify(map[string]int64{"p/01.flac": 40 * mb, "p/02 - Intro.flac": 1 * mb, "p/video.mkv": 500 * mb}, 0, "p")
+		So(kinds["p/02 - Intro.flac"], ShouldEqual, Media)
+	})
+
+	Convey("Test the largest video is media when nothing else is", t, func() {
+		kinds := Classify(map[string]int64{"p/Movie.Trailer.1080p.mkv": 90 * mb, "p/movie.nfo": 1}, 0, "p")
+		So(kinds["p/Movie.Trailer.1080p.mkv"], ShouldEqual, Media)
+
+		kinds = Classify(map[string]int64{"p/Extras/Making Of.mkv": 900 * mb, "p/movie.mkv": 800 * mb}, 0, "p")
+		So(kinds["p/Extras/Making Of.mkv"], ShouldEqual, Extra)
+	})
+
+	Convey("Test only folders within the roots count", t, func() {
+		kinds := Classify(map[string]int64{
+			"complete/Sample Movie/Sample Movie.mkv":    2000 * mb,
+			"complete/Sample Movie/sample.mkv":          20 * mb,
+			"staging/Sample Movie/Extras/interview.mkv": 700 * mb,
+		}, 0, "complete/Sample Movie", "staging/Sample Movie")
+
+		So(kinds["complete/Sample Movie/Sample Movie.mkv"], ShouldEqual, Media)
+		So(kinds["complete/Sample Movie/sample.mkv"], ShouldEqual, Sample)
+		So(kinds["staging/Sample Movie/Extras/interview.mkv"], ShouldEqual, Extra)
+		So(RelativePath("staging/Sample Movie/Extras/interview.mkv", "complete/Sample Movie", "staging/Sample Movie"), ShouldEqual, "Extras/interview.mkv")
+		So(RelativePath("elsewhere/file.mkv", "complete"), ShouldEqual, "file.mkv")
+	})
+
+	Convey("Test sizes", t, func() {
+		for value, expected := range map[string]Size{"50MB": 50 * mb, "50m": 50 * mb, "1.5GiB": 1536 * mb, "2048": 2048, "10 kb": 10240} {
+			size, err := ParseSize(value)
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, expected)
+		}
+		_, err := ParseSize("lots")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	TypeTransmission = "transmission"
+	TypeQBittorrent  = "qbittorrent"
+)
+
+var Types = []string{TypeTransmission, TypeQBittorrent}
+
+// AddOptions control where an added torrent is downloaded to and how it is labelled
+type AddOptions struct {
+	// Category becomes the torrent's category or label. Empty leaves it unset
+	Category string
+	// SavePath is the directory the client downloads the payload into. Empty uses the client's default
+	SavePath string
+}
+
+// Torrent is the client's view of a torrent
+type Torrent struct {
+	InfoHash string
+	// Name is the torrent's payload file or directory in SavePath
+	Name     string
+	SavePath string
+	// Progress is how much of the wanted data has been downloaded, from 0 to 1
+	Progress float64
+	// Done is true once every wanted file has been downloaded
+	Done bool
+}
+
+// Client hands torrents straight to a torrent client and asks it how they're getting on
+type Client interface {
+	Name() string
+	// AddTorrent adds the contents of a .torrent file, returning its lower case hex infohash
+	AddTorrent(data []byte, options AddOptions) (string, error)
+	// AddMagnet adds a magnet link, returning its lower case hex infohash
+	AddMagnet(uri string, options AddOptions) (string, error)
+	// Torrents returns the state of every torrent in infoHashes the client has, by lower case hex infohash. Those it
+	// doesn't have are left out
+	Torrents(infoHashes []string) (map[string]*Torrent, error)
+}
+
+// Config describes the client to use
+type Config struct {
+	// Type is one of Types. Empty means torrents are handed over through the drop dir instead
+	Type string `yaml:"type"`
+	// URL is the client's address, e.g. http://localhost:9091/transmission/rpc or http://localhost:8080
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// timeout bounds every request to the client
+var timeout = time.Second * 30
+
+// New creates the client described by cfg, or returns nil if no type is set
+func New(cfg Config) (Client, error) {
+	if cfg.Type == "" {
+		return nil, nil
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("client %v needs a url", cfg.Type)
+	}
+
+	switch cfg.Type {
+	case TypeTransmission:
+		return newTransmission(cfg), nil
+	case TypeQBittorrent:
+		return newQBittorrent(cfg)
+	}
+	return nil, fmt.Errorf("client has unknown type %q, must be one of %v", cfg.Type, strings.Join(Types, ", "))
+}
+
+// checkResponse fails on any non 2xx response, closing its body
+func checkResponse(response *http.Response) error {
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return fmt.Errorf("%v responded %v", response.Request.URL.Path, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/magnet"
+	"github.com/MondayHopscotch/SuperScope/torrent"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// qBittorrent talks to qBittorrent's Web API, logging in with a session cookie
+type qBittorrent struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+type qBittorrentTorrent struct {
+	Hash     string  `json:"hash"`
+	Name     string  `json:"name"`
+	SavePath string  `json:"save_path"`
+	Progress float64 `json:"progress"`
+	Left     int64   `json:"amount_left"`
+}
+
+func newQBittorrent(cfg Config) (*qBittorrent, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &qBittorrent{
+		url:        strings.TrimSuffix(cfg.URL, "/"),
+		username:   cfg.Username,
+		password:   cfg.Password,
+		httpClient: &http.Client{Timeout: timeout, Jar: jar},
+	}, nil
+}
+
+func (q *qBittorrent) Name() string {
+	return TypeQBittorrent
+}
+
+// AddTorrent uploads data. qBittorrent doesn't report what it added, so the infohash is read from data
+func (q *qBittorrent) AddTorrent(data []byte, options AddOptions) (string, error) {
+	meta, err := torrent.Parse(data)
+	if err != nil {
+		return "", err
+	}
+	err = q.add(options, func(form *multipart.Writer) error {
+		part, err := form.CreateFormFile("torrents", meta.Info.Name+".torrent")
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(data)
+		return err
+	})
+	return meta.InfoHash, err
+}
+
+func (q *qBittorrent) AddMagnet(uri string, options AddOptions) (string, error) {
+	link, err := magnet.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	err = q.add(options, func(form *multipart.Writer) error {
+		return form.WriteField("urls", uri)
+	})
+	return link.InfoHash, err
+}
+
+func (q *qBittorrent) add(options AddOptions, writeSource func(*multipart.Writer) error) error {
+	if options.Category != "" {
+		err := q.createCategory(options.Category)
+		if err != nil {
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	err := writeSource(form)
+	if err != nil {
+		return err
+	}
+	if options.SavePath != "" {
+		form.WriteField("savepath", options.SavePath)
+	}
+	if options.Category != "" {
+		form.WriteField("category", options.Category)
+	}
+	err = form.Close()
+	if err != nil {
+		return err
+	}
+
+	result, err := q.post("/api/v2/torrents/add", form.FormDataContentType(), body.Bytes())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(result) == "Fails." {
+		return errors.New("qbittorrent refused the torrent")
+	}
+	return nil
+}
+
+// createCategory makes sure category exists. qBittorrent answers 409 if it already does
+func (q *qBittorrent) createCategory(category string) error {
+	form := url.Values{"category": {category}}
+	_, err := q.post("/api/v2/torrents/createCategory", "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil && !strings.Contains(err.Error(), "409") {
+		return err
+	}
+	return nil
+}
+
+func (q *qBittorrent) Torrents(infoHashes []string) (map[string]*Torrent, error) {
+	found := make(map[string]*Torrent, len(infoHashes))
+	// asking for no hashes lists every torrent
+	if len(infoHashes) == 0 {
+		return found, nil
+	}
+	response, err := q.send(func() (*http.Request, error) {
+		hashes := url.QueryEscape(strings.Join(infoHashes, "|"))
+		return http.NewRequest(http.MethodGet, q.url+"/api/v2/torrents/info?hashes="+hashes, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	torrents := make([]qBittorrentTorrent, 0)
+	err = json.NewDecoder(response.Body).Decode(&torrents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read qbittorrent response: %v", err)
+	}
+	for _, torrent := range torrents {
+		infoHash := strings.ToLower(torrent.Hash)
+		found[infoHash] = &Torrent{
+			InfoHash: infoHash,
+			Name:     torrent.Name,
+			SavePath: torrent.SavePath,
+			Progress: torrent.Progress,
+			Done:     torrent.Progress >= 1 && torrent.Left == 0,
+		}
+	}
+	return found, nil
+}
+
+func (q *qBittorrent) post(path string, contentType string, body []byte) (string, error) {
+	response, err := q.send(func() (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodPost, q.url+path, bytes.NewReader(body))
+		if err == nil {
+			request.Header.Set("Content-Type", contentType)
+		}
+		return request, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	return string(result), err
+}
+
+// send makes a request, logging in and trying again if the session has expired
+func (q *qBittorrent) send(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		request, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		// qBittorrent rejects requests whose Referer doesn't match its own address
+		request.Header.Set("Referer", q.url)
+		response, err := q.httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusForbidden || attempt > 0 {
+			return response, checkResponse(response)
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+
+		err = q.login()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (q *qBittorrent) login() error {
+	form := url.Values{"username": {q.username}, "password": {q.password}}
+	request, err := http.NewRequest(http.MethodPost, q.url+"/api/v2/auth/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Referer", q.url)
+	response, err := q.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(result)) != "Ok." {
+		return errors.New("qbittorrent login failed, check the username and password")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeQBittorrent requires a login cookie, records added torrents and serves torrents from info
+type fakeQBittorrent struct {
+	logins     int
+	categories []string
+	added      []*http.Request
+	files      [][]byte
+	info       []qBittorrentTorrent
+	// hashes are the hashes each torrent list asked for
+	hashes []string
+}
+
+func (f *fakeQBittorrent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v2/auth/login" {
+		f.logins++
+		if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			w.Write([]byte("Fails."))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: "sid", Path: "/"})
+		w.Write([]byte("Ok."))
+		return
+	}
+	if cookie, err := r.Cookie("SID"); err != nil || cookie.Value != "sid" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v2/torrents/createCategory":
+		f.categories = append(f.categories, r.FormValue("category"))
+		w.WriteHeader(http.StatusConflict)
+	case "/api/v2/torrents/add":
+		r.ParseMultipartForm(1 << 20)
+		f.added = append(f.added, r)
+		if file, _, err := r.FormFile("torrents"); err == nil {
+			data, _ := ioutil.ReadAll(file)
+			f.files = append(f.files, data)
+		}
+		w.Write([]byte("Ok."))
+	case "/api/v2/torrents/info":
+		f.hashes = append(f.hashes, r.URL.Query().Get("hashes"))
+		json.NewEncoder(w).Encode(f.info)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestQBittorrent(t *testing.T) {
+
+	Convey("Test qbittorrent logs in and adds torrents", t, func() {
+		fake := &fakeQBittorrent{}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		c, err := New(Config{Type: TypeQBittorrent, URL: server.URL, Username: "admin", Password: "secret"})
+		So(err, ShouldBeNil)
+
+		data, _ := bencode.Encode(map[string]interface{}{
+			"info": map[string]interface{}{"name": "Film", "piece length": 16384, "pieces": "", "length": 10},
+		})
+		hash, err := c.AddTorrent(data, AddOptions{Category: "movies", SavePath: "/complete"})
+		So(err, ShouldBeNil)
+		So(hash, ShouldHaveLength, 40)
+		So(fake.logins, ShouldEqual, 1)
+		So(fake.categories, ShouldResemble, []string{"movies"})
+		So(fake.files, ShouldResemble, [][]byte{data})
+		So(fake.added[0].FormValue("savepath"), ShouldEqual, "/complete")
+		So(fake.added[0].FormValue("category"), ShouldEqual, "movies")
+
+		uri := "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Show"
+		hash, err = c.AddMagnet(uri, AddOptions{})
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+		So(fake.added[1].FormValue("urls"), ShouldEqual, uri)
+		So(fake.logins, ShouldEqual, 1)
+	})
+
+	Convey("Test qbittorrent reports torrent progress", t, func() {
+		fake := &fakeQBittorrent{info: []qBittorrentTorrent{{Hash: "abc", Name: "Film", SavePath: "/complete/", Progress: 1}}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		c, _ := New(Config{Type: TypeQBittorrent, URL: server.URL, Username: "admin", Password: "secret"})
+		torrents, err := c.Torrents([]string{"abc", "def"})
+		So(err, ShouldBeNil)
+		So(torrents, ShouldResemble, map[string]*Torrent{"abc": {InfoHash: "abc", Name: "Film", SavePath: "/complete/", Progress: 1, Done: true}})
+		So(fake.hashes, ShouldResemble, []string{"abc|def"})
+
+		fake.info = nil
+		torrents, err = c.Torrents([]string{"abc"})
+		So(err, ShouldBeNil)
+		So(torrents, ShouldBeEmpty)
+
+		// without any hashes qbittorrent would list everything, so it isn't asked at all
+		torrents, err = c.Torrents(nil)
+		So(err, ShouldBeNil)
+		So(torrents, ShouldBeEmpty)
+		So(fake.hashes, ShouldHaveLength, 2)
+	})
+
+	Convey("Test qbittorrent reports bad credentials", t, func() {
+		server := httptest.NewServer(&fakeQBittorrent{})
+		defer server.Close()
+
+		c, _ := New(Config{Type: TypeQBittorrent, URL: server.URL, Username: "admin", Password: "guess"})
+		_, err := c.Torrents([]string{"abc"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "login failed")
+	})
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const transmissionSessionHeader = "X-Transmission-Session-Id"
+
+// transmission talks to Transmission's JSON RPC
+type transmission struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	// sessionLock guards sessionID, which Transmission hands out to protect against CSRF
+	sessionLock sync.Mutex
+	sessionID   string
+}
+
+type transmissionRequest struct {
+	Method    string      `json:"method"`
+	Arguments interface{} `json:"arguments"`
+}
+
+type transmissionResponse struct {
+	Result    string          `json:"result"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type transmissionTorrent struct {
+	HashString    string  `json:"hashString"`
+	Name          string  `json:"name"`
+	DownloadDir   string  `json:"downloadDir"`
+	PercentDone   float64 `json:"percentDone"`
+	LeftUntilDone int64   `json:"leftUntilDone"`
+}
+
+func newTransmission(cfg Config) *transmission {
+	rpcURL := strings.TrimSuffix(cfg.URL, "/")
+	if parsed, err := url.Parse(rpcURL); err == nil && parsed.Path == "" {
+		rpcURL += "/transmission/rpc"
+	}
+	return &transmission{
+		url:        rpcURL,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (t *transmission) Name() string {
+	return TypeTransmission
+}
+
+func (t *transmission) AddTorrent(data []byte, options AddOptions) (string, error) {
+	return t.add(map[string]interface{}{"metainfo": base64.StdEncoding.EncodeToString(data)}, options)
+}
+
+func (t *transmission) AddMagnet(uri string, options AddOptions) (string, error) {
+	return t.add(map[string]interface{}{"filename": uri}, options)
+}
+
+func (t *transmission) add(arguments map[string]interface{}, options AddOptions) (string, error) {
+	if options.SavePath != "" {
+		arguments["download-dir"] = options.SavePath
+	}
+	if options.Category != "" {
+		arguments["labels"] = []string{options.Category}
+	}
+
+	var added struct {
+		Added     *transmissionTorrent `json:"torrent-added"`
+		Duplicate *transmissionTorrent `json:"torrent-duplicate"`
+	}
+	err := t.call("torrent-add", arguments, &added)
+	if err != nil {
+		return "", err
+	}
+	if added.Added != nil {
+		return strings.ToLower(added.Added.HashString), nil
+	}
+	if added.Duplicate != nil {
+		return strings.ToLower(added.Duplicate.HashString), nil
+	}
+	return "", errors.New("transmission did not report the added torrent")
+}
+
+func (t *transmission) Torrents(infoHashes []string) (map[string]*Torrent, error) {
+	found := make(map[string]*Torrent, len(infoHashes))
+	// asking for no ids lists every torrent
+	if len(infoHashes) == 0 {
+		return found, nil
+	}
+	arguments := map[string]interface{}{
+		"ids":    infoHashes,
+		"fields": []string{"hashString", "name", "downloadDir", "percentDone", "leftUntilDone"},
+	}
+	var listed struct {
+		Torrents []transmissionTorrent `json:"torrents"`
+	}
+	err := t.call("torrent-get", arguments, &listed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, torrent := range listed.Torrents {
+		infoHash := strings.ToLower(torrent.HashString)
+		found[infoHash] = &Torrent{
+			InfoHash: infoHash,
+			Name:     torrent.Name,
+			SavePath: torrent.DownloadDir,
+			Progress: torrent.PercentDone,
+			Done:     torrent.PercentDone >= 1 && torrent.LeftUntilDone == 0,
+		}
+	}
+	return found, nil
+}
+
+// call runs an RPC method, fetching a new session id and trying again if Transmission asks for one
+func (t *transmission) call(method string, arguments interface{}, result interface{}) error {
+	body, err := json.Marshal(transmissionRequest{Method: method, Arguments: arguments})
+	if err != nil {
+		return err
+	}
+
+	var response *http.Response
+	for attempt := 0; attempt < 2; attempt++ {
+		request, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if t.username != "" {
+			request.SetBasicAuth(t.username, t.password)
+		}
+		t.sessionLock.Lock()
+		request.Header.Set(transmissionSessionHeader, t.sessionID)
+		t.sessionLock.Unlock()
+
+		response, err = t.httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusConflict {
+			break
+		}
+		response.Body.Close()
+		t.sessionLock.Lock()
+		t.sessionID = response.Header.Get(transmissionSessionHeader)
+		t.sessionLock.Unlock()
+	}
+	err = checkResponse(response)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var decoded transmissionResponse
+	err = json.NewDecoder(response.Body).Decode(&decoded)
+	if err != nil {
+		return fmt.Errorf("unable to read transmission response: %v", err)
+	}
+	if decoded.Result != "success" {
+		return fmt.Errorf("transmission %v failed: %v", method, decoded.Result)
+	}
+	return json.Unmarshal(decoded.Arguments, result)
+}
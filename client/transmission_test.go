@@ -0,0 +1,124 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeTransmission answers torrent-add and torrent-get for a single torrent, demanding a session id first
+func fakeTransmission(requests *[]transmissionRequest, torrents map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/transmission/rpc" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get(transmissionSessionHeader) != "session" {
+			w.Header().Set(transmissionSessionHeader, "session")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var request transmissionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		*requests = append(*requests, request)
+
+		switch request.Method {
+		case "torrent-add":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"result":    "success",
+				"arguments": map[string]interface{}{"torrent-added": map[string]interface{}{"hashString": "ABCDEF", "name": "Film"}},
+			})
+		case "torrent-get":
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": "success", "arguments": torrents})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": "method name not recognized"})
+		}
+	}))
+}
+
+func TestTransmission(t *testing.T) {
+
+	Convey("Test clients are validated", t, func() {
+		c, err := New(Config{})
+		So(err, ShouldBeNil)
+		So(c, ShouldBeNil)
+
+		_, err = New(Config{Type: TypeTransmission})
+		So(err, ShouldNotBeNil)
+
+		_, err = New(Config{Type: "deluge", URL: "http://localhost"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, `unknown type "deluge"`)
+	})
+
+	Convey("Test transmission adds torrents with a label and save path", t, func() {
+		requests := make([]transmissionRequest, 0)
+		server := fakeTransmission(&requests, nil)
+		defer server.Close()
+
+		c, err := New(Config{Type: TypeTransmission, URL: server.URL, Username: "user", Password: "pass"})
+		So(err, ShouldBeNil)
+
+		hash, err := c.AddTorrent([]byte("d4:infoe"), AddOptions{Category: "movies", SavePath: "/complete"})
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, "abcdef")
+
+		So(requests, ShouldHaveLength, 1)
+		arguments := requests[0].Arguments.(map[string]interface{})
+		So(arguments["metainfo"], ShouldEqual, base64.StdEncoding.EncodeToString([]byte("d4:infoe")))
+		So(arguments["download-dir"], ShouldEqual, "/complete")
+		So(arguments["labels"], ShouldResemble, []interface{}{"movies"})
+
+		_, err = c.AddMagnet("magnet:?xt=urn:btih:abcdef", AddOptions{})
+		So(err, ShouldBeNil)
+		arguments = requests[1].Arguments.(map[string]interface{})
+		So(arguments["filename"], ShouldEqual, "magnet:?xt=urn:btih:abcdef")
+		So(arguments, ShouldNotContainKey, "labels")
+	})
+
+	Convey("Test transmission reports torrent progress", t, func() {
+		requests := make([]transmissionRequest, 0)
+		torrents := map[string]interface{}{"torrents": []interface{}{
+			map[string]interface{}{"hashString": "ABCDEF", "name": "Film", "downloadDir": "/complete", "percentDone": 0.5, "leftUntilDone": 100},
+		}}
+		server := fakeTransmission(&requests, torrents)
+		defer server.Close()
+
+		c, _ := New(Config{Type: TypeTransmission, URL: server.URL + "/transmission/rpc", Username: "user", Password: "pass"})
+		found, err := c.Torrents([]string{"abcdef", "012345"})
+		So(err, ShouldBeNil)
+		So(found, ShouldResemble, map[string]*Torrent{"abcdef": {InfoHash: "abcdef", Name: "Film", SavePath: "/complete", Progress: 0.5}})
+		So(requests[len(requests)-1].Arguments.(map[string]interface{})["ids"], ShouldResemble, []interface{}{"abcdef", "012345"})
+
+		torrents["torrents"] = []interface{}{
+			map[string]interface{}{"hashString": "ABCDEF", "name": "Film", "percentDone": 1, "leftUntilDone": 0},
+		}
+		found, err = c.Torrents([]string{"abcdef"})
+		So(err, ShouldBeNil)
+		So(found["abcdef"].Done, ShouldBeTrue)
+
+		torrents["torrents"] = []interface{}{}
+		found, err = c.Torrents([]string{"abcdef"})
+		So(err, ShouldBeNil)
+		So(found, ShouldBeEmpty)
+	})
+
+	Convey("Test transmission reports rpc failures", t, func() {
+		requests := make([]transmissionRequest, 0)
+		server := fakeTransmission(&requests, nil)
+		defer server.Close()
+
+		c, _ := New(Config{Type: TypeTransmission, URL: server.URL, Username: "user", Password: "wrong"})
+		_, err := c.Torrents([]string{"abcdef"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "401")
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/config"
+	"github.com/MondayHopscotch/SuperScope/control"
+	"io"
+	"os"
+)
+
+const completeUsage = `Usage: superscope complete [flags] <infohash|name> <path>
+
+Tells the running superscope that the torrent with the given infohash or original file name has finished
+downloading to path, an entry in the completed dir, so it is finalized without guessing from names. Meant to be
+run by a torrent client when a download finishes, e.g. from qBittorrent:
+
+    superscope complete "%I" "%F"
+
+`
+
+// runComplete implements the complete subcommand, returning the exit code
+func runComplete(args []string) int {
+	flags := flag.NewFlagSet("complete", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML config file to read the socket from")
+	socket := flags.String("socket", "", "Unix socket the daemon is listening on. Defaults to the config's socket, or superscope.sock in its state dir")
+	flags.Usage = func() {
+		io.WriteString(flags.Output(), completeUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	if *socket == "" {
+		cfg := config.Default()
+		if *configFile != "" {
+			var err error
+			cfg, err = config.Load(*configFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		*socket = cfg.SocketPath()
+	}
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "superscope complete: no socket to reach the daemon on, pass -socket or a config with a state dir")
+		return 1
+	}
+
+	err := control.Complete(*socket, flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "superscope complete:", err)
+		return 1
+	}
+	return 0
+}
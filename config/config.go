@@ -0,0 +1,268 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/client"
+	"github.com/MondayHopscotch/SuperScope/hooks"
+	"github.com/MondayHopscotch/SuperScope/library"
+	"github.com/MondayHopscotch/SuperScope/match"
+	"github.com/MondayHopscotch/SuperScope/routing"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MagnetFormatMagnet  = "magnet"
+	MagnetFormatTorrent = "torrent"
+)
+
+// Duration is a time.Duration read from strings like "30m" or "5s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+type Config struct {
+	RootDir      string `yaml:"root"`
+	DropDir      string `yaml:"drop"`
+	CompletedDir string `yaml:"complete"`
+	MediaDir     string `yaml:"media"`
+	StateDir     string `yaml:"state"`
+	// History is how long linked, failed and cancelled torrents are kept in the state dir. 0 keeps them forever
+	History Duration `yaml:"history"`
+	// StagingDir is where archives in completed payloads are extracted, leaving the originals to seed. Defaults to
+	// a hidden directory in the completed dir
+	StagingDir string `yaml:"staging"`
+
+	// ConsumeTimeout is how long we keep trying to move a new torrent into the drop dir
+	ConsumeTimeout Duration `yaml:"consume_timeout"`
+	// PollInterval is how often the completed dir is checked for finished downloads when its changes can't be
+	// watched, and how often the torrent client is asked about progress
+	PollInterval Duration `yaml:"poll_interval"`
+	// RescanInterval is how often the completed dir is rescanned while its changes are being watched, in case an
+	// event was missed
+	RescanInterval Duration `yaml:"rescan_interval"`
+	// MoveTimeout is how long we keep trying to move a completed file when the link mode is a move
+	MoveTimeout Duration `yaml:"move_timeout"`
+	// ShutdownTimeout is how long shutting down waits for torrents being consumed and payloads being placed
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+	// SettleWindow is how long a completed payload has to stop changing before it's finalized, so one still being
+	// copied in isn't linked half written. 0 finalizes payloads as soon as they're matched
+	SettleWindow Duration `yaml:"settle_window"`
+	// SettleMaxWait is how long we wait for a payload that keeps changing before finalizing it anyway. 0 waits forever
+	SettleMaxWait Duration `yaml:"settle_max_wait"`
+
+	// IgnorePrefixes are lower case file name prefixes that are never consumed, like the "new " of "New Folder"
+	IgnorePrefixes []string `yaml:"ignore_prefixes"`
+	// Categories are tried in order to decide how a completed payload is placed in the media dir
+	Categories []routing.Rule `yaml:"categories"`
+
+	// MatchThreshold is the lowest confidence, from 0 to 1, a completed entry needs to be taken as a torrent's payload
+	MatchThreshold float64 `yaml:"match_threshold"`
+	// MatchMargin is how far ahead of the runner up the best entry must be. Closer than that is reported as
+	// ambiguous instead of guessing
+	MatchMargin float64 `yaml:"match_margin"`
+
+	VerifyPieces bool   `yaml:"verify"`
+	MagnetFormat string `yaml:"magnet_format"`
+
+	// HTTPAddr is where the status API listens, e.g. "localhost:8080". Empty disables it
+	HTTPAddr string `yaml:"http_addr"`
+	// Socket is the unix socket commands like "superscope complete" reach the daemon on. See SocketPath for the default
+	Socket string `yaml:"socket"`
+
+	// Hooks are webhooks and commands run as torrents are consumed, completed and linked
+	Hooks []hooks.Hook `yaml:"hooks"`
+
+	// Libraries are media servers told to rescan the directories payloads are placed in
+	Libraries []library.Config `yaml:"libraries"`
+
+	// Client, when set, hands torrents straight to a torrent client and asks it when they are complete, instead of
+	// using the drop dir and matching names in the completed dir
+	Client client.Config `yaml:"client"`
+}
+
+// Default returns a config with every tunable set to its default. The directories still need to be filled in
+func Default() Config {
+	return Config{
+		ConsumeTimeout:  Duration(time.Minute * 30),
+		PollInterval:    Duration(time.Second * 5),
+		MoveTimeout:     Duration(time.Minute * 5),
+		ShutdownTimeout: Duration(time.Second * 30),
+		SettleWindow:    Duration(time.Second * 10),
+		SettleMaxWait:   Duration(time.Hour),
+		RescanInterval:  Duration(time.Minute),
+		History:         Duration(time.Hour * 24 * 7),
+		IgnorePrefixes:  []string{"new "},
+		Categories: []routing.Rule{
+			{Name: "tv", Dir: "tv", Strategy: routing.StrategyTV},
+			{Name: "movies", Dir: "movies", Strategy: routing.StrategyLargest},
+		},
+		MatchThreshold: 0.7,
+		MatchMargin:    0.1,
+		MagnetFormat:   MagnetFormatMagnet,
+	}
+}
+
+// Load reads a YAML config file over the top of the defaults. Categories in the file replace the default ones
+// entirely. It does not validate the result, as flags may still fill in missing values
+func Load(path string) (Config, error) {
+	cfg := Default()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Categories = nil
+	err = yaml.UnmarshalStrict(data, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to parse config %v: %v", path, err)
+	}
+	if cfg.Categories == nil {
+		cfg.Categories = Default().Categories
+	}
+	return cfg, nil
+}
+
+// Validate checks every setting and reports all of the problems found at once
+func (c Config) Validate() error {
+	problems := make([]string, 0)
+	required := []struct{ name, value string }{
+		{"root", c.RootDir},
+		{"complete", c.CompletedDir},
+		{"media", c.MediaDir},
+	}
+	if c.Client.Type == "" {
+		required = append(required, struct{ name, value string }{"drop", c.DropDir})
+	}
+	for _, dir := range required {
+		if dir.value == "" {
+			problems = append(problems, dir.name+" directory is required")
+		}
+	}
+
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"consume_timeout", c.ConsumeTimeout},
+		{"poll_interval", c.PollInterval},
+		{"rescan_interval", c.RescanInterval},
+		{"move_timeout", c.MoveTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value <= 0 {
+			problems = append(problems, duration.name+" must be greater than zero")
+		}
+	}
+
+	if c.History < 0 {
+		problems = append(problems, "history must not be negative")
+	}
+	if c.SettleWindow < 0 {
+		problems = append(problems, "settle_window must not be negative")
+	}
+	if c.SettleMaxWait < 0 {
+		problems = append(problems, "settle_max_wait must not be negative")
+	} else if c.SettleMaxWait > 0 && c.SettleMaxWait < c.SettleWindow {
+		problems = append(problems, "settle_max_wait must not be shorter than settle_window")
+	}
+
+	for _, prefix := range c.IgnorePrefixes {
+		if prefix == "" {
+			problems = append(problems, "ignore_prefixes may not contain an empty prefix")
+		}
+	}
+
+	if c.MatchThreshold <= 0 || c.MatchThreshold > 1 {
+		problems = append(problems, "match_threshold must be greater than 0 and at most 1")
+	}
+	if c.MatchMargin < 0 || c.MatchMargin >= 1 {
+		problems = append(problems, "match_margin must be at least 0 and less than 1")
+	}
+
+	_, err := c.Router()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	_, err = c.Dispatcher()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	_, err = c.Notifiers()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	_, err = c.TorrentClient()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.MagnetFormat != MagnetFormatMagnet && c.MagnetFormat != MagnetFormatTorrent {
+		problems = append(problems, fmt.Sprintf("magnet_format must be %v or %v, not %q", MagnetFormatMagnet, MagnetFormatTorrent, c.MagnetFormat))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Router compiles the categories into a routing.Router
+func (c Config) Router() (*routing.Router, error) {
+	return routing.NewRouter(c.Categories)
+}
+
+// Dispatcher compiles the hooks into a hooks.Dispatcher
+func (c Config) Dispatcher() (*hooks.Dispatcher, error) {
+	return hooks.NewDispatcher(c.Hooks)
+}
+
+// Notifiers creates a library.Notifier for each of the libraries
+func (c Config) Notifiers() ([]library.Notifier, error) {
+	return library.NewAll(c.Libraries)
+}
+
+// TorrentClient creates the configured client, or returns nil if torrents go through the drop dir
+func (c Config) TorrentClient() (client.Client, error) {
+	return client.New(c.Client)
+}
+
+// SocketPath is the control socket to use. Unless one is set it's superscope.sock in the state dir, which unlike a
+// shared temp dir other users can't create files in. Without a state dir it's "", disabling the socket
+func (c Config) SocketPath() string {
+	if c.Socket != "" || c.StateDir == "" {
+		return c.Socket
+	}
+	return filepath.Join(c.StateDir, "superscope.sock")
+}
+
+// Matcher returns a match.Matcher using the match settings
+func (c Config) Matcher() match.Matcher {
+	return match.Matcher{Threshold: c.MatchThreshold, Margin: c.MatchMargin}
+}
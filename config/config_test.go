@@ -0,0 +1,191 @@
+package config
+
+import (
+	"github.com/MondayHopscotch/SuperScope/classify"
+	"github.com/MondayHopscotch/SuperScope/client"
+	"github.com/MondayHopscotch/SuperScope/hooks"
+	"github.com/MondayHopscotch/SuperScope/library"
+	"github.com/MondayHopscotch/SuperScope/routing"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+
+	Convey("Test defaults need directories", t, func() {
+		err := Default().Validate()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "root directory is required")
+		So(err.Error(), ShouldContainSubstring, "media directory is required")
+	})
+
+	Convey("Test load config file", t, func() {
+		resetTestDir()
+		data := `
+root: /watch
+drop: /drop
+complete: /complete
+media: /media
+consume_timeout: 10m
+poll_interval: 1s
+rescan_interval: 10m
+shutdown_timeout: 1m
+history: 48h
+settle_window: 30s
+settle_max_wait: 2h
+ignore_prefixes: ["new ", "tmp"]
+categories:
+  - name: tv
+    dir: tv
+    strategy: folder
+    sidecars: []
+    kinds: [media, extra]
+    exclude: ["*.iso"]
+    min_size: 50MB
+  - name: music
+    regex: (?i)music|flac
+    strategy: media
+    destination: "music/{{.Name}}"
+    link: symlink
+verify: true
+magnet_format: torrent
+hooks:
+  - name: chat
+    url: http://localhost:9000/notify
+    events: [linked, failed]
+    body: '{"text": {{json .Name}}}'
+    retries: 3
+  - name: script
+    command: /usr/local/bin/on-event
+libraries:
+  - type: plex
+    url: http://localhost:32400
+    token: abc
+    path_from: /data/media
+    path_to: /media
+client:
+  type: transmission
+  url: http://localhost:9091/transmission/rpc
+  username: admin
+`
+		ioutil.WriteFile("test/superscope.yml", []byte(data), os.ModePerm)
+
+		cfg, err := Load("test/superscope.yml")
+		So(err, ShouldBeNil)
+		So(cfg.Validate(), ShouldBeNil)
+		So(cfg.RootDir, ShouldEqual, "/watch")
+		So(time.Duration(cfg.ConsumeTimeout), ShouldEqual, time.Minute*10)
+		So(time.Duration(cfg.PollInterval), ShouldEqual, time.Second)
+		So(time.Duration(cfg.RescanInterval), ShouldEqual, time.Minute*10)
+		So(time.Duration(cfg.MoveTimeout), ShouldEqual, time.Minute*5)
+		So(time.Duration(cfg.ShutdownTimeout), ShouldEqual, time.Minute)
+		So(time.Duration(cfg.History), ShouldEqual, time.Hour*48)
+		So(cfg.SocketPath(), ShouldEqual, "")
+		So(time.Duration(cfg.SettleWindow), ShouldEqual, time.Second*30)
+		So(time.Duration(cfg.SettleMaxWait), ShouldEqual, time.Hour*2)
+		So(cfg.IgnorePrefixes, ShouldResemble, []string{"new ", "tmp"})
+		So(len(cfg.Categories), ShouldEqual, 2)
+		So(cfg.Categories[0].Sidecars, ShouldNotBeNil)
+		So(cfg.Categories[0].Sidecars, ShouldBeEmpty)
+		So(cfg.Categories[1].Sidecars, ShouldBeNil)
+		So(cfg.Categories[0].Kinds, ShouldResemble, []classify.Kind{classify.Media, classify.Extra})
+		So(cfg.Categories[0].Exclude, ShouldResemble, []string{"*.iso"})
+		So(cfg.Categories[0].MinSize, ShouldEqual, 50<<20)
+		So(cfg.Categories[1].Name, ShouldEqual, "music")
+		So(cfg.Categories[1].Strategy, ShouldEqual, routing.StrategyMedia)
+		So(cfg.Categories[1].Destination, ShouldEqual, "music/{{.Name}}")
+		So(cfg.VerifyPieces, ShouldBeTrue)
+		So(cfg.MagnetFormat, ShouldEqual, MagnetFormatTorrent)
+		So(len(cfg.Hooks), ShouldEqual, 2)
+		So(cfg.Hooks[0].Events, ShouldResemble, []hooks.Event{hooks.EventLinked, hooks.EventFailed})
+		So(cfg.Hooks[0].Retries, ShouldEqual, 3)
+		So(cfg.Hooks[1].Command, ShouldEqual, "/usr/local/bin/on-event")
+		So(cfg.Client, ShouldResemble, client.Config{Type: client.TypeTransmission, URL: "http://localhost:9091/transmission/rpc", Username: "admin"})
+		So(cfg.Libraries, ShouldResemble, []library.Config{{Type: library.TypePlex, URL: "http://localhost:32400", Token: "abc", PathFrom: "/data/media", PathTo: "/media"}})
+	})
+
+	Convey("Test the socket defaults to the state dir", t, func() {
+		cfg := Default()
+		So(cfg.SocketPath(), ShouldEqual, "")
+
+		cfg.StateDir = "/var/lib/superscope"
+		So(cfg.SocketPath(), ShouldEqual, filepath.Join("/var/lib/superscope", "superscope.sock"))
+
+		cfg.Socket = "/run/superscope.sock"
+		So(cfg.SocketPath(), ShouldEqual, "/run/superscope.sock")
+	})
+
+	Convey("Test drop dir is not needed with a torrent client", t, func() {
+		cfg := validConfig()
+		cfg.DropDir = ""
+		So(cfg.Validate(), ShouldNotBeNil)
+
+		cfg.Client = client.Config{Type: client.TypeQBittorrent, URL: "http://localhost:8080"}
+		So(cfg.Validate(), ShouldBeNil)
+	})
+
+	Convey("Test load rejects unknown keys", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/superscope.yml", []byte("rooot: /watch\n"), os.ModePerm)
+
+		_, err := Load("test/superscope.yml")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test load rejects bad durations", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/superscope.yml", []byte("poll_interval: often\n"), os.ModePerm)
+
+		_, err := Load("test/superscope.yml")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test validate reports every problem", t, func() {
+		cfg := validConfig()
+		cfg.PollInterval = 0
+		cfg.SettleMaxWait = Duration(time.Second)
+		cfg.MatchThreshold = 1.5
+		cfg.MagnetFormat = "carrier pigeon"
+		cfg.Categories = append(cfg.Categories, routing.Rule{Name: "music", Dir: "music", Strategy: "shuffle"})
+		cfg.Hooks = []hooks.Hook{{Name: "nowhere"}}
+		cfg.Libraries = []library.Config{{Type: "kodi", URL: "http://localhost"}}
+		cfg.Client = client.Config{Type: "deluge", URL: "http://localhost"}
+
+		err := cfg.Validate()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "poll_interval")
+		So(err.Error(), ShouldContainSubstring, "settle_max_wait")
+		So(err.Error(), ShouldContainSubstring, "match_threshold")
+		So(err.Error(), ShouldContainSubstring, "magnet_format")
+		So(err.Error(), ShouldContainSubstring, "category music")
+		So(err.Error(), ShouldContainSubstring, "hook nowhere")
+		So(err.Error(), ShouldContainSubstring, "library kodi")
+		So(err.Error(), ShouldContainSubstring, `unknown type "deluge"`)
+	})
+
+	Convey("Test default categories route by watch subdirectory", t, func() {
+		router, err := validConfig().Router()
+		So(err, ShouldBeNil)
+		So(router.Route("tv/show.torrent").Name, ShouldEqual, "tv")
+		So(router.Route("movies/film.torrent").Strategy, ShouldEqual, routing.StrategyLargest)
+		So(router.Route("documentaries/tvnz/film.torrent").Name, ShouldEqual, "")
+	})
+}
+
+func validConfig() Config {
+	cfg := Default()
+	cfg.RootDir = "watch"
+	cfg.DropDir = "drop"
+	cfg.CompletedDir = "complete"
+	cfg.MediaDir = "media"
+	return cfg
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
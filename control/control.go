@@ -0,0 +1,157 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const commandComplete = "complete"
+
+// timeout bounds how long either end waits on the other
+var timeout = time.Second * 30
+
+// Handler carries out commands arriving on the control socket
+type Handler interface {
+	// CompletePayload finalizes the torrent with the given name or infohash using the payload at path, which is
+	// in the completed dir, instead of waiting for it to be matched. It returns once the payload is queued to be
+	// finalized, as placing it can take far longer than the caller waits for a reply
+	CompletePayload(key string, path string) error
+}
+
+type request struct {
+	Command string `json:"command"`
+	Key     string `json:"key"`
+	Path    string `json:"path"`
+}
+
+type response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Server listens on a unix socket for commands from other superscope processes
+type Server struct {
+	socket   string
+	listener net.Listener
+	handler  Handler
+	wg       sync.WaitGroup
+}
+
+// Listen starts serving commands on socket, which only our own user may connect to. A socket left behind by a
+// daemon that is no longer running is replaced, but one that still answers or belongs to another user is an error
+func Listen(socket string, handler Handler) (*Server, error) {
+	if stat, err := os.Lstat(socket); err == nil {
+		if !ownedByUs(stat) {
+			return nil, fmt.Errorf("%v belongs to another user, refusing to replace it", socket)
+		}
+		conn, err := net.DialTimeout("unix", socket, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%v is in use, is superscope already running?", socket)
+		}
+		os.Remove(socket)
+	}
+
+	listener, err := listenPrivate(socket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &Server{socket: socket, listener: listener, handler: handler}
+	s.wg.Add(1)
+	go s.serve()
+	log.Println("Control socket listening on ", socket)
+	return s, nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var req request
+	var resp response
+	err := json.NewDecoder(conn).Decode(&req)
+	if err == nil {
+		err = s.run(req)
+	}
+	if err != nil {
+		log.Println("Control command ", req.Command, " failed: ", err)
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (s *Server) run(req request) error {
+	switch req.Command {
+	case commandComplete:
+		log.Println("Told ", req.Key, " is complete at ", req.Path)
+		return s.handler.CompletePayload(req.Key, req.Path)
+	}
+	return fmt.Errorf("unknown command %q", req.Command)
+}
+
+// Close stops listening and removes the socket
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	os.Remove(s.socket)
+	return err
+}
+
+// Complete tells the daemon listening on socket that the torrent with the given name or infohash has finished
+// downloading to path
+func Complete(socket string, key string, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return send(socket, request{Command: commandComplete, Key: key, Path: path})
+}
+
+func send(socket string, req request) error {
+	if stat, err := os.Lstat(socket); err == nil && !ownedByUs(stat) {
+		return fmt.Errorf("%v belongs to another user, it isn't our superscope", socket)
+	}
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return fmt.Errorf("unable to reach superscope on %v: %v", socket, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return err
+	}
+	var resp response
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("no response from superscope: %v", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package control
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeHandler struct {
+	key  string
+	path string
+	err  error
+}
+
+func (f *fakeHandler) CompletePayload(key string, path string) error {
+	f.key = key
+	f.path = path
+	return f.err
+}
+
+func TestControl(t *testing.T) {
+
+	Convey("Test complete reaches the handler", t, func() {
+		resetTestDir()
+		handler := &fakeHandler{}
+		server, err := Listen("test/superscope.sock", handler)
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		err = Complete("test/superscope.sock", "c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "test/complete/Show")
+		So(err, ShouldBeNil)
+		So(handler.key, ShouldEqual, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+		expected, _ := filepath.Abs("test/complete/Show")
+		So(handler.path, ShouldEqual, expected)
+	})
+
+	Convey("Test handler errors are returned to the caller", t, func() {
+		resetTestDir()
+		server, err := Listen("test/superscope.sock", &fakeHandler{err: errors.New("It.torrent is not being tracked")})
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		err = Complete("test/superscope.sock", "It.torrent", "test/complete/It")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "It.torrent is not being tracked")
+	})
+
+	Convey("Test a running daemon's socket is not taken over", t, func() {
+		resetTestDir()
+		server, err := Listen("test/superscope.sock", &fakeHandler{})
+		So(err, ShouldBeNil)
+
+		_, err = Listen("test/superscope.sock", &fakeHandler{})
+		So(err, ShouldNotBeNil)
+
+		server.Close()
+		_, err = os.Stat("test/superscope.sock")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test a stale socket is replaced", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/superscope.sock", nil, os.ModePerm)
+
+		server, err := Listen("test/superscope.sock", &fakeHandler{})
+		So(err, ShouldBeNil)
+		server.Close()
+	})
+
+	Convey("Test only our own user can use the socket", t, func() {
+		resetTestDir()
+		server, err := Listen("test/superscope.sock", &fakeHandler{})
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		stat, err := os.Stat("test/superscope.sock")
+		So(err, ShouldBeNil)
+		So(stat.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+	})
+
+	Convey("Test a socket belonging to another user is left alone", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/superscope.sock", nil, os.ModePerm)
+		if os.Chown("test/superscope.sock", 12345, 12345) != nil {
+			// only root can give a file away
+			return
+		}
+
+		_, err := Listen("test/superscope.sock", &fakeHandler{})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "belongs to another user")
+		_, err = os.Stat("test/superscope.sock")
+		So(err, ShouldBeNil)
+
+		err = Complete("test/superscope.sock", "Up.torrent", "test/complete/Up")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "belongs to another user")
+	})
+
+	Convey("Test complete without a daemon", t, func() {
+		resetTestDir()
+		err := Complete("test/superscope.sock", "Up.torrent", "test/complete/Up")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unable to reach superscope")
+	})
+
+	os.RemoveAll("test")
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package control
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// ownedByUs returns true if the file described by stat belongs to the user we are running as
+func ownedByUs(stat os.FileInfo) bool {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	return !ok || int(sys.Uid) == os.Getuid()
+}
+
+// listenPrivate listens on socket, creating it with no access for anyone but our user so there's no moment another
+// user could connect. The umask is the whole process's, so files created meanwhile are private too, which is harmless
+func listenPrivate(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0077)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,229 @@
+package extract
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/nwaples/rardecode"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	Rar      Format = "rar"
+	Zip      Format = "zip"
+	SevenZip Format = "7z"
+)
+
+// ErrUnsupported is returned when extracting an archive we recognise but can't read, like 7z or a split zip
+var ErrUnsupported = errors.New("unsupported archive format")
+
+// Archive is a set of volumes that extract together. Path is the volume extraction starts from
+type Archive struct {
+	Path   string
+	Format Format
+	Parts  []string
+}
+
+var (
+	// newRarPart is a volume of a set named like Movie.part01.rar
+	newRarPart = regexp.MustCompile(`(?i)^(.*)\.part(\d+)\.rar$`)
+	// oldRarPart is a continuation volume of a set named like Movie.rar, Movie.r00, Movie.r01. Sets with more than
+	// 101 volumes carry on with .s00
+	oldRarPart = regexp.MustCompile(`(?i)^(.*)\.[rs](\d{2,3})$`)
+	rarFile    = regexp.MustCompile(`(?i)^(.*)\.rar$`)
+	zipFile    = regexp.MustCompile(`(?i)^(.*)\.zip$`)
+	// zipPart is a volume of a split zip, like Movie.z01, which we can't extract
+	zipPart = regexp.MustCompile(`(?i)^(.*)\.z(\d{2,3})$`)
+	// sevenZip is a 7z archive or a volume of a split one, like Movie.7z.001
+	sevenZip = regexp.MustCompile(`(?i)^(.*)\.7z(?:\.(\d{3}))?$`)
+)
+
+// IsPart returns true if name looks like any volume of an archive
+func IsPart(name string) bool {
+	base := filepath.Base(name)
+	for _, pattern := range []*regexp.Regexp{newRarPart, oldRarPart, rarFile, zipFile, zipPart, sevenZip} {
+		if pattern.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find groups files into archive sets. Volumes whose first part is missing are left out, as they can't be extracted
+func Find(files []string) []Archive {
+	sets := make(map[string]*Archive, 0)
+	add := func(key string, format Format, file string, first bool) {
+		set, ok := sets[key]
+		if !ok {
+			set = &Archive{Format: format}
+			sets[key] = set
+		}
+		set.Parts = append(set.Parts, file)
+		if first {
+			set.Path = file
+		}
+	}
+
+	for _, file := range files {
+		dir, base := filepath.Dir(file), filepath.Base(file)
+		if match := newRarPart.FindStringSubmatch(base); match != nil {
+			number, _ := strconv.Atoi(match[2])
+			add(filepath.Join(dir, match[1])+".part.rar", Rar, file, number == lowestPart(files, dir, match[1]))
+		} else if match := rarFile.FindStringSubmatch(base); match != nil {
+			add(filepath.Join(dir, match[1])+".rar", Rar, file, true)
+		} else if match := oldRarPart.FindStringSubmatch(base); match != nil {
+			add(filepath.Join(dir, match[1])+".rar", Rar, file, false)
+		} else if match := zipFile.FindStringSubmatch(base); match != nil {
+			add(filepath.Join(dir, match[1])+".zip", Zip, file, true)
+		} else if match := zipPart.FindStringSubmatch(base); match != nil {
+			add(filepath.Join(dir, match[1])+".zip", Zip, file, false)
+		} else if match := sevenZip.FindStringSubmatch(base); match != nil {
+			add(filepath.Join(dir, match[1])+".7z", SevenZip, file, match[2] == "" || match[2] == "001")
+		}
+	}
+
+	archives := make([]Archive, 0, len(sets))
+	for _, set := range sets {
+		if set.Path == "" {
+			log.Println("No first volume found for ", set.Parts, ", unable to extract it")
+			continue
+		}
+		sort.Strings(set.Parts)
+		archives = append(archives, *set)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Path < archives[j].Path
+	})
+	return archives
+}
+
+// lowestPart finds the first volume number of the Name.partN.rar set in dir, which is usually 1 but may be 0
+func lowestPart(files []string, dir string, name string) int {
+	lowest := -1
+	for _, file := range files {
+		if filepath.Dir(file) != dir {
+			continue
+		}
+		match := newRarPart.FindStringSubmatch(filepath.Base(file))
+		if match == nil || match[1] != name {
+			continue
+		}
+		number, _ := strconv.Atoi(match[2])
+		if lowest < 0 || number < lowest {
+			lowest = number
+		}
+	}
+	return lowest
+}
+
+// Extract unpacks archive into destDir and returns the paths of the files written. Entries that would land outside
+// destDir are refused
+func Extract(archive Archive, destDir string) ([]string, error) {
+	log.Println("Extracting ", archive.Path, " into ", destDir)
+	switch archive.Format {
+	case Rar:
+		return extractRar(archive.Path, destDir)
+	case Zip:
+		if len(archive.Parts) > 1 {
+			return nil, ErrUnsupported
+		}
+		return extractZip(archive.Path, destDir)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+func extractRar(path string, destDir string) ([]string, error) {
+	reader, err := rardecode.OpenReader(path, "")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	extracted := make([]string, 0)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return extracted, nil
+		} else if err != nil {
+			return extracted, fmt.Errorf("%v: %v", path, err)
+		}
+		if header.IsDir {
+			continue
+		}
+		dest, err := entryPath(destDir, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+		err = write(dest, reader)
+		if err != nil {
+			return extracted, fmt.Errorf("%v: %v", path, err)
+		}
+		extracted = append(extracted, dest)
+	}
+}
+
+func extractZip(path string, destDir string) ([]string, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	extracted := make([]string, 0)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		dest, err := entryPath(destDir, file.Name)
+		if err != nil {
+			return extracted, err
+		}
+		content, err := file.Open()
+		if err != nil {
+			return extracted, fmt.Errorf("%v: %v", path, err)
+		}
+		err = write(dest, content)
+		content.Close()
+		if err != nil {
+			return extracted, fmt.Errorf("%v: %v", path, err)
+		}
+		extracted = append(extracted, dest)
+	}
+	return extracted, nil
+}
+
+// entryPath is where an archive entry called name belongs under destDir
+func entryPath(destDir string, name string) (string, error) {
+	name = strings.Replace(name, "\\", "/", -1)
+	dest := filepath.Join(destDir, filepath.FromSlash(name))
+	if dest == filepath.Clean(destDir) || !strings.HasPrefix(dest, filepath.Clean(destDir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %v would be extracted outside of %v", name, destDir)
+	}
+	return dest, nil
+}
+
+func write(dest string, content io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
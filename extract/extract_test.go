@@ -0,0 +1,169 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+
+	Convey("Test archive sets are grouped by their first volume", t, func() {
+		archives := Find([]string{
+			"p/movie.part01.rar", "p/movie.part02.rar", "p/movie.part03.rar",
+			"p/CD2/show.rar", "p/CD2/show.r00", "p/CD2/show.r01",
+			"p/extras.zip",
+			"p/orphan.r05",
+			"p/music.7z.001", "p/music.7z.002",
+			"p/movie.mkv", "p/movie.nfo",
+		})
+
+		So(len(archives), ShouldEqual, 4)
+		So(archives[0], ShouldResemble, Archive{Path: "p/CD2/show.rar", Format: Rar, Parts: []string{"p/CD2/show.r00", "p/CD2/show.r01", "p/CD2/show.rar"}})
+		So(archives[1].Path, ShouldEqual, "p/extras.zip")
+		So(archives[2], ShouldResemble, Archive{Path: "p/movie.part01.rar", Format: Rar, Parts: []string{"p/movie.part01.rar", "p/movie.part02.rar", "p/movie.part03.rar"}})
+		So(archives[3].Format, ShouldEqual, SevenZip)
+	})
+
+	Convey("Test archive parts", t, func() {
+		So(IsPart("movie.part2.rar"), ShouldBeTrue)
+		So(IsPart("movie.R12"), ShouldBeTrue)
+		So(IsPart("movie.zip"), ShouldBeTrue)
+		So(IsPart("movie.7z"), ShouldBeTrue)
+		So(IsPart("movie.mkv"), ShouldBeFalse)
+		So(IsPart("rar.nfo"), ShouldBeFalse)
+	})
+
+	Convey("Test extracting a zip", t, func() {
+		resetTestDir()
+		writeZip("test/extras.zip", map[string]string{"featurette.mkv": "f", "docs/readme.txt": "r"})
+
+		files, err := Extract(Find([]string{"test/extras.zip"})[0], "test/out")
+		So(err, ShouldBeNil)
+		So(len(files), ShouldEqual, 2)
+
+		data, _ := ioutil.ReadFile("test/out/docs/readme.txt")
+		So(string(data), ShouldEqual, "r")
+	})
+
+	Convey("Test zip entries can not escape the destination", t, func() {
+		resetTestDir()
+		writeZip("test/evil.zip", map[string]string{"../../escaped.txt": "x"})
+
+		_, err := Extract(Archive{Path: "test/evil.zip", Format: Zip, Parts: []string{"test/evil.zip"}}, "test/out")
+		So(err, ShouldNotBeNil)
+		_, err = os.Stat("escaped.txt")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test extracting a rar", t, func() {
+		resetTestDir()
+		writeRar([]string{"test/movie.rar"}, "Movie/movie.mkv", []byte("the whole movie"))
+
+		files, err := Extract(Find([]string{"test/movie.rar"})[0], "test/out")
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{filepath.Join("test", "out", "Movie", "movie.mkv")})
+
+		data, _ := ioutil.ReadFile("test/out/Movie/movie.mkv")
+		So(string(data), ShouldEqual, "the whole movie")
+	})
+
+	Convey("Test extracting a multi volume rar", t, func() {
+		resetTestDir()
+		writeRar([]string{"test/movie.part1.rar", "test/movie.part2.rar", "test/movie.part3.rar"}, "movie.mkv", []byte("split across three volumes"))
+
+		archives := Find([]string{"test/movie.part3.rar", "test/movie.part2.rar", "test/movie.part1.rar"})
+		So(len(archives), ShouldEqual, 1)
+
+		_, err := Extract(archives[0], "test/out")
+		So(err, ShouldBeNil)
+		data, _ := ioutil.ReadFile("test/out/movie.mkv")
+		So(string(data), ShouldEqual, "split across three volumes")
+	})
+
+	Convey("Test extracting a rar with a missing volume fails", t, func() {
+		resetTestDir()
+		writeRar([]string{"test/movie.part1.rar", "test/movie.part2.rar"}, "movie.mkv", []byte("split across two volumes"))
+		os.Remove("test/movie.part2.rar")
+
+		_, err := Extract(Find([]string{"test/movie.part1.rar"})[0], "test/out")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test 7z is recognised but not extracted", t, func() {
+		_, err := Extract(Archive{Path: "test/music.7z", Format: SevenZip}, "test/out")
+		So(err, ShouldEqual, ErrUnsupported)
+	})
+}
+
+func writeZip(name string, files map[string]string) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for file, content := range files {
+		entry, _ := writer.Create(file)
+		entry.Write([]byte(content))
+	}
+	writer.Close()
+	ioutil.WriteFile(name, buf.Bytes(), os.ModePerm)
+}
+
+// writeRar stores content uncompressed in a RAR 1.5 archive, split evenly across one volume per name
+func writeRar(names []string, file string, content []byte) {
+	block := func(headType byte, flags uint16, fields []byte) []byte {
+		header := []byte{headType, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint16(header[1:], flags)
+		binary.LittleEndian.PutUint16(header[3:], uint16(7+len(fields)))
+		header = append(header, fields...)
+		crc := make([]byte, 2)
+		binary.LittleEndian.PutUint16(crc, uint16(crc32.ChecksumIEEE(header)))
+		return append(crc, header...)
+	}
+
+	var arcFlags uint16
+	if len(names) > 1 {
+		// a volume, using the name.partN.rar naming
+		arcFlags = 0x0011
+	}
+	chunk := (len(content) + len(names) - 1) / len(names)
+	for i, name := range names {
+		data := content[i*chunk:]
+		if len(data) > chunk {
+			data = data[:chunk]
+		}
+		var fileFlags, endFlags uint16 = 0x8000, 0
+		if i > 0 {
+			fileFlags |= 0x0001
+		}
+		if i < len(names)-1 {
+			fileFlags |= 0x0002
+			endFlags = 0x0001
+		}
+
+		fields := make([]byte, 25)
+		binary.LittleEndian.PutUint32(fields[0:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(fields[4:], uint32(len(content)))
+		binary.LittleEndian.PutUint32(fields[9:], crc32.ChecksumIEEE(content))
+		fields[17] = 20
+		fields[18] = 0x30
+		binary.LittleEndian.PutUint16(fields[19:], uint16(len(file)))
+		fields = append(fields, file...)
+
+		volume := []byte("Rar!\x1a\x07\x00")
+		volume = append(volume, block(0x73, arcFlags, make([]byte, 6))...)
+		volume = append(volume, block(0x74, fileFlags, fields)...)
+		volume = append(volume, data...)
+		volume = append(volume, block(0x7b, endFlags, nil)...)
+		ioutil.WriteFile(name, volume, os.ModePerm)
+	}
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
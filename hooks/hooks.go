@@ -0,0 +1,269 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Event is a point in a torrent's lifecycle that hooks can fire on
+type Event string
+
+const (
+	// EventConsumed fires when a torrent or magnet has been handed to the client
+	EventConsumed Event = "consumed"
+	// EventCompleted fires when a completed payload has been matched to a consumed torrent
+	EventCompleted Event = "completed"
+	// EventLinked fires when a payload has been placed in the media dir
+	EventLinked Event = "linked"
+	// EventFailed fires when a payload could not be placed in the media dir
+	EventFailed Event = "failed"
+)
+
+var Events = []Event{EventConsumed, EventCompleted, EventLinked, EventFailed}
+
+// Payload describes an event. It is the JSON body of webhooks without a Body template, the data for Body
+// templates, and is passed to commands as SUPERSCOPE_* environment variables
+type Payload struct {
+	Event       Event     `json:"event"`
+	Name        string    `json:"name"`
+	OrigPath    string    `json:"origPath"`
+	InfoHash    string    `json:"infoHash,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Completed   string    `json:"completed,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Hook is either a webhook, when URL is set, or a local executable, when Command is set
+type Hook struct {
+	Name string `yaml:"name"`
+	// Events the hook fires on. Empty fires on every event
+	Events []Event `yaml:"events"`
+
+	// URL receives a POST for every event
+	URL string `yaml:"url"`
+	// Body is a text/template for the request body, executed with the Payload. The json function quotes a value
+	// as JSON. Empty sends the Payload as JSON
+	Body string `yaml:"body"`
+	// Headers are added to the request. Content-Type defaults to application/json
+	Headers map[string]string `yaml:"headers"`
+
+	// Command is run for every event with the event data in its environment
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+
+	// Retries is how many more times a failed hook is tried, waiting twice as long before each attempt
+	Retries int `yaml:"retries"`
+}
+
+// timeout bounds a single attempt of a hook
+var timeout = time.Second * 30
+
+// backoff is how long to wait before the first retry
+var backoff = time.Second * 2
+
+// compiledHook is a validated Hook
+type compiledHook struct {
+	Hook
+	body *template.Template
+}
+
+// Dispatcher runs hooks in the background as events are fired
+type Dispatcher struct {
+	hooks  []*compiledHook
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// NewDispatcher validates hooks, reporting every problem found at once
+func NewDispatcher(hooks []Hook) (*Dispatcher, error) {
+	dispatcher := &Dispatcher{client: &http.Client{Timeout: timeout}}
+	problems := make([]string, 0)
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("#%v", i+1)
+		}
+		compiled := &compiledHook{Hook: hook}
+
+		if (hook.URL == "") == (hook.Command == "") {
+			problems = append(problems, fmt.Sprintf("hook %v must have exactly one of url or command", name))
+		}
+		for _, event := range hook.Events {
+			if !isEvent(event) {
+				problems = append(problems, fmt.Sprintf("hook %v has unknown event %q", name, event))
+			}
+		}
+		if hook.Retries < 0 {
+			problems = append(problems, fmt.Sprintf("hook %v may not have negative retries", name))
+		}
+		if hook.Body != "" {
+			var err error
+			compiled.body, err = template.New(name).Funcs(templateFuncs).Parse(hook.Body)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("hook %v has a bad body template: %v", name, err))
+			}
+		}
+		dispatcher.hooks = append(dispatcher.hooks, compiled)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return dispatcher, nil
+}
+
+func isEvent(event Event) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire runs every hook interested in payload's event in the background. Firing on a nil Dispatcher does nothing
+func (d *Dispatcher) Fire(payload Payload) {
+	if d == nil {
+		return
+	}
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+	for _, hook := range d.hooks {
+		if !hook.firesOn(payload.Event) {
+			continue
+		}
+		d.wg.Add(1)
+		go func(hook *compiledHook) {
+			defer d.wg.Done()
+			d.run(hook, payload)
+		}(hook)
+	}
+}
+
+// Wait blocks until every hook fired so far has finished
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+	d.wg.Wait()
+}
+
+func (h *compiledHook) firesOn(event Event) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// run tries hook until it succeeds or runs out of retries
+func (d *Dispatcher) run(hook *compiledHook, payload Payload) {
+	wait := backoff
+	var err error
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			log.Println("Retrying hook ", hook.Name, " for ", payload.Name, " in ", wait, ": ", err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if hook.URL != "" {
+			err = d.post(hook, payload)
+		} else {
+			err = execute(hook, payload)
+		}
+		if err == nil {
+			log.Println("Ran ", payload.Event, " hook ", hook.Name, " for ", payload.Name)
+			return
+		}
+	}
+	log.Println("Hook ", hook.Name, " failed for ", payload.Name, ": ", err)
+}
+
+func (d *Dispatcher) post(hook *compiledHook, payload Payload) error {
+	var body []byte
+	var err error
+	if hook.body != nil {
+		var buf bytes.Buffer
+		err = hook.body.Execute(&buf, payload)
+		body = buf.Bytes()
+	} else {
+		body, err = json.Marshal(payload)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to build request body: %v", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%v responded %v", hook.URL, response.Status)
+	}
+	return nil
+}
+
+func execute(hook *compiledHook, payload Payload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Env = append(os.Environ(), Environment(payload)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %v", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Environment returns payload as SUPERSCOPE_* variables in the form os/exec expects
+func Environment(payload Payload) []string {
+	return []string{
+		"SUPERSCOPE_EVENT=" + string(payload.Event),
+		"SUPERSCOPE_NAME=" + payload.Name,
+		"SUPERSCOPE_ORIG_PATH=" + payload.OrigPath,
+		"SUPERSCOPE_INFO_HASH=" + payload.InfoHash,
+		"SUPERSCOPE_DISPLAY_NAME=" + payload.DisplayName,
+		"SUPERSCOPE_COMPLETED=" + payload.Completed,
+		"SUPERSCOPE_CATEGORY=" + payload.Category,
+		"SUPERSCOPE_DESTINATION=" + payload.Destination,
+		"SUPERSCOPE_ERROR=" + payload.Error,
+		"SUPERSCOPE_TIME=" + payload.Time.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,140 @@
+package hooks
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	backoff = time.Millisecond
+
+	Convey("Test hooks are validated", t, func() {
+		_, err := NewDispatcher([]Hook{
+			{Name: "neither"},
+			{Name: "both", URL: "http://localhost", Command: "true"},
+			{Name: "event", URL: "http://localhost", Events: []Event{"deleted"}},
+			{Name: "template", URL: "http://localhost", Body: "{{.Name"},
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "neither must have exactly one of url or command")
+		So(err.Error(), ShouldContainSubstring, "both must have exactly one of url or command")
+		So(err.Error(), ShouldContainSubstring, `unknown event "deleted"`)
+		So(err.Error(), ShouldContainSubstring, "template has a bad body template")
+
+		dispatcher, err := NewDispatcher(nil)
+		So(err, ShouldBeNil)
+		dispatcher.Fire(Payload{Event: EventConsumed})
+	})
+
+	Convey("Test firing on a nil dispatcher", t, func() {
+		var dispatcher *Dispatcher
+		dispatcher.Fire(Payload{Event: EventConsumed})
+		dispatcher.Wait()
+	})
+
+	Convey("Test webhook posts the payload as JSON", t, func() {
+		server, bodies := recordingServer(0)
+		defer server.Close()
+
+		dispatcher, err := NewDispatcher([]Hook{{Name: "web", URL: server.URL, Events: []Event{EventLinked}}})
+		So(err, ShouldBeNil)
+
+		dispatcher.Fire(Payload{Event: EventConsumed, Name: "ignored.torrent"})
+		dispatcher.Fire(Payload{Event: EventLinked, Name: "show.torrent", Category: "tv", Destination: "media/tv"})
+		dispatcher.Wait()
+
+		So(*bodies, ShouldHaveLength, 1)
+		var payload Payload
+		So(json.Unmarshal([]byte((*bodies)[0]), &payload), ShouldBeNil)
+		So(payload.Event, ShouldEqual, EventLinked)
+		So(payload.Name, ShouldEqual, "show.torrent")
+		So(payload.Category, ShouldEqual, "tv")
+		So(payload.Time.IsZero(), ShouldBeFalse)
+	})
+
+	Convey("Test webhook body templates", t, func() {
+		server, bodies := recordingServer(0)
+		defer server.Close()
+
+		dispatcher, err := NewDispatcher([]Hook{{URL: server.URL, Body: `{"text": {{json (printf "%v was %v" .Name .Event)}}}`}})
+		So(err, ShouldBeNil)
+
+		dispatcher.Fire(Payload{Event: EventFailed, Name: `a "quoted" name`})
+		dispatcher.Wait()
+
+		So(*bodies, ShouldResemble, []string{`{"text": "a \"quoted\" name was failed"}`})
+	})
+
+	Convey("Test webhooks retry failures", t, func() {
+		server, bodies := recordingServer(2)
+		defer server.Close()
+
+		dispatcher, err := NewDispatcher([]Hook{{URL: server.URL, Retries: 2}})
+		So(err, ShouldBeNil)
+		dispatcher.Fire(Payload{Event: EventCompleted, Name: "flaky.torrent"})
+		dispatcher.Wait()
+		So(*bodies, ShouldHaveLength, 1)
+
+		server2, bodies2 := recordingServer(5)
+		defer server2.Close()
+
+		dispatcher, err = NewDispatcher([]Hook{{URL: server2.URL, Retries: 1}})
+		So(err, ShouldBeNil)
+		dispatcher.Fire(Payload{Event: EventCompleted, Name: "down.torrent"})
+		dispatcher.Wait()
+		So(*bodies2, ShouldBeEmpty)
+	})
+
+	Convey("Test command hooks receive the event in their environment", t, func() {
+		if runtime.GOOS == "windows" {
+			return
+		}
+		resetTestDir()
+
+		dispatcher, err := NewDispatcher([]Hook{{
+			Command: "sh",
+			Args:    []string{"-c", `echo "$SUPERSCOPE_EVENT $SUPERSCOPE_NAME $SUPERSCOPE_DESTINATION" > test/out`},
+		}})
+		So(err, ShouldBeNil)
+		dispatcher.Fire(Payload{Event: EventLinked, Name: "movie.torrent", Destination: "media/movies"})
+		dispatcher.Wait()
+
+		out, err := ioutil.ReadFile("test/out")
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(string(out)), ShouldEqual, "linked movie.torrent media/movies")
+	})
+
+	os.RemoveAll("test")
+}
+
+// recordingServer fails the first failures requests, then records the body of every request it accepts
+func recordingServer(failures int) (*httptest.Server, *[]string) {
+	var lock sync.Mutex
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	return server, &bodies
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
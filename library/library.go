@@ -0,0 +1,149 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	TypeJellyfin = "jellyfin"
+	TypeEmby     = "emby"
+	TypePlex     = "plex"
+)
+
+var Types = []string{TypeJellyfin, TypeEmby, TypePlex}
+
+// Notifier tells a media server that something new has been placed in a directory, so it can rescan just that
+// directory instead of waiting for its next scheduled scan
+type Notifier interface {
+	Name() string
+	Refresh(dir string) error
+}
+
+// Config describes a media server to notify
+type Config struct {
+	Name string `yaml:"name"`
+	// Type is one of Types
+	Type string `yaml:"type"`
+	// URL is the server's base address, e.g. http://localhost:8096
+	URL string `yaml:"url"`
+	// Token is the server's API key, or X-Plex-Token for Plex
+	Token string `yaml:"token"`
+	// Section is the Plex library section to refresh. Empty finds the section whose folder holds the directory
+	Section string `yaml:"section"`
+	// PathFrom and PathTo rewrite directories into the path the server sees them at, for when the server runs in a
+	// container or on another machine. A directory starting with PathFrom has it replaced by PathTo
+	PathFrom string `yaml:"path_from"`
+	PathTo   string `yaml:"path_to"`
+}
+
+// timeout bounds every request to a media server
+var timeout = time.Second * 30
+
+// New creates the notifier described by cfg
+func New(cfg Config) (Notifier, error) {
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Type
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("library %v needs a url", name)
+	}
+	base := server{
+		name:     name,
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		token:    cfg.Token,
+		pathFrom: cfg.PathFrom,
+		pathTo:   cfg.PathTo,
+		client:   &http.Client{Timeout: timeout},
+	}
+
+	switch cfg.Type {
+	case TypeJellyfin:
+		return &mediaBrowser{server: base, jellyfin: true}, nil
+	case TypeEmby:
+		return &mediaBrowser{server: base}, nil
+	case TypePlex:
+		return &plex{server: base, section: cfg.Section}, nil
+	}
+	return nil, fmt.Errorf("library %v has unknown type %q, must be one of %v", name, cfg.Type, strings.Join(Types, ", "))
+}
+
+// NewAll creates a notifier for every config, reporting every problem found at once
+func NewAll(cfgs []Config) ([]Notifier, error) {
+	notifiers := make([]Notifier, 0, len(cfgs))
+	problems := make([]string, 0)
+	for _, cfg := range cfgs {
+		notifier, err := New(cfg)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return notifiers, nil
+}
+
+// RefreshAll asks every notifier to refresh dir, logging any that fail
+func RefreshAll(notifiers []Notifier, dir string) {
+	for _, notifier := range notifiers {
+		err := notifier.Refresh(dir)
+		if err != nil {
+			log.Println("Unable to refresh ", notifier.Name(), " library for ", dir, ": ", err)
+			continue
+		}
+		log.Println("Asked ", notifier.Name(), " to refresh ", dir)
+	}
+}
+
+// server holds what every media server needs
+type server struct {
+	name     string
+	url      string
+	token    string
+	pathFrom string
+	pathTo   string
+	client   *http.Client
+}
+
+func (s *server) Name() string {
+	return s.name
+}
+
+// serverPath makes dir absolute and rewrites it into the server's view of the file system
+func (s *server) serverPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if s.pathFrom == "" {
+		return dir, nil
+	}
+	// only rewrite whole path elements, so /srv/media2 isn't taken to be inside /srv/media
+	from := strings.TrimSuffix(filepath.Clean(s.pathFrom), string(filepath.Separator))
+	if dir == from || strings.HasPrefix(dir, from+string(filepath.Separator)) {
+		dir = s.pathTo + dir[len(from):]
+	}
+	return dir, nil
+}
+
+// do sends request and fails on any non 2xx response
+func (s *server) do(request *http.Request) (*http.Response, error) {
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, fmt.Errorf("%v responded %v", request.URL.Path, response.Status)
+	}
+	return response, nil
+}
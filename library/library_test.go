@@ -0,0 +1,90 @@
+package library
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+
+	Convey("Test library configs are validated", t, func() {
+		_, err := NewAll([]Config{
+			{Name: "nowhere", Type: TypeJellyfin},
+			{Name: "kodi", Type: "kodi", URL: "http://localhost"},
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "library nowhere needs a url")
+		So(err.Error(), ShouldContainSubstring, `library kodi has unknown type "kodi"`)
+
+		notifiers, err := NewAll([]Config{{Type: TypeEmby, URL: "http://localhost:8096/"}})
+		So(err, ShouldBeNil)
+		So(notifiers, ShouldHaveLength, 1)
+		So(notifiers[0].Name(), ShouldEqual, TypeEmby)
+	})
+
+	Convey("Test paths are rewritten into the server's view", t, func() {
+		s := &server{pathFrom: "/data/media", pathTo: "/media"}
+		dir, err := s.serverPath("/data/media/tv/Show/Season 01")
+		So(err, ShouldBeNil)
+		So(dir, ShouldEqual, "/media/tv/Show/Season 01")
+
+		dir, err = s.serverPath("/elsewhere/tv")
+		So(err, ShouldBeNil)
+		So(dir, ShouldEqual, "/elsewhere/tv")
+
+		dir, err = s.serverPath("/data/media2/tv")
+		So(err, ShouldBeNil)
+		So(dir, ShouldEqual, "/data/media2/tv")
+
+		dir, err = s.serverPath("/data/media")
+		So(err, ShouldBeNil)
+		So(dir, ShouldEqual, "/media")
+
+		s.pathFrom = "/"
+		dir, err = s.serverPath("/data/media")
+		So(err, ShouldBeNil)
+		So(dir, ShouldEqual, "/media/data/media")
+	})
+
+	Convey("Test jellyfin and emby are told about the updated directory", t, func() {
+		var updates mediaUpdates
+		var headers http.Header
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/Library/Media/Updated" {
+				http.NotFound(w, r)
+				return
+			}
+			headers = r.Header
+			json.NewDecoder(r.Body).Decode(&updates)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer stub.Close()
+
+		jellyfin, err := New(Config{Type: TypeJellyfin, URL: stub.URL, Token: "secret", PathFrom: "/data", PathTo: "/srv"})
+		So(err, ShouldBeNil)
+		So(jellyfin.Refresh("/data/movies/Film"), ShouldBeNil)
+		So(updates.Updates, ShouldResemble, []mediaUpdate{{Path: "/srv/movies/Film", UpdateType: "Created"}})
+		So(headers.Get("Authorization"), ShouldEqual, `MediaBrowser Token="secret"`)
+
+		emby, err := New(Config{Type: TypeEmby, URL: stub.URL, Token: "secret"})
+		So(err, ShouldBeNil)
+		So(emby.Refresh("/data/tv/Show"), ShouldBeNil)
+		So(updates.Updates[0].Path, ShouldEqual, "/data/tv/Show")
+		So(headers.Get("X-Emby-Token"), ShouldEqual, "secret")
+	})
+
+	Convey("Test refresh reports server errors", t, func() {
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer stub.Close()
+
+		jellyfin, _ := New(Config{Type: TypeJellyfin, URL: stub.URL})
+		err := jellyfin.Refresh("/data/movies")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "401")
+	})
+}
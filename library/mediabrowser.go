@@ -0,0 +1,52 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// mediaBrowser notifies Jellyfin and Emby, which share the media updated API they inherited from Media Browser
+type mediaBrowser struct {
+	server
+	jellyfin bool
+}
+
+type mediaUpdate struct {
+	Path       string `json:"Path"`
+	UpdateType string `json:"UpdateType"`
+}
+
+type mediaUpdates struct {
+	Updates []mediaUpdate `json:"Updates"`
+}
+
+func (m *mediaBrowser) Refresh(dir string) error {
+	serverDir, err := m.serverPath(dir)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(mediaUpdates{Updates: []mediaUpdate{{Path: serverDir, UpdateType: "Created"}}})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, m.url+"/Library/Media/Updated", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if m.jellyfin {
+		request.Header.Set("Authorization", fmt.Sprintf("MediaBrowser Token=%q", m.token))
+	} else {
+		request.Header.Set("X-Emby-Token", m.token)
+	}
+
+	response, err := m.do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
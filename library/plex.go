@@ -0,0 +1,97 @@
+package library
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// plex notifies Plex, which refreshes a single library section, optionally limited to one path in it
+type plex struct {
+	server
+	section string
+}
+
+type plexSections struct {
+	Directories []struct {
+		Key       string `xml:"key,attr"`
+		Locations []struct {
+			Path string `xml:"path,attr"`
+		} `xml:"Location"`
+	} `xml:"Directory"`
+}
+
+func (p *plex) Refresh(dir string) error {
+	serverDir, err := p.serverPath(dir)
+	if err != nil {
+		return err
+	}
+
+	section := p.section
+	if section == "" {
+		section, err = p.findSection(serverDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := url.Values{"path": {serverDir}}
+	request, err := p.request(fmt.Sprintf("/library/sections/%v/refresh?%v", url.PathEscape(section), query.Encode()))
+	if err != nil {
+		return err
+	}
+	response, err := p.do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// findSection returns the key of the section with the most specific folder holding dir
+func (p *plex) findSection(dir string) (string, error) {
+	request, err := p.request("/library/sections")
+	if err != nil {
+		return "", err
+	}
+	response, err := p.do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var sections plexSections
+	err = xml.NewDecoder(response.Body).Decode(&sections)
+	if err != nil {
+		return "", fmt.Errorf("unable to read library sections: %v", err)
+	}
+
+	key := ""
+	longest := -1
+	for _, directory := range sections.Directories {
+		for _, location := range directory.Locations {
+			folder := strings.TrimRight(location.Path, "/\\")
+			if dir != folder && !strings.HasPrefix(dir, folder+"/") && !strings.HasPrefix(dir, folder+"\\") {
+				continue
+			}
+			if len(folder) > longest {
+				key, longest = directory.Key, len(folder)
+			}
+		}
+	}
+	if key == "" {
+		return "", fmt.Errorf("no library section holds %v", dir)
+	}
+	return key, nil
+}
+
+func (p *plex) request(path string) (*http.Request, error) {
+	request, err := http.NewRequest(http.MethodGet, p.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Plex-Token", p.token)
+	return request, nil
+}
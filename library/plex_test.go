@@ -0,0 +1,70 @@
+package library
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const plexSectionsXML = `<?xml version="1.0" encoding="UTF-8"?>
+<MediaContainer size="3">
+  <Directory key="1" type="movie" title="Movies">
+    <Location id="1" path="/media/movies" />
+  </Directory>
+  <Directory key="2" type="show" title="TV">
+    <Location id="2" path="/media/tv" />
+    <Location id="3" path="/archive/tv" />
+  </Directory>
+  <Directory key="3" type="show" title="Kids TV">
+    <Location id="4" path="/media/tv/kids/" />
+  </Directory>
+</MediaContainer>`
+
+func TestPlex(t *testing.T) {
+
+	refreshes := make([]*url.URL, 0)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Plex-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/library/sections" {
+			w.Write([]byte(plexSectionsXML))
+			return
+		}
+		refreshes = append(refreshes, r.URL)
+	}))
+	defer stub.Close()
+
+	Convey("Test plex refreshes the section holding the directory", t, func() {
+		refreshes = refreshes[:0]
+		notifier, err := New(Config{Type: TypePlex, URL: stub.URL, Token: "token"})
+		So(err, ShouldBeNil)
+
+		So(notifier.Refresh("/archive/tv/Show/Season 01"), ShouldBeNil)
+		So(notifier.Refresh("/media/tv/kids/Cartoon"), ShouldBeNil)
+		So(refreshes, ShouldHaveLength, 2)
+		So(refreshes[0].Path, ShouldEqual, "/library/sections/2/refresh")
+		So(refreshes[0].Query().Get("path"), ShouldEqual, "/archive/tv/Show/Season 01")
+		So(refreshes[1].Path, ShouldEqual, "/library/sections/3/refresh")
+	})
+
+	Convey("Test plex uses a configured section", t, func() {
+		refreshes = refreshes[:0]
+		notifier, _ := New(Config{Type: TypePlex, URL: stub.URL, Token: "token", Section: "7"})
+
+		So(notifier.Refresh("/anywhere/Film"), ShouldBeNil)
+		So(refreshes, ShouldHaveLength, 1)
+		So(refreshes[0].Path, ShouldEqual, "/library/sections/7/refresh")
+	})
+
+	Convey("Test plex fails when no section holds the directory", t, func() {
+		notifier, _ := New(Config{Type: TypePlex, URL: stub.URL, Token: "token"})
+
+		err := notifier.Refresh("/media/movies2/Film")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no library section holds")
+	})
+}
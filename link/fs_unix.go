@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package link
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameFilesystem returns true if a and b are on the same device, so they can be hardlinked or renamed
+func sameFilesystem(a string, b string) (bool, error) {
+	aStat, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bStat, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	aSys, aOk := aStat.Sys().(*syscall.Stat_t)
+	bSys, bOk := bStat.Sys().(*syscall.Stat_t)
+	if !aOk || !bOk {
+		return false, nil
+	}
+	return aSys.Dev == bSys.Dev, nil
+}
@@ -0,0 +1,19 @@
+package link
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// sameFilesystem returns true if a and b are on the same volume, so they can be hardlinked or renamed
+func sameFilesystem(a string, b string) (bool, error) {
+	aAbs, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	bAbs, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(filepath.VolumeName(aAbs), filepath.VolumeName(bAbs)), nil
+}
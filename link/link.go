@@ -0,0 +1,223 @@
+package link
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+type Mode string
+
+const (
+	// Symlink points at the completed payload, leaving it in place for seeding
+	Symlink Mode = "symlink"
+	// Hardlink shares the payload's data without a second copy. Falls back to Copy across filesystems
+	Hardlink Mode = "hardlink"
+	// Copy makes a full copy of the payload
+	Copy Mode = "copy"
+	// Move takes the payload out of the completed dir. This stops it seeding
+	Move Mode = "move"
+	// Reflink makes a copy-on-write clone where the filesystem supports it, and a plain copy otherwise
+	Reflink Mode = "reflink"
+)
+
+var Modes = []Mode{Symlink, Hardlink, Copy, Move, Reflink}
+
+// progressInterval is how often copies report how far along they are
+var progressInterval = time.Second * 10
+
+// partialSuffix marks a copy or directory that is still being built. It is only renamed into place once complete,
+// so a placement cut short never leaves a truncated payload at its destination
+const partialSuffix = ".partial"
+
+// DefaultMode is used when a category doesn't choose a mode. Symlinks need special privileges on Windows, so it
+// hardlinks there instead
+func DefaultMode() Mode {
+	if runtime.GOOS == "windows" {
+		return Hardlink
+	}
+	return Symlink
+}
+
+func IsMode(mode string) bool {
+	for _, known := range Modes {
+		if Mode(mode) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Place puts src, a file or directory, at dest using mode. Directories are recreated and filled file by file for
+// every mode except Symlink, which links the directory itself
+func Place(mode Mode, src string, dest string) error {
+	log.Println("Placing ", src, " at ", dest, " (", mode, ")")
+	switch mode {
+	case Symlink:
+		absSrc, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(absSrc, dest)
+	case Move:
+		return move(src, dest)
+	case Hardlink:
+		same, err := sameFilesystem(src, filepath.Dir(dest))
+		if err != nil {
+			return err
+		}
+		if !same {
+			log.Println(src, " and ", dest, " are on different filesystems, copying instead of hardlinking")
+			return walk(src, dest, copyFile)
+		}
+		return walk(src, dest, os.Link)
+	case Copy:
+		return walk(src, dest, copyFile)
+	case Reflink:
+		return walk(src, dest, reflinkFile)
+	default:
+		return fmt.Errorf("unknown link mode %q", mode)
+	}
+}
+
+// walk recreates src at dest, calling placeFile for every regular file. A directory is built beside dest and
+// renamed into place once every file is in it
+func walk(src string, dest string, placeFile func(string, string) error) error {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return placeFile(src, dest)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return &os.PathError{Op: "place", Path: dest, Err: os.ErrExist}
+	}
+
+	// anything already here was left by a placement that was cut short
+	partial := dest + partialSuffix
+	os.RemoveAll(partial)
+	err = filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(partial, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			log.Println("Skipping ", file, ": not a regular file")
+			return nil
+		}
+		return placeFile(file, target)
+	})
+	if err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	return commitPartial(partial, dest)
+}
+
+// move renames src to dest, copying and removing the original when they're on different filesystems
+func move(src string, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+
+	same, statErr := sameFilesystem(src, filepath.Dir(dest))
+	if statErr != nil || same {
+		return err
+	}
+
+	log.Println(src, " and ", dest, " are on different filesystems, copying then removing the original")
+	err = walk(src, dest, copyFile)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyFile copies src to a new file at dest, logging progress for large files
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := createPartial(dest, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	progress := &progressWriter{name: filepath.Base(src), total: stat.Size(), lastReport: time.Now()}
+	_, err = io.Copy(io.MultiWriter(out, progress), in)
+	return finishPartial(out, dest, err)
+}
+
+// createPartial creates the file a copy to dest is written to before it's renamed into place by finishPartial
+func createPartial(dest string, perm os.FileMode) (*os.File, error) {
+	if _, err := os.Lstat(dest); err == nil {
+		return nil, &os.PathError{Op: "open", Path: dest, Err: os.ErrExist}
+	}
+	return os.OpenFile(dest+partialSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// finishPartial closes out and renames it to dest if the copy into it succeeded, and removes it otherwise
+func finishPartial(out *os.File, dest string, err error) error {
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return commitPartial(out.Name(), dest)
+}
+
+// commitPartial renames a finished partial file or directory to dest, unless something has appeared there since
+func commitPartial(partial string, dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		os.RemoveAll(partial)
+		return &os.PathError{Op: "rename", Path: dest, Err: os.ErrExist}
+	}
+	return os.Rename(partial, dest)
+}
+
+type progressWriter struct {
+	name       string
+	total      int64
+	written    int64
+	lastReport time.Time
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.written += int64(len(data))
+	if time.Since(p.lastReport) >= progressInterval {
+		p.lastReport = time.Now()
+		percent := float64(100)
+		if p.total > 0 {
+			percent = float64(p.written) * 100 / float64(p.total)
+		}
+		log.Println(fmt.Sprintf("Copying %v: %v of %v bytes (%.1f%%)", p.name, p.written, p.total, percent))
+	}
+	return len(data), nil
+}
@@ -0,0 +1,147 @@
+package link
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+
+	Convey("Test symlink points at an absolute path", t, func() {
+		resetTestDir()
+
+		So(Place(Symlink, "test/complete/movie.avi", "test/media/movie.avi"), ShouldBeNil)
+
+		target, err := os.Readlink("test/media/movie.avi")
+		So(err, ShouldBeNil)
+		So(filepath.IsAbs(target), ShouldBeTrue)
+		So(readFile("test/media/movie.avi"), ShouldEqual, "movie")
+	})
+
+	Convey("Test hardlink shares the file", t, func() {
+		resetTestDir()
+
+		So(Place(Hardlink, "test/complete/movie.avi", "test/media/movie.avi"), ShouldBeNil)
+
+		src, _ := os.Stat("test/complete/movie.avi")
+		dest, _ := os.Stat("test/media/movie.avi")
+		So(os.SameFile(src, dest), ShouldBeTrue)
+	})
+
+	Convey("Test hardlink a directory", t, func() {
+		resetTestDir()
+
+		So(Place(Hardlink, "test/complete/show", "test/media/show"), ShouldBeNil)
+		So(readFile("test/media/show/Season 1/ep1.mkv"), ShouldEqual, "episode")
+	})
+
+	Convey("Test copy a directory", t, func() {
+		resetTestDir()
+
+		So(Place(Copy, "test/complete/show", "test/media/show"), ShouldBeNil)
+
+		src, _ := os.Stat("test/complete/show/Season 1/ep1.mkv")
+		dest, _ := os.Stat("test/media/show/Season 1/ep1.mkv")
+		So(os.SameFile(src, dest), ShouldBeFalse)
+		So(readFile("test/media/show/Season 1/ep1.mkv"), ShouldEqual, "episode")
+	})
+
+	Convey("Test copy refuses to overwrite", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/media/movie.avi", []byte("existing"), os.ModePerm)
+
+		So(Place(Copy, "test/complete/movie.avi", "test/media/movie.avi"), ShouldNotBeNil)
+		So(readFile("test/media/movie.avi"), ShouldEqual, "existing")
+	})
+
+	Convey("Test copy replaces what an interrupted copy left behind", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/media/movie.avi.partial", []byte("mov"), os.ModePerm)
+		os.MkdirAll("test/media/show.partial/Season 1", os.ModePerm)
+		ioutil.WriteFile("test/media/show.partial/Season 1/ep1.mkv", []byte("epi"), os.ModePerm)
+
+		So(Place(Copy, "test/complete/movie.avi", "test/media/movie.avi"), ShouldBeNil)
+		So(Place(Copy, "test/complete/show", "test/media/show"), ShouldBeNil)
+
+		So(readFile("test/media/movie.avi"), ShouldEqual, "movie")
+		So(readFile("test/media/show/Season 1/ep1.mkv"), ShouldEqual, "episode")
+		entries, _ := ioutil.ReadDir("test/media")
+		So(len(entries), ShouldEqual, 2)
+	})
+
+	Convey("Test a failed directory copy leaves nothing at the destination", t, func() {
+		resetTestDir()
+
+		err := walk("test/complete/show", "test/media/show", func(src string, dest string) error {
+			return errors.New("disk full")
+		})
+		So(err, ShouldNotBeNil)
+		_, err = os.Lstat("test/media/show")
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Lstat("test/media/show.partial")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test reflink makes an independent copy", t, func() {
+		resetTestDir()
+
+		So(Place(Reflink, "test/complete/movie.avi", "test/media/movie.avi"), ShouldBeNil)
+		So(readFile("test/media/movie.avi"), ShouldEqual, "movie")
+
+		ioutil.WriteFile("test/media/movie.avi", []byte("changed"), os.ModePerm)
+		So(readFile("test/complete/movie.avi"), ShouldEqual, "movie")
+	})
+
+	Convey("Test move", t, func() {
+		resetTestDir()
+
+		So(Place(Move, "test/complete/show", "test/media/show"), ShouldBeNil)
+
+		_, err := os.Stat("test/complete/show")
+		So(err, ShouldNotBeNil)
+		So(readFile("test/media/show/Season 1/ep1.mkv"), ShouldEqual, "episode")
+	})
+
+	Convey("Test unknown mode", t, func() {
+		resetTestDir()
+		So(Place(Mode("teleport"), "test/complete/movie.avi", "test/media/movie.avi"), ShouldNotBeNil)
+		So(IsMode("teleport"), ShouldBeFalse)
+		So(IsMode("reflink"), ShouldBeTrue)
+	})
+
+	Convey("Test same filesystem", t, func() {
+		resetTestDir()
+		same, err := sameFilesystem("test/complete/movie.avi", "test/media")
+		So(err, ShouldBeNil)
+		So(same, ShouldBeTrue)
+	})
+
+	Convey("Test progress is reported while copying", t, func() {
+		progress := &progressWriter{name: "file", total: 10}
+		n, err := progress.Write([]byte("12345"))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 5)
+		So(progress.written, ShouldEqual, 5)
+		So(progress.lastReport.IsZero(), ShouldBeFalse)
+	})
+}
+
+func readFile(name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.MkdirAll("test/complete/show/Season 1", os.ModePerm)
+	os.MkdirAll("test/media", os.ModePerm)
+	ioutil.WriteFile("test/complete/movie.avi", []byte("movie"), os.ModePerm)
+	ioutil.WriteFile("test/complete/show/Season 1/ep1.mkv", []byte("episode"), os.ModePerm)
+}
@@ -0,0 +1,59 @@
+package link
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"log"
+	"os"
+)
+
+// reflinkFile clones src to dest with FICLONE where the filesystem supports it (btrfs, xfs), then tries
+// copy_file_range, which lets the kernel share or copy extents without passing the data through us. If neither
+// works it falls back to a plain copy
+func reflinkFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := createPartial(dest, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if err == nil {
+		return finishPartial(out, dest, nil)
+	}
+
+	err = copyFileRange(in, out, stat.Size())
+	if err == nil {
+		return finishPartial(out, dest, nil)
+	}
+
+	log.Println("Unable to reflink ", src, ", falling back to a copy: ", err)
+	out.Close()
+	os.Remove(out.Name())
+	return copyFile(src, dest)
+}
+
+func copyFileRange(in *os.File, out *os.File, size int64) error {
+	remaining := size
+	for remaining > 0 {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, int(remaining), 0)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		remaining -= int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+// reflinkFile makes a plain copy, as cloning is only supported on Linux
+func reflinkFile(src string, dest string) error {
+	return copyFile(src, dest)
+}
@@ -0,0 +1,105 @@
+package magnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+const btihPrefix = "urn:btih:"
+
+// ErrNoMagnet is returned by Find when data has no magnet URI in it, like an ordinary web shortcut. Reading it again
+// won't help, unlike an empty file which may still be being written
+var ErrNoMagnet = errors.New("magnet: no magnet uri found")
+
+// Link is a parsed magnet URI. InfoHash is always lower case hex
+type Link struct {
+	URI         string
+	InfoHash    string
+	DisplayName string
+	Trackers    []string
+}
+
+// Parse reads a single magnet URI
+func Parse(uri string) (*Link, error) {
+	uri = strings.TrimSpace(uri)
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "magnet" {
+		return nil, fmt.Errorf("magnet: %v is not a magnet uri", uri)
+	}
+
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &Link{URI: uri, DisplayName: query.Get("dn"), Trackers: query["tr"]}
+	for _, topic := range query["xt"] {
+		if strings.HasPrefix(strings.ToLower(topic), btihPrefix) {
+			link.InfoHash, err = decodeInfoHash(topic[len(btihPrefix):])
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if link.InfoHash == "" {
+		return nil, errors.New("magnet: no btih exact topic found")
+	}
+	return link, nil
+}
+
+// Find returns the first magnet URI found in data. This handles plain .magnet files as well as browser
+// exported shortcuts (URL=magnet:?...)
+func Find(data []byte) (*Link, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("magnet: file is empty")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		start := strings.Index(line, "magnet:?")
+		if start < 0 {
+			continue
+		}
+		return Parse(line[start:])
+	}
+	return nil, ErrNoMagnet
+}
+
+func ParseFile(path string) (*Link, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Find(data)
+}
+
+func decodeInfoHash(hash string) (string, error) {
+	switch len(hash) {
+	case 40:
+		decoded, err := hex.DecodeString(hash)
+		if err != nil {
+			return "", fmt.Errorf("magnet: bad hex infohash: %v", err)
+		}
+		return hex.EncodeToString(decoded), nil
+	case 32:
+		decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err != nil {
+			return "", fmt.Errorf("magnet: bad base32 infohash: %v", err)
+		}
+		return hex.EncodeToString(decoded), nil
+	default:
+		return "", fmt.Errorf("magnet: infohash %v has unexpected length %v", hash, len(hash))
+	}
+}
@@ -0,0 +1,56 @@
+package magnet
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMagnet(t *testing.T) {
+
+	Convey("Test parse hex magnet", t, func() {
+		link, err := Parse("magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=Some.Show.S01E01&tr=udp%3A%2F%2Ftracker%3A80")
+		So(err, ShouldBeNil)
+		So(link.InfoHash, ShouldEqual, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+		So(link.DisplayName, ShouldEqual, "Some.Show.S01E01")
+		So(link.Trackers, ShouldResemble, []string{"udp://tracker:80"})
+	})
+
+	Convey("Test parse base32 magnet", t, func() {
+		link, err := Parse("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK")
+		So(err, ShouldBeNil)
+		So(link.InfoHash, ShouldEqual, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+		So(link.DisplayName, ShouldEqual, "")
+	})
+
+	Convey("Test parse rejects non magnet", t, func() {
+		_, err := Parse("http://example.com/file.torrent")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test parse rejects missing infohash", t, func() {
+		_, err := Parse("magnet:?dn=nothing")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test parse rejects bad infohash", t, func() {
+		_, err := Parse("magnet:?xt=urn:btih:1234")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test find magnet in browser shortcut", t, func() {
+		data := "[InternetShortcut]\r\nURL=magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Movie\r\n"
+		link, err := Find([]byte(data))
+		So(err, ShouldBeNil)
+		So(link.DisplayName, ShouldEqual, "Movie")
+		So(link.URI, ShouldStartWith, "magnet:?")
+	})
+
+	Convey("Test find without magnet", t, func() {
+		_, err := Find([]byte("just some text"))
+		So(err, ShouldEqual, ErrNoMagnet)
+
+		_, err = Find([]byte(" \n"))
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrNoMagnet)
+	})
+}
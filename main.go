@@ -2,32 +2,134 @@ package main
 
 import (
 	"flag"
+	"github.com/MondayHopscotch/SuperScope/api"
+	"github.com/MondayHopscotch/SuperScope/config"
+	"github.com/MondayHopscotch/SuperScope/control"
+	"github.com/MondayHopscotch/SuperScope/metrics"
+	"github.com/MondayHopscotch/SuperScope/state"
 	"github.com/MondayHopscotch/SuperScope/watcher"
 	"github.com/vrecan/death"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
-	rootDir := flag.String("root", "", "Root file to watch for new files")
-	dropDir := flag.String("drop", "", "Dropoff for tracker files")
-	completedDir := flag.String("complete", "", "Where the completed files will be found")
-	mediaDir := flag.String("media", "", "Final resting place for finished files")
+	if len(os.Args) > 1 && os.Args[1] == "complete" {
+		os.Exit(runComplete(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "", "YAML config file. Flags override values from the file")
+	flag.String("root", "", "Root file to watch for new files")
+	flag.String("drop", "", "Dropoff for tracker files")
+	flag.String("complete", "", "Where the completed files will be found")
+	flag.String("media", "", "Final resting place for finished files")
+	flag.String("state", "", "Directory to persist tracking state in (optional)")
+	flag.Bool("verify", false, "Verify completed files against torrent piece hashes before linking")
+	flag.String("magnet-format", config.MagnetFormatMagnet, "How magnet links are dropped off: magnet or torrent")
+	flag.String("http", "", "Address for the status API to listen on, e.g. localhost:8080 (optional)")
+	flag.String("socket", "", "Unix socket for commands like 'superscope complete'. Defaults to superscope.sock in the state dir")
 
 	flag.Parse()
 
-	log.Println("root: ", *rootDir, "   drop: ", *dropDir)
-	if *rootDir == "" || *dropDir == "" || *completedDir == "" || *mediaDir == "" {
-		log.Println("All flags must be provided")
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Println(err)
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	watcher := watcher.NewWatcher(*rootDir, *dropDir, *completedDir, *mediaDir)
+	log.Println("root: ", cfg.RootDir, "   drop: ", cfg.DropDir)
+
+	watcher := watcher.NewSimpleWatcherFromConfig(cfg)
+
+	if cfg.StateDir != "" {
+		store, err := state.OpenStore(cfg.StateDir)
+		if err != nil {
+			log.Fatal("Unable to open state dir: ", err)
+		}
+		store.Retention = time.Duration(cfg.History)
+		watcher.Store = store
+	}
 
 	watcher.Watch()
 
+	closers := []io.Closer{watcher}
+	if cfg.HTTPAddr != "" {
+		server := api.NewServer(cfg.HTTPAddr, watcher)
+		server.Handle("/metrics", metrics.Handler())
+		err = server.Start()
+		if err != nil {
+			log.Fatal("Unable to start HTTP server: ", err)
+		}
+		closers = append(closers, server)
+	}
+	if socket := cfg.SocketPath(); socket != "" {
+		server, err := control.Listen(socket, watcher)
+		if err != nil {
+			log.Fatal("Unable to open control socket: ", err)
+		}
+		closers = append(closers, server)
+	}
+
+	if *configFile != "" {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				log.Println("Reloading config from ", *configFile)
+				newCfg, err := loadConfig(*configFile)
+				if err == nil {
+					err = watcher.Reload(newCfg)
+				}
+				if err != nil {
+					log.Println("Failed to reload config, keeping the current one: ", err)
+				}
+			}
+		}()
+	}
+
 	death := death.NewDeath(syscall.SIGINT, syscall.SIGTERM)
-	death.WaitForDeath(watcher)
+	death.WaitForDeath(closers...)
+}
+
+// loadConfig reads the config file, if there is one, then applies any flags set on the command line over the top
+func loadConfig(configFile string) (config.Config, error) {
+	cfg := config.Default()
+	if configFile != "" {
+		var err error
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "root":
+			cfg.RootDir = value
+		case "drop":
+			cfg.DropDir = value
+		case "complete":
+			cfg.CompletedDir = value
+		case "media":
+			cfg.MediaDir = value
+		case "state":
+			cfg.StateDir = value
+		case "verify":
+			cfg.VerifyPieces = value == "true"
+		case "magnet-format":
+			cfg.MagnetFormat = value
+		case "http":
+			cfg.HTTPAddr = value
+		case "socket":
+			cfg.Socket = value
+		}
+	})
+
+	return cfg, cfg.Validate()
 }
@@ -0,0 +1,155 @@
+package match
+
+import (
+	"sort"
+)
+
+const (
+	// commonShare makes a token common once more than 1 in commonShare entries have it, like the or a group name
+	commonShare = 20
+	// commonFloor is how many entries a token has to be in before it's common, so small dirs use every token
+	commonFloor = 100
+)
+
+// Index holds entries of the completed dir by the tokens in their names, so a torrent only has to be scored against
+// the entries sharing a word of its title. Release tags like 1080p or x264 are in nearly every entry, so they don't
+// narrow anything down
+type Index struct {
+	entries map[string]Candidate
+	// postings lists the names of the entries containing each token
+	postings map[string]map[string]bool
+	// sorted is every entry name in order, or nil when entries have changed since it was last needed
+	sorted []string
+}
+
+func NewIndex() *Index {
+	return &Index{entries: make(map[string]Candidate, 0), postings: make(map[string]map[string]bool, 0)}
+}
+
+// candidateTokens are the tokens a candidate is scored on
+func candidateTokens(candidate Candidate) []string {
+	if candidate.Dir {
+		return Tokenize(candidate.Name)
+	}
+	return Tokenize(StripExtension(candidate.Name))
+}
+
+// Add indexes candidate, replacing any entry with the same name
+func (x *Index) Add(candidate Candidate) {
+	if existing, ok := x.entries[candidate.Name]; ok {
+		if existing == candidate {
+			return
+		}
+		x.Remove(candidate.Name)
+	}
+	x.entries[candidate.Name] = candidate
+	x.sorted = nil
+	for _, token := range candidateTokens(candidate) {
+		names, ok := x.postings[token]
+		if !ok {
+			names = make(map[string]bool, 1)
+			x.postings[token] = names
+		}
+		names[candidate.Name] = true
+	}
+}
+
+// Remove drops the entry called name, if there is one
+func (x *Index) Remove(name string) {
+	candidate, ok := x.entries[name]
+	if !ok {
+		return
+	}
+	delete(x.entries, name)
+	x.sorted = nil
+	for _, token := range candidateTokens(candidate) {
+		delete(x.postings[token], name)
+		if len(x.postings[token]) == 0 {
+			delete(x.postings, token)
+		}
+	}
+}
+
+// Has returns true if there's an entry called name
+func (x *Index) Has(name string) bool {
+	_, ok := x.entries[name]
+	return ok
+}
+
+// Len is how many entries there are
+func (x *Index) Len() int {
+	return len(x.entries)
+}
+
+// Names lists every entry, sorted
+func (x *Index) Names() []string {
+	return append([]string(nil), x.ordered()...)
+}
+
+// ordered is every entry name in order, only sorted again after entries change
+func (x *Index) ordered() []string {
+	if x.sorted == nil {
+		x.sorted = make([]string, 0, len(x.entries))
+		for name := range x.entries {
+			x.sorted = append(x.sorted, name)
+		}
+		sort.Strings(x.sorted)
+	}
+	return x.sorted
+}
+
+// Search finds the entries that could be the payload of a torrent known by any of names: those called exactly one
+// of them, and those sharing an uncommon token with one of their titles, or every entry if none do. They're sorted by
+// name, as the completed dir lists them
+func (x *Index) Search(names ...string) []Candidate {
+	found := make(map[string]bool, 0)
+	for _, name := range names {
+		if _, ok := x.entries[name]; ok {
+			found[name] = true
+		}
+		x.search(name, found)
+	}
+	// the payload may share no words with the torrent, like when it's spelt differently, so then everything is a
+	// candidate for scoring to decide
+	if len(found) == 0 {
+		candidates := make([]Candidate, 0, len(x.entries))
+		for _, entry := range x.ordered() {
+			candidates = append(candidates, x.entries[entry])
+		}
+		return candidates
+	}
+
+	candidates := make([]Candidate, 0, len(found))
+	if len(found) < 64 || len(found)*16 < len(x.entries) {
+		for entry := range found {
+			candidates = append(candidates, x.entries[entry])
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Name < candidates[j].Name
+		})
+		return candidates
+	}
+	// when much of the dir is found, walking it all in order is cheaper than sorting the ones found
+	for _, entry := range x.ordered() {
+		if found[entry] {
+			candidates = append(candidates, x.entries[entry])
+		}
+	}
+	return candidates
+}
+
+// search adds the entries sharing an uncommon token with the title of name to found. Common tokens hardly narrow the
+// search, and payloads are often named without them
+func (x *Index) search(name string, found map[string]bool) {
+	common := len(x.entries) / commonShare
+	if common < commonFloor {
+		common = commonFloor
+	}
+	for _, token := range parse(name, false).title {
+		if entries := x.postings[token]; len(entries) <= common {
+			for entry := range entries {
+				found[entry] = true
+			}
+		}
+	}
+}
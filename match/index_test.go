@@ -0,0 +1,134 @@
+package match
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+
+	Convey("Test searching finds entries sharing a token", t, func() {
+		index := NewIndex()
+		index.Add(Candidate{Name: "Show.S01E01.720p.HDTV-AAA.mkv"})
+		index.Add(Candidate{Name: "Show.S01E02.720p.HDTV-AAA.mkv"})
+		index.Add(Candidate{Name: "Film (2010)", Dir: true})
+		index.Add(Candidate{Name: "---"})
+
+		So(index.Len(), ShouldEqual, 4)
+		So(names(index.Search("Show.S01E01")), ShouldResemble, []string{"Show.S01E01.720p.HDTV-AAA.mkv", "Show.S01E02.720p.HDTV-AAA.mkv"})
+		So(names(index.Search("Film.2010.1080p")), ShouldResemble, []string{"Film (2010)"})
+		So(names(index.Search("---", "Unrelated")), ShouldResemble, []string{"---"})
+		// nothing shares a word with it, so everything is left for scoring to rule out
+		So(index.Search("mkv"), ShouldHaveLength, 4)
+	})
+
+	Convey("Test removing and replacing entries", t, func() {
+		index := NewIndex()
+		index.Add(Candidate{Name: "Film.2010.mkv"})
+		index.Add(Candidate{Name: "Film.2010.mkv", Dir: true})
+		So(index.Search("mkv")[0].Dir, ShouldBeTrue)
+
+		index.Remove("Film.2010.mkv")
+		index.Remove("missing")
+		So(index.Has("Film.2010.mkv"), ShouldBeFalse)
+		So(index.Search("Film"), ShouldBeEmpty)
+		So(index.postings, ShouldBeEmpty)
+	})
+
+	Convey("Test the index finds every entry by its title", t, func() {
+		index := NewIndex()
+		candidates := benchmarkCandidates(500)
+		for _, candidate := range candidates {
+			index.Add(candidate)
+		}
+		for _, candidate := range candidates {
+			title := parse(candidate.Name, !candidate.Dir).title
+			So(names(index.Search(strings.Join(title, ".")+".2160p.WEB-DL.x265-EEE")), ShouldContain, candidate.Name)
+		}
+	})
+
+	Convey("Test searching skips release tags and common words", t, func() {
+		index := NewIndex()
+		for _, candidate := range benchmarkCandidates(500) {
+			index.Add(candidate)
+		}
+		index.Add(Candidate{Name: "The.Lost.Show.S01E01.720p.HDTV.x264-AAA.mkv"})
+
+		// show is in half the entries, so only 3 narrows it down
+		found := names(index.Search("Show.3.S01E02.720p.HDTV.x264-AAA"))
+		So(found, ShouldResemble, names(index.Search("Show 3")))
+		So(found, ShouldHaveLength, 11)
+		So(found, ShouldContain, "Film 3 (1993) 1080p BluRay-DDD")
+		So(names(index.Search("Film 7 2013 1080p")), ShouldNotContain, "Film 23 (2013) 1080p BluRay-DDD")
+		So(names(index.Search("Lost.Show.S01E01.720p.HDTV.x264-AAA")), ShouldResemble, []string{"The.Lost.Show.S01E01.720p.HDTV.x264-AAA.mkv"})
+		So(index.Search("Missing.Show.S01E01.720p.HDTV.x264-AAA"), ShouldHaveLength, 501)
+		So(index.Search("Show"), ShouldHaveLength, 501)
+	})
+
+	Convey("Test searching finds payloads named a little differently", t, func() {
+		index := NewIndex()
+		for _, candidate := range benchmarkCandidates(500) {
+			index.Add(candidate)
+		}
+		pairs := map[string]Candidate{
+			"Spider-Man.No.Way.Home.2021.1080p.WEB-DL.x264-GRP": {Name: "Spiderman No Way Home 2021 1080p WEB-DL x264-GRP", Dir: true},
+			"The.Office.US.S01E01.720p.HDTV.x264-AAA":           {Name: "The Office S01E01 720p HDTV x264-AAA.mkv"},
+			"Amelie.2001.1080p.BluRay.x264-GRP":                 {Name: "Amélie 2001 1080p BluRay x264-GRP", Dir: true},
+		}
+		for _, payload := range pairs {
+			index.Add(payload)
+		}
+		for torrent, payload := range pairs {
+			So(Score(torrent, payload, 0), ShouldBeGreaterThanOrEqualTo, 0.7)
+			So(names(index.Search(torrent)), ShouldContain, payload.Name)
+		}
+	})
+}
+
+func names(candidates []Candidate) []string {
+	result := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		result = append(result, candidate.Name)
+	}
+	return result
+}
+
+// benchmarkCandidates makes count release names, a mix of episodes and films sharing the usual tags
+func benchmarkCandidates(count int) []Candidate {
+	groups := []string{"AAA", "BBB", "CCC", "DDD"}
+	candidates := make([]Candidate, 0, count)
+	for i := 0; i < count; i++ {
+		if i%2 == 0 {
+			name := fmt.Sprintf("Show.%d.S%02dE%02d.720p.HDTV.x264-%s.mkv", i/20, i%5+1, i%20+1, groups[i%4])
+			candidates = append(candidates, Candidate{Name: name})
+		} else {
+			name := fmt.Sprintf("Film %d (%d) 1080p BluRay-%s", i, 1990+i%30, groups[i%4])
+			candidates = append(candidates, Candidate{Name: name, Dir: true})
+		}
+	}
+	return candidates
+}
+
+func BenchmarkIndexAdd(b *testing.B) {
+	candidates := benchmarkCandidates(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := NewIndex()
+		for _, candidate := range candidates {
+			index.Add(candidate)
+		}
+	}
+}
+
+func BenchmarkIndexSearch(b *testing.B) {
+	index := NewIndex()
+	for _, candidate := range benchmarkCandidates(10000) {
+		index.Add(candidate)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search(fmt.Sprintf("Show.%d.S01E01", i%500))
+	}
+}
@@ -0,0 +1,329 @@
+package match
+
+import (
+	"container/list"
+	"github.com/MondayHopscotch/SuperScope/release"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Candidate is an entry in the completed dir that might be a torrent's payload
+type Candidate struct {
+	Name string `json:"name"`
+	// Size is the total size of the entry, or 0 if it isn't known
+	Size int64 `json:"size,omitempty"`
+	// Dir is true for directories, whose names never have an extension to ignore
+	Dir bool `json:"dir,omitempty"`
+}
+
+// Result is a Candidate and how confident we are that it's the payload, from 0 to 1. Certain is set when the
+// candidate is known to be the payload, like one verified against the torrent's metadata, rather than scored by name
+type Result struct {
+	Candidate
+	Score   float64 `json:"score"`
+	Certain bool    `json:"certain,omitempty"`
+}
+
+// Matcher picks the best candidate for a torrent
+type Matcher struct {
+	// Threshold is the lowest score accepted as a match
+	Threshold float64
+	// Margin is how far ahead of the runner up the best candidate must be. Closer than that is ambiguous
+	Margin float64
+}
+
+const (
+	forwardWeight    = 0.5
+	backwardWeight   = 0.15
+	similarityWeight = 0.35
+
+	// attributeBonus is added for every release attribute, like year, episode or resolution, both names agree on
+	attributeBonus = 0.05
+	// attributePenalty is taken for every one they disagree on instead, as they're different releases
+	attributePenalty = 0.3
+	// plausibleSizeRatio is how much smaller or larger than expected a payload can be before it's penalised
+	plausibleSizeRatio = 0.8
+)
+
+var (
+	nonAlphaNum = regexp.MustCompile("[^a-z0-9]+")
+	extension   = regexp.MustCompile(`^\.[A-Za-z0-9]{2,4}$`)
+	// notExtension catches the end of dotted release names that look like extensions, like .2020 or .S01
+	notExtension = regexp.MustCompile(`^\.([0-9]+|[sSeE][0-9]+)$`)
+)
+
+// name is a parsed torrent or candidate name
+type name struct {
+	normalized string
+	tokens     []string
+	// counts is how many times each token appears
+	counts     map[string]int
+	attributes []string
+	// title is the tokens of the title, or all of them if there's no title to make out
+	title []string
+}
+
+// parsedLimit is how many parsed names are cached, enough for every entry of a large completed dir and every active
+// torrent. The least recently used are dropped past that, like entries since removed from the completed dir
+var parsedLimit = 50000
+
+// parsedKey is a name to parse, and whether it's a file name that could have an extension
+type parsedKey struct {
+	s    string
+	file bool
+}
+
+// parsedName is a cached name, kept in the order it was last used
+type parsedName struct {
+	key  parsedKey
+	name name
+}
+
+var (
+	parsedLock sync.Mutex
+	// parsed caches names, as the same torrents and completed entries are scored against each other on every check
+	parsed = make(map[parsedKey]*list.Element, 0)
+	// parsedOrder has the most recently used names at the front
+	parsedOrder = list.New()
+)
+
+// Tokenize lower cases name and splits it on anything that isn't a letter or digit
+func Tokenize(s string) []string {
+	tokens := make([]string, 0)
+	for _, token := range nonAlphaNum.Split(strings.ToLower(s), -1) {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// StripExtension removes a file extension from s, leaving dotted release names like Show.S01 alone
+func StripExtension(s string) string {
+	dot := strings.LastIndex(s, ".")
+	if dot < 0 {
+		return s
+	}
+	ext := s[dot:]
+	if !extension.MatchString(ext) || notExtension.MatchString(ext) {
+		return s
+	}
+	return s[:dot]
+}
+
+// parse splits s up for scoring, without its extension if it's a file
+func parse(s string, file bool) name {
+	key := parsedKey{s: s, file: file}
+	parsedLock.Lock()
+	element, ok := parsed[key]
+	if ok {
+		parsedOrder.MoveToFront(element)
+	}
+	parsedLock.Unlock()
+	if ok {
+		return element.Value.(*parsedName).name
+	}
+
+	if file {
+		s = StripExtension(s)
+	}
+	r := release.Parse(s)
+	n := name{tokens: Tokenize(s), attributes: util.ReleaseAttributes(r), title: Tokenize(r.Title)}
+	if len(n.title) == 0 {
+		n.title = n.tokens
+	}
+	n.normalized = strings.Join(n.tokens, " ")
+	n.counts = make(map[string]int, len(n.tokens))
+	for _, token := range n.tokens {
+		n.counts[token]++
+	}
+
+	parsedLock.Lock()
+	if _, ok := parsed[key]; !ok {
+		parsed[key] = parsedOrder.PushFront(&parsedName{key: key, name: n})
+		for parsedOrder.Len() > parsedLimit {
+			oldest := parsedOrder.Remove(parsedOrder.Back()).(*parsedName)
+			delete(parsed, oldest.key)
+		}
+	}
+	parsedLock.Unlock()
+	return n
+}
+
+// Score rates how likely candidate is the payload of the torrent called torrentName. expectedSize is the
+// torrent's total size, or 0 if it isn't known
+func Score(torrentName string, candidate Candidate, expectedSize int64) float64 {
+	return ScoreAbove(torrentName, candidate, expectedSize, 0)
+}
+
+// ScoreAbove is Score for when only scores of at least minimum matter. Candidates that can't reach it score 0
+// without the costly comparison of their whole names
+func ScoreAbove(torrentName string, candidate Candidate, expectedSize int64, minimum float64) float64 {
+	return score(parse(torrentName, false), parse(candidate.Name, !candidate.Dir), candidate.Size, expectedSize, minimum)
+}
+
+func score(torrent name, candidate name, size int64, expectedSize int64, minimum float64) float64 {
+	if len(torrent.tokens) == 0 || len(candidate.tokens) == 0 {
+		return 0
+	}
+
+	// agreeing on everything else doesn't make up for being a different episode or year
+	result := 0.0
+	agree, conflict := util.CompareAttributes(torrent.attributes, candidate.attributes)
+	if conflict > 0 {
+		result -= attributePenalty * float64(conflict)
+	} else {
+		result += attributeBonus * float64(agree)
+	}
+
+	if size > 0 && expectedSize > 0 {
+		ratio := float64(size) / float64(expectedSize)
+		if ratio > 1 {
+			ratio = 1 / ratio
+		}
+		if ratio < plausibleSizeRatio {
+			result -= plausibleSizeRatio - ratio
+		}
+	}
+
+	// the names themselves can only make up so much, so check that could be enough before comparing them
+	if result+forwardWeight+backwardWeight+similarityWeight < minimum {
+		return 0
+	}
+	shared := sharedTokens(torrent.counts, candidate.counts)
+	if shared == 0 {
+		return 0
+	}
+	forward := float64(shared) / float64(len(torrent.tokens))
+	backward := float64(shared) / float64(len(candidate.tokens))
+	result += forwardWeight*forward + backwardWeight*backward
+
+	// the edit distance is at least the difference in length, so see if the best similarity could be enough first
+	if result+similarityWeight*similarityBound(torrent.normalized, candidate.normalized) < minimum {
+		return 0
+	}
+	result += similarityWeight * similarity(torrent.normalized, candidate.normalized)
+
+	if result < 0 {
+		return 0
+	} else if result > 1 {
+		return 1
+	}
+	return result
+}
+
+// sharedTokens counts the tokens a and b have in common, each only as many times as it appears in both
+func sharedTokens(a map[string]int, b map[string]int) int {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	shared := 0
+	for token, count := range a {
+		if other := b[token]; other < count {
+			shared += other
+		} else {
+			shared += count
+		}
+	}
+	return shared
+}
+
+// similarity is 1 minus the edit distance between a and b as a fraction of the longer one
+func similarity(a string, b string) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// similarityBound is the highest similarity a and b could have, given their lengths
+func similarityBound(a string, b string) float64 {
+	shortest, longest := len(a), len(b)
+	if shortest > longest {
+		shortest, longest = longest, shortest
+	}
+	if longest == 0 {
+		return 1
+	}
+	return float64(shortest) / float64(longest)
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	smallest := values[0]
+	for _, value := range values[1:] {
+		if value < smallest {
+			smallest = value
+		}
+	}
+	return smallest
+}
+
+// Rank scores every candidate with scoreFunc, which also says whether it's certain of the candidate, dropping those
+// scoring nothing. Certain results come first, then the rest best first
+func Rank(candidates []Candidate, scoreFunc func(Candidate) (float64, bool)) []Result {
+	results := make([]Result, 0)
+	for _, candidate := range candidates {
+		s, certain := scoreFunc(candidate)
+		if s > 0 {
+			results = append(results, Result{Candidate: candidate, Score: s, Certain: certain})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Certain != results[j].Certain {
+			return results[i].Certain
+		}
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+// Pick chooses the best of ranked, which must be sorted as Rank does. It returns nil if nothing reaches the threshold.
+// If other candidates are too close to call, it returns nil along with every candidate in contention. The margin
+// only applies to scores: a certain candidate is picked however close the others score, unless they're certain too
+func (m Matcher) Pick(ranked []Result) (*Result, []Result) {
+	if len(ranked) == 0 || ranked[0].Score < m.Threshold {
+		return nil, nil
+	}
+	contenders := 1
+	if ranked[0].Certain {
+		for contenders < len(ranked) && ranked[contenders].Certain {
+			contenders++
+		}
+	} else {
+		for contenders < len(ranked) && ranked[contenders].Score >= m.Threshold && ranked[0].Score-ranked[contenders].Score < m.Margin {
+			contenders++
+		}
+	}
+	if contenders > 1 {
+		return nil, ranked[:contenders]
+	}
+	best := ranked[0]
+	return &best, nil
+}
@@ -0,0 +1,148 @@
+package match
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	matcher := Matcher{Threshold: 0.7, Margin: 0.1}
+
+	Convey("Test extensions are only stripped from real extensions", t, func() {
+		So(StripExtension("Film.2010.mkv"), ShouldEqual, "Film.2010")
+		So(StripExtension("Show.S01"), ShouldEqual, "Show.S01")
+		So(StripExtension("The.Daily.Show.2020.01.31"), ShouldEqual, "The.Daily.Show.2020.01.31")
+		So(StripExtension("Show Name"), ShouldEqual, "Show Name")
+		So(Tokenize("It.2017.1080p--BluRay"), ShouldResemble, []string{"it", "2017", "1080p", "bluray"})
+	})
+
+	Convey("Test identical names score full marks", t, func() {
+		So(Score("It.2017.1080p.BluRay-GRP", Candidate{Name: "It.2017.1080p.BluRay-GRP.mkv"}, 0), ShouldEqual, 1)
+		So(Score("test", Candidate{Name: "test.avi"}, 0), ShouldEqual, 1)
+	})
+
+	Convey("Test scores", t, func() {
+		cases := []struct {
+			torrent   string
+			candidate Candidate
+			accepted  bool
+		}{
+			{"Movie Name (2010)", Candidate{Name: "Movie.Name.2010.1080p.BluRay-GRP", Dir: true}, true},
+			{"Some Show S01", Candidate{Name: "Some.Show.S01.720p.HDTV-GRP", Dir: true}, true},
+			{"It", Candidate{Name: "It Follows (2014)", Dir: true}, false},
+			{"Up", Candidate{Name: "Upgrade.2018.mkv"}, false},
+			{"Up", Candidate{Name: "Make Up Your Mind", Dir: true}, false},
+			{"Show.S01E01.720p.HDTV-AAA", Candidate{Name: "Show.S01E01.1080p.WEB-BBB.mkv"}, false},
+			{"Film.1999.1080p", Candidate{Name: "Film.2019.1080p.mkv"}, false},
+			{"Show.S01E01.720p.HDTV.x264-GRP", Candidate{Name: "Show.S01E02.720p.HDTV.x264-GRP.mkv"}, false},
+			{"Film.2010.EXTENDED.1080p.BluRay-GRP", Candidate{Name: "Film.2010.1080p.BluRay-GRP", Dir: true}, true},
+			{"Unrelated", Candidate{Name: "Something Else"}, false},
+		}
+		for _, c := range cases {
+			s := Score(c.torrent, c.candidate, 0)
+			So(s >= matcher.Threshold, ShouldEqual, c.accepted)
+		}
+	})
+
+	Convey("Test scoring above a minimum only skips what can't reach it", t, func() {
+		for _, candidate := range benchmarkCandidates(200) {
+			for _, torrent := range []string{"Show.3.S02E04.720p.HDTV", "Film 7 (1997) 1080p BluRay-DDD", "Show 5"} {
+				s := Score(torrent, candidate, 0)
+				above := ScoreAbove(torrent, candidate, 0, matcher.Threshold)
+				if s >= matcher.Threshold {
+					So(above, ShouldEqual, s)
+				} else {
+					So(above, ShouldBeLessThan, matcher.Threshold)
+				}
+			}
+		}
+	})
+
+	Convey("Test implausible sizes are penalised", t, func() {
+		candidate := Candidate{Name: "Film.2010.1080p.BluRay-GRP", Size: 1000, Dir: true}
+		So(Score("Film.2010.1080p.BluRay-GRP", candidate, 1100), ShouldEqual, 1)
+		So(Score("Film.2010.1080p.BluRay-GRP", candidate, 5000), ShouldBeLessThan, matcher.Threshold)
+	})
+
+	Convey("Test pick takes the clear winner", t, func() {
+		candidates := []Candidate{
+			{Name: "Some.Show.S01.720p.HDTV-GRP", Dir: true},
+			{Name: "Some.Show.S01.1080p.WEB-OTHER", Dir: true},
+			{Name: "Another Show", Dir: true},
+		}
+		ranked := Rank(candidates, func(c Candidate) (float64, bool) { return Score("Some.Show.S01.720p.HDTV-GRP", c, 0), false })
+		// the other release disagrees on resolution and group, so doesn't score at all
+		So(len(ranked), ShouldEqual, 2)
+
+		best, ambiguous := matcher.Pick(ranked)
+		So(ambiguous, ShouldBeEmpty)
+		So(best, ShouldNotBeNil)
+		So(best.Name, ShouldEqual, "Some.Show.S01.720p.HDTV-GRP")
+	})
+
+	Convey("Test pick refuses to guess between close candidates", t, func() {
+		candidates := []Candidate{
+			{Name: "Show.S01E01.720p.HDTV-AAA.mkv"},
+			{Name: "Show.S01E01.720p.WEB-BBB.mkv"},
+		}
+		ranked := Rank(candidates, func(c Candidate) (float64, bool) { return Score("Show S01E01 720p", c, 0), false })
+
+		best, ambiguous := matcher.Pick(ranked)
+		So(best, ShouldBeNil)
+		So(len(ambiguous), ShouldEqual, 2)
+	})
+
+	Convey("Test pick takes a certain candidate however close the others score", t, func() {
+		candidates := []Candidate{
+			{Name: "Show.S01E01.720p.HDTV-AAA.mkv"},
+			{Name: "Show.S01E01.720p.HDTV-AAA"},
+		}
+		ranked := Rank(candidates, func(c Candidate) (float64, bool) {
+			if c.Name == "Show.S01E01.720p.HDTV-AAA" {
+				return 1, true
+			}
+			return 0.99, false
+		})
+		So(ranked[0].Certain, ShouldBeTrue)
+
+		best, ambiguous := matcher.Pick(ranked)
+		So(ambiguous, ShouldBeEmpty)
+		So(best, ShouldNotBeNil)
+		So(best.Name, ShouldEqual, "Show.S01E01.720p.HDTV-AAA")
+
+		ranked[1].Certain = true
+		best, ambiguous = matcher.Pick(ranked)
+		So(best, ShouldBeNil)
+		So(len(ambiguous), ShouldEqual, 2)
+	})
+
+	Convey("Test pick refuses low confidence", t, func() {
+		best, ambiguous := matcher.Pick([]Result{{Candidate: Candidate{Name: "It Follows"}, Score: 0.6}})
+		So(best, ShouldBeNil)
+		So(ambiguous, ShouldBeEmpty)
+
+		best, _ = matcher.Pick(nil)
+		So(best, ShouldBeNil)
+	})
+
+	Convey("Test parsed names are dropped once they haven't been used for a while", t, func() {
+		defer func(limit int) {
+			parsedLimit = limit
+		}(parsedLimit)
+		parsedLimit = 100
+
+		kept := parse("Kept.Film.2010.mkv", true)
+		for i := 0; i < parsedLimit; i++ {
+			if i%10 == 0 {
+				parse("Kept.Film.2010.mkv", true)
+			}
+			parse(fmt.Sprintf("Film.%d", i), false)
+		}
+		So(parsedOrder.Len(), ShouldEqual, parsedLimit)
+		So(len(parsed), ShouldEqual, parsedLimit)
+		So(parsed, ShouldContainKey, parsedKey{s: "Kept.Film.2010.mkv", file: true})
+		So(parsed, ShouldNotContainKey, parsedKey{s: "Film.0"})
+		So(parse("Kept.Film.2010.mkv", true), ShouldResemble, kept)
+	})
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "superscope"
+
+// durationBuckets run from a second to a couple of days, as downloads can take a long time to complete
+var durationBuckets = []float64{1, 5, 30, 60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600, 48 * 3600}
+
+var (
+	TorrentsSeen = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "torrents_seen_total",
+		Help:      "New torrent and magnet files found in the watch tree.",
+	})
+	TorrentsConsumed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "torrents_consumed_total",
+		Help:      "Torrents successfully handed to the client.",
+	})
+	ConsumeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consume_failures_total",
+		Help:      "Torrents that could not be handed to the client before the consume timeout.",
+	})
+	ConsumeRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consume_retries_total",
+		Help:      "Extra attempts needed to hand torrents to the client.",
+	})
+	ConsumeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "consume_duration_seconds",
+		Help:      "Time from a torrent being found to it being handed to the client.",
+		Buckets:   durationBuckets,
+	})
+	CompletionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "completion_duration_seconds",
+		Help:      "Time from a torrent being consumed to its completed payload being found.",
+		Buckets:   durationBuckets,
+	})
+	Finalizations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "finalizations_total",
+		Help:      "Completed payloads placed in the media dir, by result (linked or failed).",
+	}, []string{"result"})
+	FinalizeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "finalize_duration_seconds",
+		Help:      "Time taken to verify and place a completed payload.",
+		Buckets:   durationBuckets,
+	})
+	ActiveTracked = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_tracked",
+		Help:      "Torrents waiting for their completed payload.",
+	})
+	WatchedDirs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watched_dirs",
+		Help:      "Directories being watched for new torrents.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		TorrentsSeen,
+		TorrentsConsumed,
+		ConsumeFailures,
+		ConsumeRetries,
+		ConsumeDuration,
+		CompletionDuration,
+		Finalizations,
+		FinalizeDuration,
+		ActiveTracked,
+		WatchedDirs,
+	)
+}
+
+// Handler serves every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
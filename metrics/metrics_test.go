@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+
+	Convey("Test metrics are served in the text format", t, func() {
+		TorrentsSeen.Inc()
+		Finalizations.WithLabelValues("linked").Inc()
+		ConsumeDuration.Observe(2)
+
+		server := httptest.NewServer(Handler())
+		defer server.Close()
+
+		resp, err := server.Client().Get(server.URL)
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+		So(string(body), ShouldContainSubstring, "superscope_torrents_seen_total 1")
+		So(string(body), ShouldContainSubstring, `superscope_finalizations_total{result="linked"} 1`)
+		So(string(body), ShouldContainSubstring, `superscope_consume_duration_seconds_bucket{le="5"} 1`)
+		So(string(body), ShouldContainSubstring, "superscope_active_tracked 0")
+	})
+}
@@ -0,0 +1,289 @@
+package release
+
+import (
+	"github.com/MondayHopscotch/SuperScope/tv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release is what we could work out from a scene or P2P style name like Show.Name.S02E05.1080p.WEB-DL.x264-GROUP.
+// Anything not found in the name is left empty
+type Release struct {
+	Title string
+	Year  int
+
+	Season   int
+	Episodes []int
+	// Date is set instead of Episodes for daily shows, as YYYY-MM-DD
+	Date string
+
+	// Resolution is normalized to lower case, e.g. 720p or 2160p
+	Resolution string
+	// Source is where it was ripped from, e.g. WEB-DL, WEBRip, BluRay or HDTV
+	Source string
+	// Codec is the video codec, e.g. x264, H.265 or XviD
+	Codec string
+	// Audio is the audio codec, e.g. DDP, DTS-HD or AAC, and Channels its layout, e.g. 5.1
+	Audio    string
+	Channels string
+	Group    string
+	// Edition is a cut of a film, e.g. Extended or Director's Cut
+	Edition string
+
+	Proper bool
+	Repack bool
+}
+
+// alias maps a pattern found in names to the value we normalize it to. Weak aliases are ordinary words that
+// could be part of a title, so are only believed after something that can't be
+type alias struct {
+	pattern *regexp.Regexp
+	value   string
+	weak    bool
+}
+
+// separator is anything that can divide the parts of a release name
+const separator = `[ ._\-\[\]()]`
+
+// audioChannels optionally follows an audio codec, like the 5.1 of DDP5.1
+const audioChannels = `(?:[ ._-]?[1-8][ ._][01])?`
+
+// part builds a pattern matching a whole part of a name, with its text in the first group
+func part(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:^|` + separator + `)(` + pattern + `)(?:$|` + separator + `)`)
+}
+
+func strong(pattern string, value string) alias {
+	return alias{pattern: part(pattern), value: value}
+}
+
+func weak(pattern string, value string) alias {
+	return alias{pattern: part(pattern), value: value, weak: true}
+}
+
+var (
+	year       = part(`(?:19|20)\d{2}`)
+	resolution = part(`\d{3,4}[pi]|4k|uhd`)
+	channels   = regexp.MustCompile(`([1-8])[ ._]([01])$`)
+
+	// aliases are tried in order, so more specific ones come first
+	sources = []alias{
+		strong(`web[ ._-]?dl`, "WEB-DL"),
+		strong(`web[ ._-]?rip`, "WEBRip"),
+		strong(`blu[ ._-]?ray|bd[ ._-]?remux`, "BluRay"),
+		strong(`bd[ ._-]?rip|br[ ._-]?rip`, "BDRip"),
+		strong(`hdtv`, "HDTV"),
+		strong(`pdtv`, "PDTV"),
+		strong(`dvd[ ._-]?rip`, "DVDRip"),
+		strong(`hd[ ._-]?rip`, "HDRip"),
+		strong(`dvd(?:[ ._-]?r|5|9)`, "DVD"),
+		weak(`dvd`, "DVD"),
+		weak(`web`, "WEB"),
+		strong(`hd[ ._-]?cam|cam[ ._-]?rip`, "CAM"),
+		weak(`cam`, "CAM"),
+		strong(`telesync|hd[ ._-]?ts`, "TS"),
+	}
+	codecs = []alias{
+		strong(`x[ ._]?264`, "x264"),
+		strong(`x[ ._]?265`, "x265"),
+		strong(`h[ ._]?264|avc`, "H.264"),
+		strong(`h[ ._]?265|hevc`, "H.265"),
+		strong(`xvid`, "XviD"),
+		strong(`divx`, "DivX"),
+		strong(`av1`, "AV1"),
+		strong(`vp9`, "VP9"),
+	}
+	audios = []alias{
+		strong(`truehd`+audioChannels, "TrueHD"),
+		strong(`dts[ ._-]?hd(?:[ ._-]?ma)?`+audioChannels, "DTS-HD"),
+		strong(`dts[ ._-]?x`+audioChannels, "DTS:X"),
+		strong(`dts`+audioChannels, "DTS"),
+		strong(`(?:ddp|dd\+|e[ ._-]?ac[ ._-]?3)`+audioChannels, "DDP"),
+		strong(`(?:dd|ac[ ._-]?3)[ ._-]?[1-8][ ._][01]|ac[ ._-]?3`, "DD"),
+		strong(`aac`+audioChannels, "AAC"),
+		strong(`flac`+audioChannels, "FLAC"),
+		weak(`opus`+audioChannels, "Opus"),
+		strong(`mp3`, "MP3"),
+		strong(`l?pcm`+audioChannels, "LPCM"),
+	}
+	editions = []alias{
+		weak(`extended(?:[ ._-]?(?:cut|edition))?`, "Extended"),
+		weak(`director'?s[ ._-]?cut`, "Director's Cut"),
+		weak(`theatrical(?:[ ._-]?cut)?`, "Theatrical"),
+		weak(`ultimate[ ._-]?(?:cut|edition)`, "Ultimate Edition"),
+		weak(`special[ ._-]?edition`, "Special Edition"),
+		weak(`unrated`, "Unrated"),
+		weak(`uncut`, "Uncut"),
+		weak(`remastered`, "Remastered"),
+		weak(`imax`, "IMAX"),
+		weak(`criterion`, "Criterion"),
+	}
+	propers = []alias{weak(`proper`, "PROPER")}
+	repacks = []alias{weak(`repack|rerip`, "REPACK")}
+
+	// group is the -GROUP at the end of a scene name, and leadingGroup the [Group] at the start of a fansub name
+	group        = regexp.MustCompile(`-([A-Za-z0-9]+)$`)
+	leadingGroup = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+	// trailingTag is one of the things like [rarbg] or [eztv] that sites append after the group
+	trailingTag  = regexp.MustCompile(`\s*\[([^\]]*)\]$`)
+	extension    = regexp.MustCompile(`\.[A-Za-z][A-Za-z0-9]{1,3}$`)
+	notExtension = regexp.MustCompile(`(?i)\.(?:[se]\d+|web|dvd[r59]?|cam|hdtv|pdtv|dts|aac|ac3|dd|ddp|x26[45]|h26[45]|hevc|avc|xvid|divx|av1|vp9|flac|mp3|hdr|uhd|imax)$`)
+
+	separators = regexp.MustCompile(`[._]+`)
+	spaces     = regexp.MustCompile(`\s+`)
+)
+
+// notGroups are endings that look like a group, but are really the end of a hyphenated part like WEB-DL
+var notGroups = map[string]bool{"dl": true, "rip": true, "ray": true, "hd": true, "ma": true, "x": true, "cut": true}
+
+// found is a match for a part of a name: where it starts and its text
+type found struct {
+	start int
+	text  string
+}
+
+// findAll finds every non-overlapping match of a part pattern. Parts share their separators, so each search
+// starts on the separator after the previous part
+func findAll(pattern *regexp.Regexp, name string) []found {
+	matches := make([]found, 0)
+	offset := 0
+	for offset < len(name) {
+		match := pattern.FindStringSubmatchIndex(name[offset:])
+		if match == nil {
+			break
+		}
+		matches = append(matches, found{start: offset + match[2], text: name[offset+match[2] : offset+match[3]]})
+		offset += match[3]
+	}
+	return matches
+}
+
+// Parse picks apart a release name, which may be a file name with an extension
+func Parse(name string) Release {
+	r := Release{}
+
+	name = stripTags(strings.TrimSpace(name))
+	if extension.MatchString(name) && !notExtension.MatchString(name) {
+		name = name[:strings.LastIndex(name, ".")]
+	}
+	name = stripTags(name)
+
+	titleStart := 0
+	if match := leadingGroup.FindStringSubmatchIndex(name); match != nil {
+		r.Group = name[match[2]:match[3]]
+		titleStart = match[1]
+	}
+
+	// titleEnd is pulled back to the first part of the name that isn't the title
+	titleEnd := len(name)
+	end := func(start int) {
+		if start >= titleStart && start < titleEnd {
+			titleEnd = start
+		}
+	}
+
+	if episode, ok := tv.Parse(name[titleStart:]); ok {
+		r.Season = episode.Season
+		r.Episodes = episode.Episodes
+		if episode.Date != "" {
+			r.Season = 0
+			r.Date = episode.Date
+		}
+		end(titleStart + tv.Index(name[titleStart:]))
+	} else if season, ok := tv.Season(name[titleStart:]); ok {
+		r.Season = season
+		end(titleStart + tv.Index(name[titleStart:]))
+	}
+
+	if matches := findAll(resolution, name); len(matches) > 0 {
+		r.Resolution = strings.ToLower(matches[0].text)
+		if r.Resolution == "4k" || r.Resolution == "uhd" {
+			r.Resolution = "2160p"
+		}
+		end(matches[0].start)
+	}
+
+	var audio found
+	var weakParts []func()
+	for _, attribute := range []struct {
+		aliases []alias
+		set     func(string, found)
+	}{
+		{sources, func(value string, _ found) { r.Source = value }},
+		{codecs, func(value string, _ found) { r.Codec = value }},
+		{audios, func(value string, match found) { r.Audio, audio = value, match }},
+		{editions, func(value string, _ found) { r.Edition = value }},
+		{propers, func(string, found) { r.Proper = true }},
+		{repacks, func(string, found) { r.Repack = true }},
+	} {
+		for _, candidate := range attribute.aliases {
+			matches := findAll(candidate.pattern, name)
+			if len(matches) == 0 {
+				continue
+			}
+			value, set := candidate.value, attribute.set
+			if candidate.weak {
+				weakParts = append(weakParts, func() {
+					for _, match := range matches {
+						if match.start > titleEnd {
+							set(value, match)
+							return
+						}
+					}
+				})
+			} else {
+				set(value, matches[0])
+				end(matches[0].start)
+			}
+			break
+		}
+	}
+
+	// the year is the last one before anything else, so titles like 1917 or Blade Runner 2049 keep their number
+	if r.Date == "" {
+		limit := titleEnd
+		for _, match := range findAll(year, name) {
+			if match.start > titleStart && match.start <= limit {
+				r.Year, _ = strconv.Atoi(match.text)
+				titleEnd = match.start
+			}
+		}
+	}
+
+	for _, weakPart := range weakParts {
+		weakPart()
+	}
+	if audio.text != "" {
+		if match := channels.FindStringSubmatch(audio.text); match != nil {
+			r.Channels = match[1] + "." + match[2]
+		}
+	}
+
+	// only trust a -GROUP ending when there's something other than the title before it
+	if r.Group == "" && titleEnd < len(name) {
+		if match := group.FindStringSubmatch(name); match != nil && !notGroups[strings.ToLower(match[1])] {
+			r.Group = match[1]
+		}
+	}
+
+	r.Title = cleanTitle(name[titleStart:titleEnd])
+	return r
+}
+
+// stripTags removes tags like [rarbg] or [ABCD1234] from the end of name, stopping at one that holds a resolution
+func stripTags(name string) string {
+	for {
+		match := trailingTag.FindStringSubmatchIndex(name)
+		if match == nil || resolution.MatchString(name[match[2]:match[3]]) {
+			return name
+		}
+		name = name[:match[0]]
+	}
+}
+
+func cleanTitle(raw string) string {
+	title := separators.ReplaceAllString(raw, " ")
+	title = strings.Trim(title, " -([")
+	return spaces.ReplaceAllString(title, " ")
+}
@@ -0,0 +1,70 @@
+package release
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+var corpus = []struct {
+	name     string
+	expected Release
+}{
+	// scene tv
+	{"Show.Name.S02E05.1080p.WEB-DL.x264-GROUP", Release{Title: "Show Name", Season: 2, Episodes: []int{5}, Resolution: "1080p", Source: "WEB-DL", Codec: "x264", Group: "GROUP"}},
+	{"Show.Name.S02E05.1080p.WEB-DL.x264-GROUP.mkv", Release{Title: "Show Name", Season: 2, Episodes: []int{5}, Resolution: "1080p", Source: "WEB-DL", Codec: "x264", Group: "GROUP"}},
+	{"show.name.s01e01.720p.hdtv.x264-lol", Release{Title: "show name", Season: 1, Episodes: []int{1}, Resolution: "720p", Source: "HDTV", Codec: "x264", Group: "lol"}},
+	{"Show.Name.S03E07E08.HDTV.XviD-GRP", Release{Title: "Show Name", Season: 3, Episodes: []int{7, 8}, Source: "HDTV", Codec: "XviD", Group: "GRP"}},
+	{"Show Name - S01E01-E03 - 720p WEBRip", Release{Title: "Show Name", Season: 1, Episodes: []int{1, 2, 3}, Resolution: "720p", Source: "WEBRip"}},
+	{"Show.Name.1x02.PDTV", Release{Title: "Show Name", Season: 1, Episodes: []int{2}, Source: "PDTV"}},
+	{"Show.Name.2019.S01E01.2160p.AMZN.WEB-DL.DDP5.1.HDR.HEVC-GROUP", Release{Title: "Show Name", Year: 2019, Season: 1, Episodes: []int{1}, Resolution: "2160p", Source: "WEB-DL", Codec: "H.265", Audio: "DDP", Channels: "5.1", Group: "GROUP"}},
+	{"Show.Name.S04E10.REPACK.1080p.WEB.h264-GRP", Release{Title: "Show Name", Season: 4, Episodes: []int{10}, Resolution: "1080p", Source: "WEB", Codec: "H.264", Group: "GRP", Repack: true}},
+	{"Show.Name.S04E10.PROPER.720p.HDTV.x264-GRP[rarbg]", Release{Title: "Show Name", Season: 4, Episodes: []int{10}, Resolution: "720p", Source: "HDTV", Codec: "x264", Group: "GRP", Proper: true}},
+	{"Show.Name.S02.1080p.BluRay.x264-GROUP", Release{Title: "Show Name", Season: 2, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GROUP"}},
+	{"Show Name Season 3 Complete 720p", Release{Title: "Show Name", Season: 3, Resolution: "720p"}},
+	{"The.Daily.Show.2020.01.31.Guest.Name.720p.WEB.h264-GRP", Release{Title: "The Daily Show", Date: "2020-01-31", Resolution: "720p", Source: "WEB", Codec: "H.264", Group: "GRP"}},
+	{"Doctor.Who.2005.S13E01.1080p.iP.WEB-DL.AAC2.0.H.264-GRP", Release{Title: "Doctor Who", Year: 2005, Season: 13, Episodes: []int{1}, Resolution: "1080p", Source: "WEB-DL", Codec: "H.264", Audio: "AAC", Channels: "2.0", Group: "GRP"}},
+
+	// scene and p2p movies
+	{"Movie.Name.2010.1080p.BluRay.x264-GROUP", Release{Title: "Movie Name", Year: 2010, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GROUP"}},
+	{"Movie Name (2010) [1080p]", Release{Title: "Movie Name", Year: 2010, Resolution: "1080p"}},
+	{"Movie.Name.2010.EXTENDED.720p.BRRip.XviD.AC3-GRP", Release{Title: "Movie Name", Year: 2010, Resolution: "720p", Source: "BDRip", Codec: "XviD", Audio: "DD", Group: "GRP", Edition: "Extended"}},
+	{"Movie.Name.2001.Directors.Cut.1080p.BluRay.DTS-HD.MA.5.1.x264-GRP", Release{Title: "Movie Name", Year: 2001, Resolution: "1080p", Source: "BluRay", Codec: "x264", Audio: "DTS-HD", Channels: "5.1", Group: "GRP", Edition: "Director's Cut"}},
+	{"Movie.Name.1999.REMASTERED.2160p.UHD.BluRay.TrueHD.7.1.Atmos.HEVC-GRP", Release{Title: "Movie Name", Year: 1999, Resolution: "2160p", Source: "BluRay", Codec: "H.265", Audio: "TrueHD", Channels: "7.1", Group: "GRP", Edition: "Remastered"}},
+	{"Movie.Name.2019.UNRATED.DVDRip.x264-GRP", Release{Title: "Movie Name", Year: 2019, Source: "DVDRip", Codec: "x264", Group: "GRP", Edition: "Unrated"}},
+	{"Movie.Name.2018.IMAX.1080p.WEBRip.x265.AAC5.1-GRP", Release{Title: "Movie Name", Year: 2018, Resolution: "1080p", Source: "WEBRip", Codec: "x265", Audio: "AAC", Channels: "5.1", Group: "GRP", Edition: "IMAX"}},
+	{"Movie Name 2021 1080p WEB-DL DD5.1 H264-GRP", Release{Title: "Movie Name", Year: 2021, Resolution: "1080p", Source: "WEB-DL", Codec: "H.264", Audio: "DD", Channels: "5.1", Group: "GRP"}},
+	{"Movie.Name.2020.HDCAM.x264-GRP", Release{Title: "Movie Name", Year: 2020, Source: "CAM", Codec: "x264", Group: "GRP"}},
+	{"Movie.Name.2020.720p.HDTS.x264-GRP", Release{Title: "Movie Name", Year: 2020, Resolution: "720p", Source: "TS", Codec: "x264", Group: "GRP"}},
+	{"Movie.Name.2015.4K.HDR.x265", Release{Title: "Movie Name", Year: 2015, Resolution: "2160p", Codec: "x265"}},
+	{"Movie.Name.2003.DVD9.FLAC", Release{Title: "Movie Name", Year: 2003, Source: "DVD", Audio: "FLAC"}},
+	{"movie_name_2012_720p_hdrip", Release{Title: "movie name", Year: 2012, Resolution: "720p", Source: "HDRip"}},
+
+	// titles that look like other things
+	{"2012.2009.1080p.BluRay.x264-GRP", Release{Title: "2012", Year: 2009, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GRP"}},
+	{"1917.2019.2160p.WEB-DL.DDP5.1.HEVC-GRP", Release{Title: "1917", Year: 2019, Resolution: "2160p", Source: "WEB-DL", Codec: "H.265", Audio: "DDP", Channels: "5.1", Group: "GRP"}},
+	{"Blade.Runner.2049.2017.1080p.BluRay.x264-GRP", Release{Title: "Blade Runner 2049", Year: 2017, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GRP"}},
+	{"Spider-Man.No.Way.Home.2021.1080p.WEB-DL", Release{Title: "Spider-Man No Way Home", Year: 2021, Resolution: "1080p", Source: "WEB-DL"}},
+	{"Spider-Man", Release{Title: "Spider-Man"}},
+	{"The.Web.2020.1080p.WEB.x264-GRP", Release{Title: "The Web", Year: 2020, Resolution: "1080p", Source: "WEB", Codec: "x264", Group: "GRP"}},
+	{"Extended Family 2023 720p", Release{Title: "Extended Family", Year: 2023, Resolution: "720p"}},
+	{"Proper Gander 1080p", Release{Title: "Proper Gander", Resolution: "1080p"}},
+	{"It", Release{Title: "It"}},
+	{"Up.2009.mkv", Release{Title: "Up", Year: 2009}},
+	{"Some Album - FLAC", Release{Title: "Some Album", Audio: "FLAC"}},
+	{"Show.Name.S01", Release{Title: "Show Name", Season: 1}},
+
+	// fansubs
+	{"[SubsPlease] Show Name - 01 (1080p) [ABCD1234].mkv", Release{Title: "Show Name - 01", Resolution: "1080p", Group: "SubsPlease"}},
+	{"[Group] Show Name S2 - 05 [720p].mkv", Release{Title: "Show Name", Season: 2, Resolution: "720p", Group: "Group"}},
+}
+
+func TestRelease(t *testing.T) {
+
+	Convey("Test release corpus", t, func() {
+		for _, c := range corpus {
+			Convey(c.name, func() {
+				So(Parse(c.name), ShouldResemble, c.expected)
+			})
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package routing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/classify"
+	"github.com/MondayHopscotch/SuperScope/link"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Strategy decides which parts of a completed payload directory get placed in the media dir
+type Strategy string
+
+const (
+	// StrategyFolder places the whole payload as-is
+	StrategyFolder Strategy = "folder"
+	// StrategyLargest places only the largest file, named after the original torrent
+	StrategyLargest Strategy = "largest"
+	// StrategyMedia places every media file in the payload
+	StrategyMedia Strategy = "media"
+	// StrategyExtract is an alias for StrategyMedia, which like every strategy but StrategyFolder places the media
+	// inside archives in the payload rather than the archives
+	StrategyExtract Strategy = "extract"
+	// StrategyTV places every episode as <Show>/Season NN/<Show> - SxxEyy.ext
+	StrategyTV Strategy = "tv"
+)
+
+const DefaultDestination = "{{.SubDir}}"
+
+// DefaultSidecars are placed alongside media when a rule doesn't list its own
+var DefaultSidecars = []string{"*.srt", "*.ass", "*.ssa", "*.sub", "*.idx", "*.vtt", "*.sup", "*.nfo", "*.jpg", "*.jpeg", "*.png"}
+
+// Rule maps torrents to a category. A rule matches when every one of Dir, Glob and Regex that is set matches the
+// torrent's path relative to the watch root. Paths always use '/' and Dir and Glob matching ignores case
+type Rule struct {
+	Name string `yaml:"name"`
+
+	// Dir matches a watch subdirectory and everything below it, e.g. "tv" or "kids/movies"
+	Dir string `yaml:"dir"`
+	// Glob is matched against the whole relative path with path.Match, e.g. "*/tv/*.torrent"
+	Glob string `yaml:"glob"`
+	// Regex is matched anywhere in the relative path
+	Regex string `yaml:"regex"`
+
+	Strategy Strategy `yaml:"strategy"`
+	// Destination is a text/template for the directory, relative to the media dir, to place the payload in.
+	// See Vars for what's available. Defaults to mirroring the torrent's watch subdirectory
+	Destination string `yaml:"destination"`
+	// Naming is a text/template for the path of each placed file, relative to the destination directory, e.g.
+	// "{{.Title}} ({{.Year}})/{{.Title}} ({{.Year}}) - {{.Resolution}}{{.Ext}}". Empty keeps the strategy's names
+	Naming string `yaml:"naming"`
+	// LinkMode is how the payload gets into the media dir, one of link.Modes. Empty uses link.DefaultMode
+	LinkMode string `yaml:"link"`
+	// Sidecars are path.Match patterns for companion files, like subtitles and artwork, placed alongside the media
+	// files they belong to and renamed to match. Matching ignores case. Unset uses DefaultSidecars, and an empty
+	// list places none. The folder strategy always places everything
+	Sidecars []string `yaml:"sidecars"`
+
+	// Kinds are which kinds of media file, as worked out by classify.Classify, are placed. Defaults to just media,
+	// leaving out samples, trailers and extras. Files classified as other, like subtitles, are left to Sidecars
+	Kinds []classify.Kind `yaml:"kinds"`
+	// Include and Exclude are path.Match patterns checked against the name of each file in the payload and its
+	// path within it, ignoring case. Included files are placed whatever their kind, and excluded ones never are.
+	// None of Kinds, Include, Exclude or MinSize apply to the folder strategy
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// MinSize is the size below which videos are samples, like "50MB"
+	MinSize classify.Size `yaml:"min_size"`
+}
+
+// Vars are available to Destination and Naming templates. File, Ext, Season and Episode are only set for Naming
+type Vars struct {
+	// Category is the name of the matched rule
+	Category string
+	// SubDir is the directory the torrent was found in, relative to the watch root
+	SubDir string
+	// Name is the original torrent's file name without its extension
+	Name string
+	// Completed is the name of the payload in the completed dir
+	Completed string
+	// Title and Year are parsed from the payload's release name, e.g. "Movie Name" and 2010. Year is 0 if the name
+	// doesn't have one
+	Title string
+	Year  int
+
+	// Resolution, Source, Codec, Group and Edition are parsed from the placed file's name, falling back to the
+	// payload's, e.g. 1080p, WEB-DL, x264, GROUP and Extended
+	Resolution string
+	Source     string
+	Codec      string
+	Group      string
+	Edition    string
+	// Season and Episode are set for episodes, e.g. 1 and S01E02. Episode is the air date for daily shows
+	Season  int
+	Episode string
+
+	// File is the placed file's name without its extension, and Ext its extension with the dot, e.g. ".mkv"
+	File string
+	Ext  string
+}
+
+// Route is a compiled Rule
+type Route struct {
+	Rule
+	regex       *regexp.Regexp
+	destination *template.Template
+	naming      *template.Template
+}
+
+type Router struct {
+	routes   []*Route
+	fallback *Route
+}
+
+var strategies = []Strategy{StrategyFolder, StrategyLargest, StrategyMedia, StrategyExtract, StrategyTV}
+
+// NewRouter compiles rules, which are tried in order. Torrents matching no rule are placed whole in their mirrored
+// subdirectory, under a route with no name
+func NewRouter(rules []Rule) (*Router, error) {
+	router := &Router{}
+	names := make(map[string]bool, 0)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("category %v has no name", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("category %v is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Dir == "" && rule.Glob == "" && rule.Regex == "" {
+			return nil, fmt.Errorf("category %v needs at least one of dir, glob or regex", rule.Name)
+		}
+		route, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("category %v: %v", rule.Name, err)
+		}
+		router.routes = append(router.routes, route)
+	}
+
+	router.fallback, _ = compile(Rule{})
+	return router, nil
+}
+
+func compile(rule Rule) (*Route, error) {
+	if rule.Strategy == "" {
+		rule.Strategy = StrategyFolder
+	} else if rule.Strategy == StrategyExtract {
+		rule.Strategy = StrategyMedia
+	}
+	known := false
+	for _, strategy := range strategies {
+		known = known || rule.Strategy == strategy
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown strategy %q", rule.Strategy)
+	}
+
+	if rule.LinkMode != "" && !link.IsMode(rule.LinkMode) {
+		return nil, fmt.Errorf("unknown link mode %q", rule.LinkMode)
+	}
+
+	if rule.Sidecars == nil {
+		rule.Sidecars = DefaultSidecars
+	}
+	for _, pattern := range rule.Sidecars {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("bad sidecar pattern %q: %v", pattern, err)
+		}
+	}
+
+	if rule.Kinds == nil {
+		rule.Kinds = []classify.Kind{classify.Media}
+	}
+	for _, kind := range rule.Kinds {
+		if !classify.IsKind(kind) {
+			return nil, fmt.Errorf("unknown kind %q", kind)
+		}
+	}
+	for _, pattern := range append(append([]string{}, rule.Include...), rule.Exclude...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("bad include or exclude pattern %q: %v", pattern, err)
+		}
+	}
+
+	rule.Dir = strings.Trim(rule.Dir, "/")
+	if rule.Glob != "" {
+		_, err := path.Match(rule.Glob, "")
+		if err != nil {
+			return nil, fmt.Errorf("bad glob %q: %v", rule.Glob, err)
+		}
+	}
+
+	route := &Route{Rule: rule}
+	if rule.Regex != "" {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex %q: %v", rule.Regex, err)
+		}
+		route.regex = regex
+	}
+
+	if route.Destination == "" {
+		route.Destination = DefaultDestination
+	}
+	destination, err := template.New(rule.Name).Option("missingkey=error").Parse(route.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("bad destination template: %v", err)
+	}
+	route.destination = destination
+
+	if route.Naming != "" {
+		naming, err := template.New(rule.Name).Option("missingkey=error").Parse(route.Naming)
+		if err != nil {
+			return nil, fmt.Errorf("bad naming template: %v", err)
+		}
+		route.naming = naming
+	}
+	return route, nil
+}
+
+// Route returns the first route matching relPath, the torrent's path relative to the watch root
+func (r *Router) Route(relPath string) *Route {
+	relPath = strings.TrimPrefix(path.Clean(strings.Replace(relPath, "\\", "/", -1)), "/")
+	for _, route := range r.routes {
+		if route.matches(relPath) {
+			return route
+		}
+	}
+	return r.fallback
+}
+
+func (r *Route) matches(relPath string) bool {
+	lowerPath := strings.ToLower(relPath)
+	if r.Dir != "" {
+		dir := strings.ToLower(r.Dir) + "/"
+		if !strings.HasPrefix(lowerPath, dir) {
+			return false
+		}
+	}
+	if r.Glob != "" {
+		matched, _ := path.Match(strings.ToLower(r.Glob), lowerPath)
+		if !matched {
+			return false
+		}
+	}
+	if r.regex != nil && !r.regex.MatchString(relPath) {
+		return false
+	}
+	return true
+}
+
+// IsSidecar returns true if name matches one of the route's sidecar patterns
+func (r *Route) IsSidecar(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	for _, pattern := range r.Sidecars {
+		if matched, _ := path.Match(strings.ToLower(pattern), base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if a file at relPath within the payload, classified as kind, may be placed
+func (r *Route) Allows(relPath string, kind classify.Kind) bool {
+	if matchesAny(r.Exclude, relPath) {
+		return false
+	}
+	if matchesAny(r.Include, relPath) || kind == classify.Other {
+		return true
+	}
+	for _, allowed := range r.Kinds {
+		if kind == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny returns true if any of patterns matches relPath or its base name, ignoring case
+func matchesAny(patterns []string, relPath string) bool {
+	relPath = strings.ToLower(relPath)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(relPath)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// DestinationDir renders the route's destination template and joins it to mediaDir. The result may not escape
+// mediaDir
+func (r *Route) DestinationDir(mediaDir string, vars Vars) (string, error) {
+	relDest, err := render(r.destination, vars)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(mediaDir, relDest), nil
+}
+
+// FileName renders the route's naming template for a single placed file, relative to the destination directory.
+// It returns false if the route has no naming template
+func (r *Route) FileName(vars Vars) (string, bool, error) {
+	if r.naming == nil {
+		return "", false, nil
+	}
+	name, err := render(r.naming, vars)
+	if err != nil {
+		return "", true, err
+	}
+	if name == "" {
+		return "", true, fmt.Errorf("naming template gave an empty name")
+	}
+	return name, true, nil
+}
+
+// render executes tmpl and cleans the result into a relative path that can't escape its parent, with every part
+// made safe for any filesystem
+func render(tmpl *template.Template, vars Vars) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, vars)
+	if err != nil {
+		return "", err
+	}
+	rendered := strings.TrimPrefix(path.Clean("/"+strings.Replace(buf.String(), "\\", "/", -1)), "/")
+	if rendered == "" {
+		return "", nil
+	}
+	parts := strings.Split(rendered, "/")
+	for i, part := range parts {
+		parts[i] = util.SanitizeFileName(part)
+	}
+	return path.Join(parts...), nil
+}
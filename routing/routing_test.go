@@ -0,0 +1,206 @@
+package routing
+
+import (
+	"github.com/MondayHopscotch/SuperScope/classify"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestRouting(t *testing.T) {
+
+	Convey("Test dir rules match whole path segments", t, func() {
+		router, err := NewRouter([]Rule{{Name: "tv", Dir: "tv"}})
+		So(err, ShouldBeNil)
+
+		So(router.Route("tv/show.torrent").Name, ShouldEqual, "tv")
+		So(router.Route("TV/nested/show.torrent").Name, ShouldEqual, "tv")
+		So(router.Route("documentaries/tvnz/show.torrent").Name, ShouldEqual, "")
+		So(router.Route("tvnz/show.torrent").Name, ShouldEqual, "")
+	})
+
+	Convey("Test glob rules", t, func() {
+		router, err := NewRouter([]Rule{{Name: "kids", Glob: "*/kids/*.torrent"}})
+		So(err, ShouldBeNil)
+
+		So(router.Route("movies/kids/film.torrent").Name, ShouldEqual, "kids")
+		So(router.Route("movies/film.torrent").Name, ShouldEqual, "")
+	})
+
+	Convey("Test regex rules", t, func() {
+		router, err := NewRouter([]Rule{{Name: "music", Regex: "(?i)(music|flac)"}})
+		So(err, ShouldBeNil)
+
+		So(router.Route("audio/FLAC/album.torrent").Name, ShouldEqual, "music")
+		So(router.Route("audio/album.torrent").Name, ShouldEqual, "")
+	})
+
+	Convey("Test every condition must match", t, func() {
+		router, err := NewRouter([]Rule{{Name: "hd", Dir: "movies", Regex: "1080p"}})
+		So(err, ShouldBeNil)
+
+		So(router.Route("movies/film.1080p.torrent").Name, ShouldEqual, "hd")
+		So(router.Route("movies/film.720p.torrent").Name, ShouldEqual, "")
+		So(router.Route("tv/show.1080p.torrent").Name, ShouldEqual, "")
+	})
+
+	Convey("Test rules are tried in order", t, func() {
+		router, err := NewRouter([]Rule{
+			{Name: "kids", Dir: "movies/kids", Strategy: StrategyMedia},
+			{Name: "movies", Dir: "movies", Strategy: StrategyLargest},
+		})
+		So(err, ShouldBeNil)
+
+		So(router.Route("movies/kids/film.torrent").Name, ShouldEqual, "kids")
+		So(router.Route("movies/film.torrent").Name, ShouldEqual, "movies")
+	})
+
+	Convey("Test fallback places the whole payload", t, func() {
+		router, err := NewRouter(nil)
+		So(err, ShouldBeNil)
+
+		route := router.Route("anything/at/all.torrent")
+		So(route.Name, ShouldEqual, "")
+		So(route.Strategy, ShouldEqual, StrategyFolder)
+	})
+
+	Convey("Test extract is another name for media", t, func() {
+		router, err := NewRouter([]Rule{{Name: "music", Dir: "music", Strategy: StrategyExtract}})
+		So(err, ShouldBeNil)
+		So(router.Route("music/album.torrent").Strategy, ShouldEqual, StrategyMedia)
+	})
+
+	Convey("Test default destination mirrors the sub directory", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "movies", Dir: "movies"}})
+		route := router.Route("movies/action/film.torrent")
+
+		dest, err := route.DestinationDir("media", Vars{Category: "movies", SubDir: "movies/action", Name: "film"})
+		So(err, ShouldBeNil)
+		So(dest, ShouldEqual, "media/movies/action")
+	})
+
+	Convey("Test destination template", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "music", Dir: "music", Destination: "{{.Category}}/{{.Name}}"}})
+		route := router.Route("music/album.torrent")
+
+		dest, err := route.DestinationDir("/media", Vars{Category: "music", SubDir: "music", Name: "album"})
+		So(err, ShouldBeNil)
+		So(dest, ShouldEqual, "/media/music/album")
+	})
+
+	Convey("Test destination template with release title", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "movies", Dir: "movies", Destination: "movies/{{.Title}}{{if .Year}} ({{.Year}}){{end}}"}})
+		route := router.Route("movies/film.torrent")
+
+		dest, err := route.DestinationDir("/media", Vars{Category: "movies", Title: "Film", Year: 2010})
+		So(err, ShouldBeNil)
+		So(dest, ShouldEqual, "/media/movies/Film (2010)")
+
+		dest, err = route.DestinationDir("/media", Vars{Category: "movies", Title: "Film"})
+		So(err, ShouldBeNil)
+		So(dest, ShouldEqual, "/media/movies/Film")
+	})
+
+	Convey("Test naming template", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "movies", Dir: "movies", Naming: "{{.Title}} ({{.Year}})/{{.Title}}: {{.Edition}}{{.Ext}}"}})
+		route := router.Route("movies/film.torrent")
+
+		name, ok, err := route.FileName(Vars{Title: "Film", Year: 2010, Edition: "Extended", Ext: ".mkv"})
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(name, ShouldEqual, "Film (2010)/Film - Extended.mkv")
+
+		name, ok, err = router.Route("tv/show.torrent").FileName(Vars{})
+		So(ok, ShouldBeFalse)
+
+		_, err = NewRouter([]Rule{{Name: "movies", Dir: "movies", Naming: "{{.Title"}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test naming can not escape the destination or be empty", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "sneaky", Dir: "x", Naming: "../../{{.File}}"}})
+		route := router.Route("x/y.torrent")
+
+		name, _, err := route.FileName(Vars{File: "passwd"})
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "passwd")
+
+		_, _, err = route.FileName(Vars{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test sidecar patterns", t, func() {
+		router, err := NewRouter([]Rule{
+			{Name: "movies", Dir: "movies"},
+			{Name: "music", Dir: "music", Sidecars: []string{"*.cue", "*.LOG"}},
+			{Name: "bare", Dir: "bare", Sidecars: []string{}},
+		})
+		So(err, ShouldBeNil)
+
+		So(router.Route("movies/film.torrent").IsSidecar("Subs/English.SRT"), ShouldBeTrue)
+		So(router.Route("movies/film.torrent").IsSidecar("film.mkv"), ShouldBeFalse)
+		So(router.Route("music/album.torrent").IsSidecar("album.log"), ShouldBeTrue)
+		So(router.Route("music/album.torrent").IsSidecar("cover.jpg"), ShouldBeFalse)
+		So(router.Route("bare/thing.torrent").IsSidecar("thing.srt"), ShouldBeFalse)
+
+		_, err = NewRouter([]Rule{{Name: "movies", Dir: "movies", Sidecars: []string{"[.srt"}}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test which kinds of file are placed", t, func() {
+		router, err := NewRouter([]Rule{
+			{Name: "movies", Dir: "movies"},
+			{Name: "extras", Dir: "extras", Kinds: []classify.Kind{classify.Media, classify.Extra}, Include: []string{"*/keep-me.*"}, Exclude: []string{"*.iso", "Bonus/*"}},
+		})
+		So(err, ShouldBeNil)
+
+		movies := router.Route("movies/film.torrent")
+		So(movies.Allows("film.mkv", classify.Media), ShouldBeTrue)
+		So(movies.Allows("film.srt", classify.Other), ShouldBeTrue)
+		So(movies.Allows("Sample/sample.mkv", classify.Sample), ShouldBeFalse)
+		So(movies.Allows("Extras/interview.mkv", classify.Extra), ShouldBeFalse)
+
+		extras := router.Route("extras/film.torrent")
+		So(extras.Allows("Extras/interview.mkv", classify.Extra), ShouldBeTrue)
+		So(extras.Allows("Bonus/interview.mkv", classify.Extra), ShouldBeFalse)
+		So(extras.Allows("film.ISO", classify.Other), ShouldBeFalse)
+		So(extras.Allows("Sample/keep-me.mkv", classify.Sample), ShouldBeTrue)
+
+		_, err = NewRouter([]Rule{{Name: "movies", Dir: "movies", Kinds: []classify.Kind{"bloopers"}}})
+		So(err, ShouldNotBeNil)
+		_, err = NewRouter([]Rule{{Name: "movies", Dir: "movies", Exclude: []string{"[iso"}}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test destination can not escape the media dir", t, func() {
+		router, _ := NewRouter([]Rule{{Name: "sneaky", Dir: "x", Destination: "../../etc"}})
+		dest, err := router.Route("x/y.torrent").DestinationDir("/media", Vars{})
+		So(err, ShouldBeNil)
+		So(dest, ShouldEqual, "/media/etc")
+	})
+
+	Convey("Test invalid rules", t, func() {
+		_, err := NewRouter([]Rule{{Dir: "tv"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Dir: "tv"}, {Name: "tv", Dir: "shows"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Dir: "tv", Strategy: "shuffle"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Dir: "tv", LinkMode: "teleport"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Regex: "("}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Glob: "["}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewRouter([]Rule{{Name: "tv", Dir: "tv", Destination: "{{.Nope"}})
+		So(err, ShouldNotBeNil)
+	})
+}
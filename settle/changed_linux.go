@@ -0,0 +1,19 @@
+package settle
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime is the later of info's modification and status change times. The status change time can't be set by
+// the tools that copy payloads around, so files moved in with their original timestamps still look new
+func changeTime(info os.FileInfo) time.Time {
+	changed := info.ModTime()
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if ctime := time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)); ctime.After(changed) {
+			changed = ctime
+		}
+	}
+	return changed
+}
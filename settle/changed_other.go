@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package settle
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime is info's modification time
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,159 @@
+package settle
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is how far a payload is from being safe to finalize
+type State int
+
+const (
+	// Settling payloads are still being written, or haven't been quiet for long enough to tell
+	Settling State = iota
+	// Settled payloads haven't changed for the whole window
+	Settled
+	// Expired payloads kept changing for longer than the max wait, and are taken as they are
+	Expired
+)
+
+// Snapshot is what a payload looked like when it was scanned
+type Snapshot struct {
+	Size  int64
+	Files int
+	// Changed is the latest time anything in the payload was written, added or renamed
+	Changed time.Time
+}
+
+// Scan totals up the file or directory at payload
+func Scan(payload string) (Snapshot, error) {
+	var snapshot Snapshot
+	err := filepath.Walk(payload, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if changed := changeTime(info); changed.After(snapshot.Changed) {
+			snapshot.Changed = changed
+		}
+		if !info.IsDir() {
+			snapshot.Size += info.Size()
+			snapshot.Files++
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+func (s Snapshot) equal(other Snapshot) bool {
+	return s.Size == other.Size && s.Files == other.Files && s.Changed.Equal(other.Changed)
+}
+
+// Detector remembers the payloads it has been asked about, so it can tell when they stop changing
+type Detector struct {
+	lock    sync.Mutex
+	pending map[string]*payload
+	now     func() time.Time
+}
+
+type payload struct {
+	snapshot Snapshot
+	scanned  bool
+	// first is when we started waiting for the payload, and changed is the last time we know it changed
+	first   time.Time
+	changed time.Time
+}
+
+func NewDetector() *Detector {
+	return &Detector{pending: make(map[string]*payload, 0), now: time.Now}
+}
+
+// Check scans the payload at path, known as name, and reports whether it has been quiet for window. A payload seen
+// for the first time counts as quiet since its newest file changed, and after that any change in its size, file
+// count or timestamps starts the window again. Payloads still changing after maxWait expire. A window of 0 settles
+// everything straight away, and a maxWait of 0 waits forever
+func (d *Detector) Check(name string, path string, window time.Duration, maxWait time.Duration) State {
+	if window <= 0 {
+		return Settled
+	}
+	snapshot, err := Scan(path)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	p, ok := d.pending[name]
+	if !ok {
+		p = &payload{first: now}
+		d.pending[name] = p
+	}
+	seen := p.scanned
+	switch {
+	case err != nil:
+		// something moved or vanished while we were looking
+		p.changed = now
+	case !p.scanned:
+		changed := snapshot.Changed
+		if changed.After(now) {
+			changed = now
+		}
+		if changed.After(p.changed) {
+			p.changed = changed
+		}
+	case !snapshot.equal(p.snapshot):
+		p.changed = now
+	}
+	p.snapshot, p.scanned = snapshot, err == nil
+
+	if now.Sub(p.changed) >= window {
+		delete(d.pending, name)
+		return Settled
+	}
+	if maxWait > 0 && now.Sub(p.first) >= maxWait {
+		log.Println("Gave up waiting for ", name, " to settle after ", maxWait, ", taking it as it is")
+		delete(d.pending, name)
+		return Expired
+	}
+	if !seen {
+		log.Println("Waiting for ", name, " to stop changing before finalizing it")
+	}
+	return Settling
+}
+
+// Touch records that something in the payload called name just changed
+func (d *Detector) Touch(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	p, ok := d.pending[name]
+	if !ok {
+		p = &payload{first: now}
+		d.pending[name] = p
+	}
+	p.changed = now
+}
+
+// Pending is how many payloads Check has seen that are still waiting to settle
+func (d *Detector) Pending() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	pending := 0
+	for _, p := range d.pending {
+		if p.scanned {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Retain forgets every payload not in names, like ones that were removed or finalized
+func (d *Detector) Retain(names map[string]bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for name := range d.pending {
+		if !names[name] {
+			delete(d.pending, name)
+		}
+	}
+}
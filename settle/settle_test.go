@@ -0,0 +1,126 @@
+package settle
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSettle(t *testing.T) {
+
+	Convey("Test scanning a payload", t, func() {
+		resetTestDir()
+		os.MkdirAll("test/Movie/Subs", os.ModePerm)
+		ioutil.WriteFile("test/Movie/movie.mkv", []byte("movie"), os.ModePerm)
+		ioutil.WriteFile("test/Movie/Subs/en.srt", []byte("subs"), os.ModePerm)
+
+		snapshot, err := Scan("test/Movie")
+		So(err, ShouldBeNil)
+		So(snapshot.Size, ShouldEqual, 9)
+		So(snapshot.Files, ShouldEqual, 2)
+		So(time.Since(snapshot.Changed), ShouldBeLessThan, time.Minute)
+
+		_, err = Scan("test/missing")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test a payload settles once it stops changing", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.mkv", []byte("half"), os.ModePerm)
+		clock := time.Now()
+		detector := testDetector(&clock)
+
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settling)
+
+		clock = clock.Add(time.Second * 30)
+		ioutil.WriteFile("test/movie.mkv", []byte("half and the rest"), os.ModePerm)
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settling)
+
+		clock = clock.Add(time.Second * 45)
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settling)
+
+		clock = clock.Add(time.Second * 15)
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settled)
+	})
+
+	Convey("Test a payload that finished changing long ago settles straight away", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.mkv", []byte("movie"), os.ModePerm)
+		clock := time.Now().Add(time.Hour)
+		detector := testDetector(&clock)
+
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settled)
+	})
+
+	Convey("Test writes restart the window", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.mkv", []byte("movie"), os.ModePerm)
+		clock := time.Now().Add(time.Hour)
+		detector := testDetector(&clock)
+
+		detector.Touch("movie.mkv")
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settling)
+
+		clock = clock.Add(time.Minute)
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Hour), ShouldEqual, Settled)
+	})
+
+	Convey("Test a payload that never settles expires", t, func() {
+		resetTestDir()
+		clock := time.Now()
+		detector := testDetector(&clock)
+
+		for i := 0; i < 5; i++ {
+			ioutil.WriteFile("test/movie.mkv", make([]byte, i), os.ModePerm)
+			So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Minute*5), ShouldEqual, Settling)
+			clock = clock.Add(time.Second * 59)
+		}
+		ioutil.WriteFile("test/movie.mkv", make([]byte, 5), os.ModePerm)
+		clock = clock.Add(time.Second * 5)
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Minute*5), ShouldEqual, Expired)
+	})
+
+	Convey("Test a missing payload never settles", t, func() {
+		resetTestDir()
+		clock := time.Now()
+		detector := testDetector(&clock)
+
+		So(detector.Check("missing", "test/missing", time.Minute, 0), ShouldEqual, Settling)
+		clock = clock.Add(time.Hour)
+		So(detector.Check("missing", "test/missing", time.Minute, 0), ShouldEqual, Settling)
+	})
+
+	Convey("Test no window settles everything", t, func() {
+		So(NewDetector().Check("missing", "test/missing", 0, 0), ShouldEqual, Settled)
+	})
+
+	Convey("Test forgotten payloads start over", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.mkv", []byte("movie"), os.ModePerm)
+		clock := time.Now()
+		detector := testDetector(&clock)
+
+		So(detector.Check("movie.mkv", "test/movie.mkv", time.Minute, time.Minute), ShouldEqual, Settling)
+		detector.Touch("other.mkv")
+		So(detector.Pending(), ShouldEqual, 1)
+
+		detector.Retain(map[string]bool{"other.mkv": true})
+		So(detector.Pending(), ShouldEqual, 0)
+		So(detector.pending, ShouldContainKey, "other.mkv")
+	})
+}
+
+func testDetector(clock *time.Time) *Detector {
+	detector := NewDetector()
+	detector.now = func() time.Time {
+		return *clock
+	}
+	return detector
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
@@ -0,0 +1,273 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/MondayHopscotch/SuperScope/torrent"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	stateFileName  = "superscope.json"
+	torrentDirName = "torrents"
+)
+
+type Status string
+
+const (
+	// StatusConsumed means the torrent has been handed to the client and we are waiting for it to complete
+	StatusConsumed Status = "consumed"
+	// StatusCompleted means a completed payload has been matched, but not yet linked into the media dir
+	StatusCompleted Status = "completed"
+	// StatusLinked means the payload has been placed in the media dir
+	StatusLinked Status = "linked"
+	// StatusFailed means something went wrong finalizing the payload. See Record.Error
+	StatusFailed Status = "failed"
+	// StatusCancelled means tracking was stopped by hand before a completed payload was found
+	StatusCancelled Status = "cancelled"
+)
+
+// Record tracks a single consumed torrent or magnet through its lifecycle. Info is only set if we were able to read
+// the torrent's metadata before consuming it, DisplayName only if a magnet link had one
+type Record struct {
+	Name        string        `json:"name"`
+	OrigPath    string        `json:"origPath"`
+	Status      Status        `json:"status"`
+	InfoHash    string        `json:"infoHash,omitempty"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Info        *torrent.Info `json:"info,omitempty"`
+	Completed   string        `json:"completed,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ConsumedAt  time.Time     `json:"consumedAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+}
+
+// Pending returns true if the record has not yet reached a terminal status
+func (r Record) Pending() bool {
+	return r.Status == StatusConsumed || r.Status == StatusCompleted
+}
+
+// Store keeps track of records, optionally persisting them as JSON in a state directory. Records that are no longer
+// pending are dropped once they haven't been updated for Retention, unless it is 0
+type Store struct {
+	Retention time.Duration
+
+	mutex   sync.Mutex
+	file    string
+	records map[string]Record
+}
+
+// NewMemoryStore creates a store that is never written to disk
+func NewMemoryStore() *Store {
+	return &Store{records: make(map[string]Record, 0)}
+}
+
+// OpenStore creates a store backed by a file in stateDir, loading any records already saved there
+func OpenStore(stateDir string) (*Store, error) {
+	// the state dir holds the control socket by default, so only our user may reach anything in it
+	err := os.MkdirAll(stateDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewMemoryStore()
+	store.file = filepath.Join(stateDir, stateFileName)
+
+	data, err := ioutil.ReadFile(store.file)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0)
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		store.records[record.Name] = record
+	}
+
+	log.Println("Loaded ", len(records), " records from ", store.file)
+	return store, nil
+}
+
+// Put adds or replaces the record with the same name and saves the store. The Info's piece hashes are left out, as
+// they are only needed to verify the payload and would make every save of the store very large
+func (s *Store) Put(record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if record.Info != nil && len(record.Info.Pieces) > 0 {
+		info := *record.Info
+		info.Pieces = nil
+		record.Info = &info
+	}
+	record.UpdatedAt = time.Now()
+	s.records[record.Name] = record
+	if !record.Pending() {
+		s.removeTorrent(record.Name)
+	}
+	return s.save()
+}
+
+// Get returns the record for name, if one exists
+func (s *Store) Get(name string) (Record, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[name]
+	return record, ok
+}
+
+// SetStatus updates the status of the named record. Setting a failure status with a nil errValue clears the error
+func (s *Store) SetStatus(name string, status Status, errValue error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return nil
+	}
+	record.Status = status
+	record.Error = ""
+	if errValue != nil {
+		record.Error = errValue.Error()
+	}
+	record.UpdatedAt = time.Now()
+	s.records[name] = record
+	if !record.Pending() {
+		s.removeTorrent(name)
+	}
+	return s.save()
+}
+
+// Delete removes the named record and saves the store
+func (s *Store) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, name)
+	s.removeTorrent(name)
+	return s.save()
+}
+
+// SaveTorrent keeps a copy of the named record's .torrent file until the record is finished with, so its piece
+// hashes can still be verified against after a restart. A memory store keeps nothing
+func (s *Store) SaveTorrent(name string, data []byte) error {
+	if s.file == "" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(s.torrentFile(name)), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.torrentFile(name), data, 0644)
+}
+
+// LoadTorrent parses the copy of the named record's .torrent file kept by SaveTorrent
+func (s *Store) LoadTorrent(name string) (*torrent.MetaInfo, error) {
+	if s.file == "" {
+		return nil, errors.New("no copy of " + name + " is kept by a memory store")
+	}
+	return torrent.ParseFile(s.torrentFile(name))
+}
+
+func (s *Store) torrentFile(name string) string {
+	return filepath.Join(filepath.Dir(s.file), torrentDirName, filepath.Base(name))
+}
+
+func (s *Store) removeTorrent(name string) {
+	if s.file == "" {
+		return
+	}
+	err := os.Remove(s.torrentFile(name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Unable to remove the kept copy of ", name, ": ", err)
+	}
+}
+
+// All returns every record, oldest first
+func (s *Store) All() []Record {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sorted()
+}
+
+// Pending returns every record that has not yet been linked or failed, oldest first
+func (s *Store) Pending() []Record {
+	pending := make([]Record, 0)
+	for _, record := range s.All() {
+		if record.Pending() {
+			pending = append(pending, record)
+		}
+	}
+	return pending
+}
+
+func (s *Store) sorted() []Record {
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ConsumedAt.Before(records[j].ConsumedAt)
+	})
+	return records
+}
+
+// SetRetention changes how long finished records are kept, taking effect the next time the store is saved
+func (s *Store) SetRetention(retention time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Retention = retention
+}
+
+// Save writes the store to disk again, in case saving it after an earlier change failed. A memory store does nothing
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.save()
+}
+
+// prune drops every finished record that hasn't been updated for the retention period
+func (s *Store) prune() {
+	if s.Retention <= 0 {
+		return
+	}
+	for name, record := range s.records {
+		if !record.Pending() && time.Since(record.UpdatedAt) > s.Retention {
+			delete(s.records, name)
+			s.removeTorrent(name)
+		}
+	}
+}
+
+// save writes the records to a temp file and renames it into place so a crash never leaves a partial state file
+func (s *Store) save() error {
+	s.prune()
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := s.file + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.file)
+}
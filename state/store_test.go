@@ -0,0 +1,163 @@
+package state
+
+import (
+	"errors"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	"github.com/MondayHopscotch/SuperScope/torrent"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+
+	Convey("Test memory store put and get", t, func() {
+		store := NewMemoryStore()
+		err := store.Put(Record{Name: "test.torrent", OrigPath: "watch/movies/test.torrent", Status: StatusConsumed})
+		So(err, ShouldBeNil)
+
+		record, ok := store.Get("test.torrent")
+		So(ok, ShouldBeTrue)
+		So(record.OrigPath, ShouldEqual, "watch/movies/test.torrent")
+		So(record.UpdatedAt.IsZero(), ShouldBeFalse)
+	})
+
+	Convey("Test set status records errors", t, func() {
+		store := NewMemoryStore()
+		store.Put(Record{Name: "test.torrent", Status: StatusCompleted})
+
+		err := store.SetStatus("test.torrent", StatusFailed, errors.New("broken"))
+		So(err, ShouldBeNil)
+
+		record, _ := store.Get("test.torrent")
+		So(record.Status, ShouldEqual, StatusFailed)
+		So(record.Error, ShouldEqual, "broken")
+		So(len(store.Pending()), ShouldEqual, 0)
+	})
+
+	Convey("Test pending only returns unfinished records in order", t, func() {
+		store := NewMemoryStore()
+		now := time.Now()
+		store.Put(Record{Name: "second", Status: StatusCompleted, ConsumedAt: now})
+		store.Put(Record{Name: "first", Status: StatusConsumed, ConsumedAt: now.Add(-time.Hour)})
+		store.Put(Record{Name: "done", Status: StatusLinked, ConsumedAt: now.Add(-2 * time.Hour)})
+
+		pending := store.Pending()
+		So(len(pending), ShouldEqual, 2)
+		So(pending[0].Name, ShouldEqual, "first")
+		So(pending[1].Name, ShouldEqual, "second")
+	})
+
+	Convey("Test file store survives reopening", t, func() {
+		os.RemoveAll("test")
+
+		store, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		So(len(store.All()), ShouldEqual, 0)
+		if runtime.GOOS != "windows" {
+			stat, err := os.Stat("test/state")
+			So(err, ShouldBeNil)
+			So(stat.Mode().Perm(), ShouldEqual, os.FileMode(0700))
+		}
+
+		So(store.Put(Record{Name: "keep", Status: StatusConsumed}), ShouldBeNil)
+		So(store.Put(Record{Name: "drop", Status: StatusConsumed}), ShouldBeNil)
+		So(store.Delete("drop"), ShouldBeNil)
+
+		reopened, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		So(len(reopened.All()), ShouldEqual, 1)
+
+		record, ok := reopened.Get("keep")
+		So(ok, ShouldBeTrue)
+		So(record.Status, ShouldEqual, StatusConsumed)
+
+		os.RemoveAll("test")
+	})
+
+	Convey("Test file store keeps piece hashes in a copy of the torrent", t, func() {
+		os.RemoveAll("test")
+
+		store, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		info := &torrent.Info{Name: "film.avi", PieceLength: 1, Pieces: make([]byte, 20), Length: 1}
+		So(store.Put(Record{Name: "film.torrent", Status: StatusConsumed, Info: info}), ShouldBeNil)
+		data, _ := bencode.Encode(map[string]interface{}{
+			"info": map[string]interface{}{"name": "film.avi", "piece length": int64(1), "pieces": string(make([]byte, 20)), "length": int64(1)},
+		})
+		So(store.SaveTorrent("film.torrent", data), ShouldBeNil)
+
+		reopened, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		record, _ := reopened.Get("film.torrent")
+		So(record.Info.Name, ShouldEqual, "film.avi")
+		So(record.Info.Pieces, ShouldBeEmpty)
+
+		meta, err := reopened.LoadTorrent("film.torrent")
+		So(err, ShouldBeNil)
+		So(meta.Info.Pieces, ShouldResemble, make([]byte, 20))
+
+		So(reopened.SetStatus("film.torrent", StatusLinked, nil), ShouldBeNil)
+		_, err = reopened.LoadTorrent("film.torrent")
+		So(err, ShouldNotBeNil)
+
+		_, err = NewMemoryStore().LoadTorrent("film.torrent")
+		So(err, ShouldNotBeNil)
+
+		os.RemoveAll("test")
+	})
+
+	Convey("Test finished records are pruned after the retention period", t, func() {
+		store := NewMemoryStore()
+		store.Retention = time.Hour
+		store.Put(Record{Name: "old", Status: StatusLinked})
+		store.Put(Record{Name: "waiting", Status: StatusConsumed})
+		store.records["old"] = Record{Name: "old", Status: StatusLinked, UpdatedAt: time.Now().Add(-time.Hour * 2)}
+		store.records["waiting"] = Record{Name: "waiting", Status: StatusConsumed, UpdatedAt: time.Now().Add(-time.Hour * 2)}
+
+		So(store.Put(Record{Name: "new", Status: StatusFailed}), ShouldBeNil)
+		_, ok := store.Get("old")
+		So(ok, ShouldBeFalse)
+		_, ok = store.Get("waiting")
+		So(ok, ShouldBeTrue)
+		_, ok = store.Get("new")
+		So(ok, ShouldBeTrue)
+
+		store.Retention = 0
+		store.records["new"] = Record{Name: "new", Status: StatusFailed, UpdatedAt: time.Now().Add(-time.Hour * 2)}
+		So(store.SetStatus("waiting", StatusCancelled, nil), ShouldBeNil)
+		So(len(store.All()), ShouldEqual, 2)
+	})
+
+	Convey("Test stored records leave out piece hashes", t, func() {
+		store := NewMemoryStore()
+		info := &torrent.Info{Name: "film.avi", Pieces: make([]byte, 20)}
+		store.Put(Record{Name: "film.torrent", Status: StatusConsumed, Info: info})
+
+		record, _ := store.Get("film.torrent")
+		So(record.Info.Name, ShouldEqual, "film.avi")
+		So(record.Info.Pieces, ShouldBeNil)
+		So(info.Pieces, ShouldHaveLength, 20)
+	})
+
+	Convey("Test saving again restores the state file", t, func() {
+		os.RemoveAll("test")
+
+		So(NewMemoryStore().Save(), ShouldBeNil)
+
+		store, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		So(store.Put(Record{Name: "keep", Status: StatusConsumed}), ShouldBeNil)
+		os.Remove("test/state/" + stateFileName)
+
+		So(store.Save(), ShouldBeNil)
+		reopened, err := OpenStore("test/state")
+		So(err, ShouldBeNil)
+		_, ok := reopened.Get("keep")
+		So(ok, ShouldBeTrue)
+
+		os.RemoveAll("test")
+	})
+}
@@ -0,0 +1,176 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPieceLength is the largest piece length we accept, well beyond what any client creates
+const maxPieceLength = 1 << 28
+
+// File is a single file inside a multi-file torrent. Path is relative to the torrent's Name, separated by '/'
+type File struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// Info holds the parts of a torrent's info dictionary we need to find, verify and place its payload.
+// Pieces is the concatenation of the 20 byte SHA-1 hash of every piece. It can run to hundreds of KB, so it is
+// never serialized along with the rest
+type Info struct {
+	Name        string `json:"name"`
+	PieceLength int64  `json:"pieceLength"`
+	Pieces      []byte `json:"-"`
+	Length      int64  `json:"length,omitempty"`
+	Files       []File `json:"files,omitempty"`
+}
+
+// MetaInfo is a parsed .torrent file
+type MetaInfo struct {
+	Announce string
+	InfoHash string
+	Info     Info
+}
+
+func ParseFile(path string) (*MetaInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (*MetaInfo, error) {
+	root, raw, err := bencode.DecodeDict(data)
+	if err != nil {
+		return nil, err
+	}
+	infoDict, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent: missing info dictionary")
+	}
+
+	meta := &MetaInfo{}
+	meta.Announce, _ = root["announce"].(string)
+
+	// the infohash is the sha1 of the info dictionary exactly as it appears in the file. Re-encoding it would
+	// sort its keys and give the wrong hash for torrents that aren't canonically encoded
+	hash := sha1.Sum(raw["info"])
+	meta.InfoHash = hex.EncodeToString(hash[:])
+
+	meta.Info.Name, ok = infoDict["name"].(string)
+	if !ok || meta.Info.Name == "" {
+		return nil, errors.New("torrent: info dictionary has no name")
+	}
+	if !safePathPart(meta.Info.Name) {
+		return nil, fmt.Errorf("torrent: name %q is not a plain file name", meta.Info.Name)
+	}
+	meta.Info.PieceLength, _ = infoDict["piece length"].(int64)
+	if meta.Info.PieceLength <= 0 || meta.Info.PieceLength > maxPieceLength {
+		return nil, fmt.Errorf("torrent: piece length %v is out of range", meta.Info.PieceLength)
+	}
+	pieces, _ := infoDict["pieces"].(string)
+	if len(pieces)%sha1.Size != 0 {
+		return nil, errors.New("torrent: pieces is not a multiple of 20 bytes")
+	}
+	meta.Info.Pieces = []byte(pieces)
+
+	if files, ok := infoDict["files"].([]interface{}); ok {
+		for _, f := range files {
+			fileDict, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("torrent: malformed file entry")
+			}
+			length, _ := fileDict["length"].(int64)
+			pathParts, ok := fileDict["path"].([]interface{})
+			if !ok || len(pathParts) == 0 {
+				return nil, errors.New("torrent: file entry has no path")
+			}
+			parts := make([]string, 0, len(pathParts))
+			for _, part := range pathParts {
+				partString, ok := part.(string)
+				if !ok {
+					return nil, errors.New("torrent: malformed file path")
+				}
+				// the path is joined onto the payload dir, so it mustn't lead anywhere outside it
+				if !safePathPart(partString) {
+					return nil, fmt.Errorf("torrent: file path %q is not relative to the payload", partString)
+				}
+				parts = append(parts, partString)
+			}
+			meta.Info.Files = append(meta.Info.Files, File{Path: strings.Join(parts, "/"), Length: length})
+		}
+	} else {
+		meta.Info.Length, ok = infoDict["length"].(int64)
+		if !ok {
+			return nil, errors.New("torrent: info dictionary has neither length nor files")
+		}
+	}
+
+	return meta, nil
+}
+
+// safePathPart returns true if part is a single path element naming something in the dir it's joined onto
+func safePathPart(part string) bool {
+	return part != "" && part != "." && part != ".." && !strings.ContainsAny(part, `/\`) && filepath.VolumeName(part) == ""
+}
+
+func (i *Info) IsMultiFile() bool {
+	return len(i.Files) > 0
+}
+
+func (i *Info) TotalLength() int64 {
+	if !i.IsMultiFile() {
+		return i.Length
+	}
+	var total int64
+	for _, file := range i.Files {
+		total += file.Length
+	}
+	return total
+}
+
+// MatchesPayload returns nil if payloadPath is named after the torrent and contains exactly the expected file(s) at
+// the expected sizes. Otherwise it returns an error describing the first mismatch
+func (i *Info) MatchesPayload(payloadPath string) error {
+	if filepath.Base(payloadPath) != i.Name {
+		return fmt.Errorf("name %v does not match %v", filepath.Base(payloadPath), i.Name)
+	}
+
+	stat, err := os.Stat(payloadPath)
+	if err != nil {
+		return err
+	}
+
+	if !i.IsMultiFile() {
+		if stat.IsDir() {
+			return fmt.Errorf("%v is a directory, expected a single file", payloadPath)
+		}
+		if stat.Size() != i.Length {
+			return fmt.Errorf("%v is %v bytes, expected %v", payloadPath, stat.Size(), i.Length)
+		}
+		return nil
+	}
+
+	if !stat.IsDir() {
+		return fmt.Errorf("%v is a file, expected a directory", payloadPath)
+	}
+	for _, file := range i.Files {
+		filePath := filepath.Join(payloadPath, filepath.FromSlash(file.Path))
+		fileStat, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if fileStat.Size() != file.Length {
+			return fmt.Errorf("%v is %v bytes, expected %v", filePath, fileStat.Size(), file.Length)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTorrent(t *testing.T) {
+
+	Convey("Test parse single file torrent", t, func() {
+		data := buildTorrent(map[string]interface{}{
+			"name":         "My.Movie.2010.avi",
+			"piece length": int64(16384),
+			"length":       int64(5),
+		})
+
+		meta, err := Parse(data)
+		So(err, ShouldBeNil)
+		So(meta.Announce, ShouldEqual, "http://tracker/announce")
+		So(meta.Info.Name, ShouldEqual, "My.Movie.2010.avi")
+		So(meta.Info.PieceLength, ShouldEqual, 16384)
+		So(meta.Info.IsMultiFile(), ShouldBeFalse)
+		So(meta.Info.TotalLength(), ShouldEqual, 5)
+		So(len(meta.InfoHash), ShouldEqual, 40)
+	})
+
+	Convey("Test parse multi file torrent", t, func() {
+		data := buildTorrent(map[string]interface{}{
+			"name":         "Show Season 1",
+			"piece length": int64(16384),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(3), "path": []interface{}{"ep1.mkv"}},
+				map[string]interface{}{"length": int64(4), "path": []interface{}{"Subs", "ep1.srt"}},
+			},
+		})
+
+		meta, err := Parse(data)
+		So(err, ShouldBeNil)
+		So(meta.Info.IsMultiFile(), ShouldBeTrue)
+		So(meta.Info.Files, ShouldResemble, []File{{Path: "ep1.mkv", Length: 3}, {Path: "Subs/ep1.srt", Length: 4}})
+		So(meta.Info.TotalLength(), ShouldEqual, 7)
+	})
+
+	Convey("Test infohash does not depend on outer dictionary", t, func() {
+		info := map[string]interface{}{"name": "a", "piece length": int64(1), "length": int64(1)}
+		first, err := Parse(buildTorrent(info))
+		So(err, ShouldBeNil)
+
+		other, _ := bencode.Encode(map[string]interface{}{"info": info, "comment": "different"})
+		second, err := Parse(other)
+		So(err, ShouldBeNil)
+		So(second.InfoHash, ShouldEqual, first.InfoHash)
+	})
+
+	Convey("Test infohash of a torrent with unsorted keys", t, func() {
+		info := "d4:name1:a6:lengthi1e12:piece lengthi1ee"
+		meta, err := Parse([]byte("d8:announce3:foo4:info" + info + "e"))
+		So(err, ShouldBeNil)
+
+		hash := sha1.Sum([]byte(info))
+		So(meta.InfoHash, ShouldEqual, hex.EncodeToString(hash[:]))
+		So(meta.Info.Name, ShouldEqual, "a")
+	})
+
+	Convey("Test parse rejects missing info", t, func() {
+		_, err := Parse([]byte("d8:announce3:fooe"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test parse rejects garbage", t, func() {
+		_, err := Parse([]byte("not a torrent"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test parse rejects piece lengths out of range", t, func() {
+		for _, pieceLength := range []int64{0, -1, maxPieceLength + 1, 1 << 62} {
+			data, _ := bencode.Encode(map[string]interface{}{"info": map[string]interface{}{"name": "a", "piece length": pieceLength, "length": int64(1)}})
+			_, err := Parse(data)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Test parse rejects paths leading outside the payload", t, func() {
+		for _, path := range [][]interface{}{{"..", "etc", "passwd"}, {"", "etc", "passwd"}, {"Subs", "..", "..", "x"}, {"a/../../x"}, {`..\x`}} {
+			file := map[string]interface{}{"path": path, "length": int64(1)}
+			data, _ := bencode.Encode(map[string]interface{}{"info": map[string]interface{}{"name": "Show", "piece length": int64(1), "files": []interface{}{file}}})
+			_, err := Parse(data)
+			So(err, ShouldNotBeNil)
+		}
+		for _, name := range []string{"..", "../x", "/x"} {
+			data, _ := bencode.Encode(map[string]interface{}{"info": map[string]interface{}{"name": name, "piece length": int64(1), "length": int64(1)}})
+			_, err := Parse(data)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Test single file payload matching", t, func() {
+		resetTestDir()
+		info := Info{Name: "movie.avi", Length: 5}
+		ioutil.WriteFile("test/movie.avi", []byte("12345"), os.ModePerm)
+		ioutil.WriteFile("test/other.avi", []byte("12345"), os.ModePerm)
+
+		So(info.MatchesPayload("test/movie.avi"), ShouldBeNil)
+		So(info.MatchesPayload("test/other.avi"), ShouldNotBeNil)
+
+		info.Length = 6
+		So(info.MatchesPayload("test/movie.avi"), ShouldNotBeNil)
+	})
+
+	Convey("Test multi file payload matching", t, func() {
+		resetTestDir()
+		info := Info{Name: "show", Files: []File{{Path: "ep1.mkv", Length: 3}, {Path: "Subs/ep1.srt", Length: 2}}}
+		os.MkdirAll("test/show/Subs", os.ModePerm)
+		ioutil.WriteFile("test/show/ep1.mkv", []byte("123"), os.ModePerm)
+
+		So(info.MatchesPayload("test/show"), ShouldNotBeNil)
+
+		ioutil.WriteFile("test/show/Subs/ep1.srt", []byte("12"), os.ModePerm)
+		So(info.MatchesPayload("test/show"), ShouldBeNil)
+	})
+}
+
+func buildTorrent(info map[string]interface{}) []byte {
+	data, err := bencode.Encode(map[string]interface{}{"announce": "http://tracker/announce", "info": info})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func resetTestDir() {
+	os.RemoveAll("test")
+	os.Mkdir("test", os.ModePerm)
+}
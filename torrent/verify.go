@@ -0,0 +1,72 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Verify hashes the payload at payloadPath piece by piece and compares it against the info dictionary's piece
+// hashes. Pieces span file boundaries, so files are read back to back in the order the torrent lists them
+func (i *Info) Verify(payloadPath string) error {
+	if i.PieceLength <= 0 || len(i.Pieces) == 0 {
+		return errors.New("torrent has no piece hashes to verify against")
+	}
+
+	numPieces := len(i.Pieces) / sha1.Size
+	expectedPieces := (i.TotalLength() + i.PieceLength - 1) / i.PieceLength
+	if int64(numPieces) != expectedPieces {
+		return fmt.Errorf("torrent has %v piece hashes but payload needs %v", numPieces, expectedPieces)
+	}
+
+	paths := make([]string, 0)
+	if i.IsMultiFile() {
+		for _, file := range i.Files {
+			paths = append(paths, filepath.Join(payloadPath, filepath.FromSlash(file.Path)))
+		}
+	} else {
+		paths = append(paths, payloadPath)
+	}
+
+	readers := make([]io.Reader, 0, len(paths))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	payload := io.MultiReader(readers...)
+	// pieces are streamed through the hash, as a piece can be far larger than we'd want to hold in memory
+	hash := sha1.New()
+	for piece := 0; piece < numPieces; piece++ {
+		size := i.PieceLength
+		if piece == numPieces-1 {
+			size = i.TotalLength() - int64(piece)*i.PieceLength
+		}
+		hash.Reset()
+		_, err := io.CopyN(hash, payload, size)
+		if err == io.EOF {
+			return fmt.Errorf("payload is shorter than expected, ran out of data at piece %v of %v", piece, numPieces)
+		} else if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(hash.Sum(nil), i.Pieces[piece*sha1.Size:(piece+1)*sha1.Size]) {
+			return fmt.Errorf("piece %v of %v does not match its hash", piece, numPieces)
+		}
+	}
+
+	extra, _ := io.CopyN(ioutil.Discard, payload, 1)
+	if extra > 0 {
+		return errors.New("payload is longer than expected")
+	}
+	return nil
+}
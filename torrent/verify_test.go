@@ -0,0 +1,80 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+
+	Convey("Test verify single file", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.avi", []byte("abcdefghij"), os.ModePerm)
+		info := Info{Name: "movie.avi", PieceLength: 4, Length: 10, Pieces: hashPieces([]byte("abcdefghij"), 4)}
+
+		So(info.Verify("test/movie.avi"), ShouldBeNil)
+	})
+
+	Convey("Test verify streams pieces rather than holding a whole one", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.avi", []byte("abcdefghij"), os.ModePerm)
+		info := Info{Name: "movie.avi", PieceLength: 1 << 40, Length: 10, Pieces: hashPieces([]byte("abcdefghij"), 10)}
+
+		So(info.Verify("test/movie.avi"), ShouldBeNil)
+	})
+
+	Convey("Test verify detects corruption", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.avi", []byte("abcdXfghij"), os.ModePerm)
+		info := Info{Name: "movie.avi", PieceLength: 4, Length: 10, Pieces: hashPieces([]byte("abcdefghij"), 4)}
+
+		So(info.Verify("test/movie.avi"), ShouldNotBeNil)
+	})
+
+	Convey("Test verify detects truncated payload", t, func() {
+		resetTestDir()
+		ioutil.WriteFile("test/movie.avi", []byte("abcdef"), os.ModePerm)
+		info := Info{Name: "movie.avi", PieceLength: 4, Length: 10, Pieces: hashPieces([]byte("abcdefghij"), 4)}
+
+		So(info.Verify("test/movie.avi"), ShouldNotBeNil)
+	})
+
+	Convey("Test verify pieces spanning files", t, func() {
+		resetTestDir()
+		os.MkdirAll("test/show/Subs", os.ModePerm)
+		ioutil.WriteFile("test/show/ep1.mkv", []byte("abcde"), os.ModePerm)
+		ioutil.WriteFile("test/show/Subs/ep1.srt", []byte("fghijkl"), os.ModePerm)
+		info := Info{
+			Name:        "show",
+			PieceLength: 4,
+			Pieces:      hashPieces([]byte("abcdefghijkl"), 4),
+			Files:       []File{{Path: "ep1.mkv", Length: 5}, {Path: "Subs/ep1.srt", Length: 7}},
+		}
+
+		So(info.Verify("test/show"), ShouldBeNil)
+
+		ioutil.WriteFile("test/show/Subs/ep1.srt", []byte("fghijkX"), os.ModePerm)
+		So(info.Verify("test/show"), ShouldNotBeNil)
+	})
+
+	Convey("Test verify without pieces", t, func() {
+		info := Info{Name: "movie.avi", Length: 10}
+		So(info.Verify("test/movie.avi"), ShouldNotBeNil)
+	})
+}
+
+func hashPieces(data []byte, pieceLength int) []byte {
+	pieces := make([]byte, 0)
+	for start := 0; start < len(data); start += pieceLength {
+		end := start + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		hash := sha1.Sum(data[start:end])
+		pieces = append(pieces, hash[:]...)
+	}
+	return pieces
+}
@@ -0,0 +1,144 @@
+package tv
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Episode is what we could work out about an episode from a file name. Date based episodes have a Date and no
+// Episodes. Show may be empty if the name started with the episode marker
+type Episode struct {
+	Show     string
+	Season   int
+	Episodes []int
+	Date     string
+}
+
+var (
+	// S01E02, S01E02E03, S01E02-E03, S01E02-03
+	seasonEpisode = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})((?:[ ._]?-?[ ._]?e\d{1,3}|-\d{1,3})*)(?:[^a-z0-9]|$)`)
+	// 1x02, 1x02x03, 1x02-03
+	crossEpisode = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})((?:[-x]\d{2,3})*)(?:[^a-z0-9]|$)`)
+	// 2020.01.31, 2020-01-31, 2020 01 31
+	dateEpisode = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d{2})[ ._-](\d{2})[ ._-](\d{2})(?:[^0-9]|$)`)
+	// S01 or Season 1 on its own, as found in season pack names
+	seasonOnly = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s(\d{1,2})|season[ ._-]?(\d{1,2}))(?:[^a-z0-9]|$)`)
+
+	episodeNumber = regexp.MustCompile(`\d{1,3}`)
+	separators    = regexp.MustCompile(`[._]+`)
+	spaces        = regexp.MustCompile(`\s+`)
+)
+
+// Parse finds an episode marker in name. It returns false if there isn't one
+func Parse(name string) (Episode, bool) {
+	if match := seasonEpisode.FindStringSubmatchIndex(name); match != nil {
+		return buildEpisode(name, match, name[match[6]:match[7]]), true
+	}
+	if match := crossEpisode.FindStringSubmatchIndex(name); match != nil {
+		return buildEpisode(name, match, name[match[6]:match[7]]), true
+	}
+	if match := dateEpisode.FindStringSubmatchIndex(name); match != nil {
+		year, _ := strconv.Atoi(name[match[2]:match[3]])
+		month, _ := strconv.Atoi(name[match[4]:match[5]])
+		day, _ := strconv.Atoi(name[match[6]:match[7]])
+		if month < 1 || month > 12 || day < 1 || day > 31 {
+			return Episode{}, false
+		}
+		return Episode{
+			Show:   CleanShowName(name[:match[0]]),
+			Season: year,
+			Date:   fmt.Sprintf("%04d-%02d-%02d", year, month, day),
+		}, true
+	}
+	return Episode{}, false
+}
+
+func buildEpisode(name string, match []int, rest string) Episode {
+	season, _ := strconv.Atoi(name[match[2]:match[3]])
+	first, _ := strconv.Atoi(name[match[4]:match[5]])
+	episode := Episode{Show: CleanShowName(name[:match[0]]), Season: season, Episodes: []int{first}}
+
+	numbers := episodeNumber.FindAllString(rest, -1)
+	for _, number := range numbers {
+		value, _ := strconv.Atoi(number)
+		episode.Episodes = append(episode.Episodes, value)
+	}
+
+	// a range like E01-E04 means every episode in between
+	if len(episode.Episodes) == 2 && strings.Contains(rest, "-") {
+		last := episode.Episodes[1]
+		episode.Episodes = episode.Episodes[:1]
+		for next := first + 1; next <= last; next++ {
+			episode.Episodes = append(episode.Episodes, next)
+		}
+	}
+	return episode
+}
+
+// Index returns where the episode or season marker in name starts, or -1 if there isn't one
+func Index(name string) int {
+	for _, marker := range []*regexp.Regexp{seasonEpisode, crossEpisode, dateEpisode, seasonOnly} {
+		if match := marker.FindStringIndex(name); match != nil {
+			return match[0]
+		}
+	}
+	return -1
+}
+
+// Season returns the season number of a season pack name like "Show.Name.S02.1080p" or "Show Season 2"
+func Season(name string) (int, bool) {
+	match := seasonOnly.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+	number := match[1]
+	if number == "" {
+		number = match[2]
+	}
+	season, _ := strconv.Atoi(number)
+	return season, true
+}
+
+// ParseShow works out the show name from a payload or season pack name like "Show.Name.S02.1080p"
+func ParseShow(name string) string {
+	if episode, ok := Parse(name); ok {
+		return episode.Show
+	}
+	if match := seasonOnly.FindStringIndex(name); match != nil {
+		return CleanShowName(name[:match[0]])
+	}
+	return ""
+}
+
+// CleanShowName turns the part of a file name before the episode marker into a show name
+func CleanShowName(raw string) string {
+	show := separators.ReplaceAllString(raw, " ")
+	show = strings.Replace(show, "/", " ", -1)
+	show = strings.Replace(show, "\\", " ", -1)
+	show = strings.Trim(show, " -([")
+	return spaces.ReplaceAllString(show, " ")
+}
+
+// Code is how the episode is written in file names, e.g. S01E02, S01E02-E03 or 2020-01-31
+func (e Episode) Code() string {
+	if e.Date != "" {
+		return e.Date
+	}
+	code := fmt.Sprintf("S%02dE%02d", e.Season, e.Episodes[0])
+	if len(e.Episodes) > 1 {
+		code += fmt.Sprintf("-E%02d", e.Episodes[len(e.Episodes)-1])
+	}
+	return code
+}
+
+// SeasonDir is the name of the directory the episode belongs in, e.g. "Season 01" or "Season 2020"
+func (e Episode) SeasonDir() string {
+	return fmt.Sprintf("Season %02d", e.Season)
+}
+
+// FileName is the final name for the episode, e.g. "Show - S01E02.mkv"
+func (e Episode) FileName(show string, ext string) string {
+	return show + " - " + e.Code() + ext
+}
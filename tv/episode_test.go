@@ -0,0 +1,105 @@
+package tv
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestEpisode(t *testing.T) {
+
+	Convey("Test parse SxxEyy", t, func() {
+		episode, ok := Parse("Show.Name.S02E05.1080p.WEB-DL.x264-GROUP.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Show, ShouldEqual, "Show Name")
+		So(episode.Season, ShouldEqual, 2)
+		So(episode.Episodes, ShouldResemble, []int{5})
+		So(episode.Code(), ShouldEqual, "S02E05")
+	})
+
+	Convey("Test parse lower case with spaces", t, func() {
+		episode, ok := Parse("show name - s1e12 - title.avi")
+		So(ok, ShouldBeTrue)
+		So(episode.Show, ShouldEqual, "show name")
+		So(episode.Code(), ShouldEqual, "S01E12")
+	})
+
+	Convey("Test parse multi episode", t, func() {
+		episode, ok := Parse("Show.S01E01E02.720p.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Episodes, ShouldResemble, []int{1, 2})
+		So(episode.Code(), ShouldEqual, "S01E01-E02")
+	})
+
+	Convey("Test parse episode range", t, func() {
+		episode, ok := Parse("Show.S01E01-E04.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Episodes, ShouldResemble, []int{1, 2, 3, 4})
+		So(episode.Code(), ShouldEqual, "S01E01-E04")
+	})
+
+	Convey("Test parse 1x02", t, func() {
+		episode, ok := Parse("Show Name 1x02 Pilot.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Show, ShouldEqual, "Show Name")
+		So(episode.Season, ShouldEqual, 1)
+		So(episode.Episodes, ShouldResemble, []int{2})
+	})
+
+	Convey("Test parse 1x02-03", t, func() {
+		episode, ok := Parse("Show.1x02-03.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Episodes, ShouldResemble, []int{2, 3})
+	})
+
+	Convey("Test parse date based", t, func() {
+		episode, ok := Parse("The.Daily.Show.2020.01.31.Guest.720p.mkv")
+		So(ok, ShouldBeTrue)
+		So(episode.Show, ShouldEqual, "The Daily Show")
+		So(episode.Date, ShouldEqual, "2020-01-31")
+		So(episode.SeasonDir(), ShouldEqual, "Season 2020")
+		So(episode.Code(), ShouldEqual, "2020-01-31")
+	})
+
+	Convey("Test parse rejects bad dates", t, func() {
+		_, ok := Parse("Show.2020.13.31.mkv")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test parse without marker", t, func() {
+		_, ok := Parse("Movie.Name.2010.1080p.mkv")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test resolution is not an episode", t, func() {
+		_, ok := Parse("Movie.1920x1080.mkv")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test parse show from season pack", t, func() {
+		So(ParseShow("Show.Name.S03.1080p.BluRay-GROUP"), ShouldEqual, "Show Name")
+		So(ParseShow("Show Name Season 2"), ShouldEqual, "Show Name")
+		So(ParseShow("Show.Name.S03E01.720p"), ShouldEqual, "Show Name")
+		So(ParseShow("Something Else"), ShouldEqual, "")
+	})
+
+	Convey("Test marker index and season packs", t, func() {
+		So(Index("Show.Name.S03E01.720p"), ShouldEqual, 9)
+		So(Index("Show Name Season 2"), ShouldEqual, 9)
+		So(Index("Something Else"), ShouldEqual, -1)
+
+		season, ok := Season("Show.Name.S03.1080p")
+		So(ok, ShouldBeTrue)
+		So(season, ShouldEqual, 3)
+		season, ok = Season("Show Name Season 12")
+		So(ok, ShouldBeTrue)
+		So(season, ShouldEqual, 12)
+		_, ok = Season("Film.2010.1080p")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Test names", t, func() {
+		episode := Episode{Season: 3, Episodes: []int{7}}
+		So(episode.SeasonDir(), ShouldEqual, "Season 03")
+		So(episode.FileName("Show", ".mkv"), ShouldEqual, "Show - S03E07.mkv")
+	})
+}
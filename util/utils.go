@@ -1,11 +1,14 @@
 package util
 
 import (
+	"context"
 	"fmt"
+	"github.com/MondayHopscotch/SuperScope/release"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -20,19 +23,34 @@ func StringSliceContains(slice []string, item string) bool {
 }
 
 func MoveFileWithTimeout(src string, dest string, timeout time.Duration) error {
+	_, err := MoveFileWithRetries(src, dest, timeout)
+	return err
+}
+
+// MoveFileWithRetries behaves like MoveFileWithTimeout, but also returns how many attempts were made
+func MoveFileWithRetries(src string, dest string, timeout time.Duration) (int, error) {
+	return MoveFileWithContext(context.Background(), src, dest, timeout)
+}
+
+// MoveFileWithContext behaves like MoveFileWithRetries, but stops retrying as soon as ctx is done, returning its error
+func MoveFileWithContext(ctx context.Context, src string, dest string, timeout time.Duration) (int, error) {
 	log.Println("Moving ", src, " to ", dest)
 	var err error
+	attempts := 0
 	start := time.Now()
 	for time.Since(start) < timeout {
+		attempts++
 		err = os.Rename(src, dest)
-		if err != nil {
-			time.Sleep(time.Second * 5)
-			continue
-		} else {
-			return nil
+		if err == nil {
+			return attempts, nil
+		}
+		select {
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		case <-time.After(time.Second * 5):
 		}
 	}
-	return err
+	return attempts, err
 }
 
 func RemoveExtension(fileName string) string {
@@ -50,10 +68,120 @@ func DoTokensMatch(starter []string, against []string) bool {
 	return true
 }
 
+// CompareReleases parses a and b as release names and counts the attributes, like year, episode or resolution, that
+// both name and agree on, and those they disagree on. Attributes only one of them names are ignored
+func CompareReleases(a string, b string) (int, int) {
+	return CompareAttributes(ReleaseAttributes(release.Parse(a)), ReleaseAttributes(release.Parse(b)))
+}
+
+// CompareAttributes is CompareReleases for the ReleaseAttributes of names that have already been parsed
+func CompareAttributes(first []string, second []string) (int, int) {
+	agree, conflict := 0, 0
+	for i := range first {
+		if first[i] == "" || second[i] == "" {
+			continue
+		}
+		if first[i] == second[i] {
+			agree++
+		} else {
+			conflict++
+		}
+	}
+	return agree, conflict
+}
+
+// ReleaseAttributes lists the attributes of r that CompareReleases looks at, with "" for those it doesn't name
+func ReleaseAttributes(r release.Release) []string {
+	episodes := make([]string, 0, len(r.Episodes))
+	for _, episode := range r.Episodes {
+		episodes = append(episodes, fmt.Sprint(episode))
+	}
+	attributes := []string{
+		"", "", strings.Join(episodes, ","), r.Date,
+		r.Resolution, r.Source, r.Codec, strings.ToLower(r.Group), r.Edition,
+	}
+	if r.Year > 0 {
+		attributes[0] = fmt.Sprint(r.Year)
+	}
+	if r.Season > 0 {
+		attributes[1] = fmt.Sprint(r.Season)
+	}
+	return attributes
+}
+
+// ReleaseTitle returns the title and year of a release name, or 0 if it has no year. Names with no recognisable
+// title are returned whole, without their extension
+func ReleaseTitle(name string) (string, int) {
+	r := release.Parse(name)
+	if r.Title == "" {
+		return RemoveExtension(name), r.Year
+	}
+	return r.Title, r.Year
+}
+
+var (
+	// illegalChars can't be used in names on Windows, or in the case of '/' anywhere
+	illegalChars   = regexp.MustCompile(`[<>"/\\|?*\x00-\x1f]`)
+	repeatedSpaces = regexp.MustCompile(`\s{2,}`)
+	// reservedNames are device names Windows refuses as file names, with or without an extension
+	reservedNames = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])(\..*)?$`)
+)
+
+// SanitizeFileName makes name safe to use as a file or directory name on any filesystem. Colons become " -",
+// other illegal characters are dropped, and trailing dots and spaces, which Windows strips, are removed
+func SanitizeFileName(name string) string {
+	name = strings.Replace(name, ":", " -", -1)
+	name = illegalChars.ReplaceAllString(name, "")
+	name = repeatedSpaces.ReplaceAllString(name, " ")
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return "_"
+	}
+	if reservedNames.MatchString(name) {
+		return "_" + name
+	}
+	return name
+}
+
+// maxUniqueTries is how many numbered names UniquePath tries before giving up
+const maxUniqueTries = 100
+
+// UniquePath returns p if nothing exists there, otherwise the first of "name (2).ext", "name (3).ext" and so on
+// that is free. Directories are numbered at the end of their name, as dotted names like Movie.2010 have no extension.
+// It fails if a name can't be checked or none of the first maxUniqueTries are free
+func UniquePath(p string, isDir bool) (string, error) {
+	ext := ""
+	if !isDir {
+		ext = filepath.Ext(p)
+	}
+	base := p[:len(p)-len(ext)]
+	candidate := p
+	for i := 2; i <= maxUniqueTries+1; i++ {
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%v (%v)%v", base, i, ext)
+	}
+	return "", fmt.Errorf("no free name for %v after %v tries", p, maxUniqueTries)
+}
+
 func IsNewFile(name string) bool {
+	return HasIgnoredPrefix(name, []string{"new "})
+}
+
+// HasIgnoredPrefix returns true if the lower cased base name of name starts with any of prefixes
+func HasIgnoredPrefix(name string, prefixes []string) bool {
 	fileBaseName := strings.ToLower(filepath.Base(name))
 	log.Println(fileBaseName)
-	return strings.HasPrefix(fileBaseName, "new ")
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(fileBaseName, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
 }
 
 func IsTorrent(name string) bool {
@@ -61,6 +189,22 @@ func IsTorrent(name string) bool {
 	return strings.Compare(ext, ".torrent") == 0
 }
 
+// IsMagnet returns true for files expected to hold a magnet URI: .magnet files and browser exported .url shortcuts
+func IsMagnet(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".magnet" || ext == ".url"
+}
+
+var mediaExtensions = []string{
+	".mkv", ".avi", ".mp4", ".m4v", ".mov", ".wmv", ".mpg", ".mpeg", ".ts", ".m2ts", ".webm", ".flv",
+	".mp3", ".flac", ".m4a", ".aac", ".ogg", ".opus", ".wav", ".wma",
+}
+
+// IsMediaFile returns true if name has a common video or audio extension
+func IsMediaFile(name string) bool {
+	return StringSliceContains(mediaExtensions, strings.ToLower(path.Ext(name)))
+}
+
 func DetermineFinalLocation(origin string, dest string, file string) string {
 	// take origin path, replace 'root' prefix with 'media' prefix
 	originLength := len(origin)
@@ -1,8 +1,12 @@
 package util
 
 import (
+	"context"
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -47,6 +51,21 @@ func TestDirectoryWatcher(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 
+	Convey("Test move file counts attempts", t, func() {
+		resetTestDir()
+
+		startFileName := "test/fileOne"
+		endFileName := "test/fileTwo"
+
+		startFile, err := os.Create(startFileName)
+		So(err, ShouldBeNil)
+		startFile.Close()
+
+		attempts, err := MoveFileWithRetries(startFileName, endFileName, time.Second*10)
+		So(err, ShouldBeNil)
+		So(attempts, ShouldEqual, 1)
+	})
+
 	Convey("Test move file timeout", t, func() {
 		resetTestDir()
 
@@ -68,6 +87,21 @@ func TestDirectoryWatcher(t *testing.T) {
 		So(err, ShouldNotBeNil) // never copied, this should error
 	})
 
+	Convey("Test move file gives up when cancelled", t, func() {
+		resetTestDir()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			cancel()
+		}()
+		start := time.Now()
+		attempts, err := MoveFileWithContext(ctx, "test/missing", "test/fileTwo", time.Minute)
+		So(err, ShouldEqual, context.Canceled)
+		So(attempts, ShouldEqual, 1)
+		So(time.Since(start), ShouldBeLessThan, time.Second*5)
+	})
+
 	Convey("Test remove valid extension", t, func() {
 		So(RemoveExtension("testFile.avi"), ShouldEqual, "testFile")
 	})
@@ -106,6 +140,14 @@ func TestDirectoryWatcher(t *testing.T) {
 		So(IsNewFile("New Folder"), ShouldBeTrue)
 	})
 
+	Convey("Test ignored prefix", t, func() {
+		So(HasIgnoredPrefix("some/dir/Partial.download", []string{"new ", "partial"}), ShouldBeTrue)
+	})
+
+	Convey("Test no ignored prefix", t, func() {
+		So(HasIgnoredPrefix("some/dir/New Folder", []string{"partial"}), ShouldBeFalse)
+	})
+
 	Convey("Test torrent file caps", t, func() {
 		So(IsTorrent("myLifeStory.TORRENT"), ShouldBeTrue)
 	})
@@ -122,6 +164,28 @@ func TestDirectoryWatcher(t *testing.T) {
 		So(IsTorrent("myLifeStory.txt"), ShouldBeFalse)
 	})
 
+	Convey("Test magnet file", t, func() {
+		So(IsMagnet("myLifeStory.Magnet"), ShouldBeTrue)
+	})
+
+	Convey("Test magnet shortcut", t, func() {
+		So(IsMagnet("myLifeStory.url"), ShouldBeTrue)
+	})
+
+	Convey("Test non-magnet", t, func() {
+		So(IsMagnet("myLifeStory.torrent"), ShouldBeFalse)
+	})
+
+	Convey("Test media file", t, func() {
+		So(IsMediaFile("some/dir/movie.MKV"), ShouldBeTrue)
+		So(IsMediaFile("album/01 - track.flac"), ShouldBeTrue)
+	})
+
+	Convey("Test non-media file", t, func() {
+		So(IsMediaFile("movie.nfo"), ShouldBeFalse)
+		So(IsMediaFile("movie.part01.rar"), ShouldBeFalse)
+	})
+
 	Convey("Test final location", t, func() {
 		origin := "this/thing/here/"
 		file := origin + "videos/homeMovies/dance.avi"
@@ -159,6 +223,76 @@ func TestDirectoryWatcher(t *testing.T) {
 		So(foundFiles, ShouldContain, "fileTwo")
 		So(foundFiles, ShouldContain, "fileThree")
 	})
+
+	Convey("Test comparing releases", t, func() {
+		agree, conflict := CompareReleases("Show.S01E01.720p.HDTV.x264-GRP", "Show.S01E01.720p.HDTV.x264-GRP.mkv")
+		So(agree, ShouldEqual, 6)
+		So(conflict, ShouldEqual, 0)
+
+		agree, conflict = CompareReleases("Show.S01E01.720p.HDTV.x264-GRP", "Show.S01E02.720p.HDTV.x264-GRP")
+		So(agree, ShouldEqual, 5)
+		So(conflict, ShouldEqual, 1)
+
+		agree, conflict = CompareReleases("Movie Name (2010)", "Movie.Name.2010.1080p.BluRay-GRP")
+		So(agree, ShouldEqual, 1)
+		So(conflict, ShouldEqual, 0)
+	})
+
+	Convey("Test release titles", t, func() {
+		title, year := ReleaseTitle("Movie.Name.2010.1080p.BluRay.x264-GRP.mkv")
+		So(title, ShouldEqual, "Movie Name")
+		So(year, ShouldEqual, 2010)
+
+		title, year = ReleaseTitle("[1080p].mkv")
+		So(title, ShouldEqual, "[1080p]")
+		So(year, ShouldEqual, 0)
+	})
+
+	Convey("Test sanitizing file names", t, func() {
+		So(SanitizeFileName("Star Wars: A New Hope"), ShouldEqual, "Star Wars - A New Hope")
+		So(SanitizeFileName(`What? <Really> "Yes"|No*`), ShouldEqual, "What Really YesNo")
+		So(SanitizeFileName("back\\slash/slash"), ShouldEqual, "backslashslash")
+		So(SanitizeFileName("Trailing dots... "), ShouldEqual, "Trailing dots")
+		So(SanitizeFileName("CON"), ShouldEqual, "_CON")
+		So(SanitizeFileName("nul.txt"), ShouldEqual, "_nul.txt")
+		So(SanitizeFileName("Console"), ShouldEqual, "Console")
+		So(SanitizeFileName("???"), ShouldEqual, "_")
+	})
+
+	Convey("Test unique paths", t, func() {
+		resetTestDir()
+
+		unique, err := UniquePath("test/complete/film.mkv", false)
+		So(err, ShouldBeNil)
+		So(unique, ShouldEqual, "test/complete/film.mkv")
+
+		ioutil.WriteFile("test/complete/film.mkv", []byte("1"), os.ModePerm)
+		ioutil.WriteFile("test/complete/film (2).mkv", []byte("2"), os.ModePerm)
+		unique, err = UniquePath("test/complete/film.mkv", false)
+		So(err, ShouldBeNil)
+		So(unique, ShouldEqual, "test/complete/film (3).mkv")
+
+		os.Mkdir("test/complete/Film.2010", os.ModePerm)
+		unique, err = UniquePath("test/complete/Film.2010", true)
+		So(err, ShouldBeNil)
+		So(unique, ShouldEqual, "test/complete/Film.2010 (2)")
+	})
+
+	Convey("Test unique paths give up instead of looping forever", t, func() {
+		resetTestDir()
+
+		_, err := UniquePath("test/complete/"+strings.Repeat("x", 300)+".mkv", false)
+		So(err, ShouldNotBeNil)
+
+		for i := 2; i <= maxUniqueTries+1; i++ {
+			ioutil.WriteFile(fmt.Sprintf("test/complete/film (%v).mkv", i), nil, os.ModePerm)
+		}
+		ioutil.WriteFile("test/complete/film.mkv", nil, os.ModePerm)
+		_, err = UniquePath("test/complete/film.mkv", false)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no free name")
+	})
+
 }
 
 func resetTestDir() {
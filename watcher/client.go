@@ -0,0 +1,151 @@
+package watcher
+
+import (
+	"github.com/MondayHopscotch/SuperScope/client"
+	"github.com/MondayHopscotch/SuperScope/hooks"
+	"github.com/MondayHopscotch/SuperScope/magnet"
+	"github.com/MondayHopscotch/SuperScope/metrics"
+	"github.com/MondayHopscotch/SuperScope/state"
+	"github.com/MondayHopscotch/SuperScope/torrent"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// torrentClient returns the configured torrent client, or nil if torrents go through the drop dir
+func (w *SimpleWatcher) torrentClient() client.Client {
+	w.configLock.RLock()
+	defer w.configLock.RUnlock()
+	return w.client
+}
+
+// addToClientWithTimeout hands the torrent or magnet in file straight to the torrent client, downloading into the
+// completed dir under its category. The file may still be being written, so it is retried until timeout
+func (w *SimpleWatcher) addToClientWithTimeout(torrentClient client.Client, file string, timeout time.Duration, start time.Time) {
+	base := filepath.Base(file)
+	record := state.Record{Name: base, OrigPath: file, Status: state.StatusConsumed}
+
+	savePath, err := filepath.Abs(w.completedDir)
+	if err != nil {
+		log.Println("Unable to find completed dir: ", err)
+		metrics.ConsumeFailures.Inc()
+		return
+	}
+	options := client.AddOptions{Category: w.route(file).Name, SavePath: savePath}
+
+	for time.Since(start) < timeout {
+		if util.IsMagnet(file) {
+			var link *magnet.Link
+			link, err = magnet.ParseFile(file)
+			if err == magnet.ErrNoMagnet {
+				// an ordinary web shortcut, which will never have a magnet in it
+				break
+			}
+			if err == nil {
+				record.DisplayName = link.DisplayName
+				record.InfoHash, err = torrentClient.AddMagnet(link.URI, options)
+			}
+		} else {
+			var data []byte
+			var meta *torrent.MetaInfo
+			data, err = ioutil.ReadFile(file)
+			if err == nil {
+				meta, err = torrent.Parse(data)
+			}
+			if err == nil {
+				record.Info = &meta.Info
+				record.InfoHash, err = torrentClient.AddTorrent(data, options)
+			}
+			if err == nil {
+				w.keepTorrent(base, data)
+			}
+		}
+		if err == nil {
+			break
+		}
+		metrics.ConsumeRetries.Inc()
+		if !w.wait(time.Second * 5) {
+			log.Println("Stopped adding ", file, " to ", torrentClient.Name(), " to shut down, it was left where it is")
+			return
+		}
+	}
+	if err != nil {
+		log.Println("Failed to add ", file, " to ", torrentClient.Name(), " before timeout reached: ", err)
+		metrics.ConsumeFailures.Inc()
+		return
+	}
+
+	err = os.Remove(file)
+	if err != nil {
+		log.Println("Unable to remove consumed file ", file, ": ", err)
+	}
+
+	record.ConsumedAt = time.Now()
+	w.startTracking(record)
+	w.fire(hooks.EventConsumed, record, "", "", nil)
+	metrics.TorrentsConsumed.Inc()
+	metrics.ConsumeDuration.Observe(time.Since(start).Seconds())
+	log.Println("Added ", base, " to ", torrentClient.Name(), " (", record.InfoHash, ")")
+}
+
+// askClient asks the torrent client about the torrents of every active file. It's asked from another goroutine, as
+// a slow or unreachable client would hold up everything else, and the answer is sent back as a request
+func (w *SimpleWatcher) askClient() {
+	torrentClient := w.torrentClient()
+	if torrentClient == nil || w.askingClient {
+		return
+	}
+	infoHashes := make([]string, 0)
+	for _, record := range w.ActiveFiles {
+		if record.InfoHash != "" {
+			infoHashes = append(infoHashes, record.InfoHash)
+		}
+	}
+	if len(infoHashes) == 0 {
+		return
+	}
+
+	w.askingClient = true
+	w.run(func() {
+		torrents, err := torrentClient.Torrents(infoHashes)
+		if err != nil {
+			log.Println("Unable to get the status of torrents from ", torrentClient.Name(), ", looking for them in the completed dir instead: ", err)
+		}
+		w.request(func() {
+			w.askingClient = false
+			w.clientDown = err != nil
+			if err == nil {
+				w.clientTorrents = make(map[string]*client.Torrent, len(infoHashes))
+				for _, infoHash := range infoHashes {
+					w.clientTorrents[infoHash] = torrents[infoHash]
+				}
+			}
+			w.matchCompletions()
+		})
+	})
+}
+
+// checkClient completes record if the torrent client last said it has finished. It returns false if the client
+// doesn't know the torrent or can't be asked, in which case the completed dir should be searched for it as usual
+func (w *SimpleWatcher) checkClient(torrentClient client.Client, record state.Record) bool {
+	if record.InfoHash == "" || w.clientDown {
+		return false
+	}
+	status, asked := w.clientTorrents[record.InfoHash]
+	if !asked {
+		// wait to hear about it, rather than take a look-alike in the completed dir
+		return true
+	}
+	if status == nil {
+		return false
+	}
+
+	if status.Done && w.settled(status.Name) {
+		log.Println(torrentClient.Name(), " finished ", record.Name, ": ", status.Name)
+		w.complete(record, status.Name)
+	}
+	return true
+}
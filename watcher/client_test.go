@@ -0,0 +1,163 @@
+package watcher
+
+import (
+	"errors"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	"github.com/MondayHopscotch/SuperScope/client"
+	"github.com/MondayHopscotch/SuperScope/state"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClient remembers what was added and reports the torrents it has been given
+type fakeClient struct {
+	added    []client.AddOptions
+	torrents map[string]*client.Torrent
+	// err fails every request for the torrents, and block holds them up until it's closed
+	err   error
+	block chan bool
+}
+
+func (f *fakeClient) Name() string {
+	return "fake"
+}
+
+func (f *fakeClient) AddTorrent(data []byte, options client.AddOptions) (string, error) {
+	f.added = append(f.added, options)
+	return "abc123", nil
+}
+
+func (f *fakeClient) AddMagnet(uri string, options client.AddOptions) (string, error) {
+	f.added = append(f.added, options)
+	return "c12fe1c06bba254a9dc9f519b335aa7c1367a88a", nil
+}
+
+func (f *fakeClient) Torrents(infoHashes []string) (map[string]*client.Torrent, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	found := make(map[string]*client.Torrent, 0)
+	for _, infoHash := range infoHashes {
+		if torrent, ok := f.torrents[infoHash]; ok {
+			found[infoHash] = torrent
+		}
+	}
+	return found, nil
+}
+
+// askClient has watcher ask the client about its active files and takes in the answer, as WatchForCompletion would
+func askClient(watcher *SimpleWatcher) {
+	watcher.askClient()
+	(<-watcher.requests)()
+}
+
+func TestClient(t *testing.T) {
+
+	Convey("Test torrents are added to the client instead of the drop dir", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		fake := &fakeClient{}
+		watcher.client = fake
+		serveRequests(watcher)
+
+		data, _ := bencode.Encode(map[string]interface{}{
+			"info": map[string]interface{}{"name": "Film", "piece length": 16384, "pieces": "", "length": 5},
+		})
+		ioutil.WriteFile("test/watch/movies/film.torrent", data, os.ModePerm)
+		uri := "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Some.Show.S01"
+		ioutil.WriteFile("test/watch/tv/show.magnet", []byte(uri), os.ModePerm)
+
+		watcher.consumeFileWithTimeout("test/watch/movies/film.torrent", time.Second)
+		watcher.consumeFileWithTimeout("test/watch/tv/show.magnet", time.Second)
+
+		completed, _ := filepath.Abs("test/complete")
+		So(fake.added, ShouldResemble, []client.AddOptions{
+			{Category: "movies", SavePath: completed},
+			{Category: "tv", SavePath: completed},
+		})
+		_, err := os.Stat("test/watch/movies/film.torrent")
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat("test/drop/film.torrent")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		active := activeFiles(watcher)
+		So(active["film.torrent"].InfoHash, ShouldEqual, "abc123")
+		So(active["film.torrent"].Info.Name, ShouldEqual, "Film")
+		So(active["show.magnet"].DisplayName, ShouldEqual, "Some.Show.S01")
+	})
+
+	Convey("Test completion comes from the client", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		fake := &fakeClient{torrents: map[string]*client.Torrent{
+			"abc123": {InfoHash: "abc123", Name: "Film", Progress: 0.5},
+		}}
+		watcher.client = fake
+
+		// a look-alike in the completed dir must not be mistaken for the real payload
+		ioutil.WriteFile("test/complete/Film.mkv", []byte("imposter"), os.ModePerm)
+		watcher.track(state.Record{Name: "film.torrent", OrigPath: "test/watch/movies/film.torrent", InfoHash: "abc123"})
+		watcher.track(state.Record{Name: "other.torrent", OrigPath: "test/watch/movies/other.torrent", InfoHash: "fff"})
+		ioutil.WriteFile("test/complete/other", []byte("other"), os.ModePerm)
+
+		// until the client answers, torrents it was given wait for it
+		watcher.checkForCompletions()
+		So(watcher.finalizing, ShouldBeEmpty)
+
+		askClient(watcher)
+		So(watcher.ActiveFiles, ShouldContainKey, "film.torrent")
+
+		// the client doesn't know other.torrent, so it was matched by name instead
+		So(watcher.finalizing, ShouldHaveLength, 1)
+		So(watcher.finalizing[0].orig, ShouldEqual, "other.torrent")
+
+		fake.torrents["abc123"] = &client.Torrent{InfoHash: "abc123", Name: "Film", Progress: 1, Done: true}
+		askClient(watcher)
+		So(watcher.ActiveFiles, ShouldNotContainKey, "film.torrent")
+		So(watcher.finalizing, ShouldHaveLength, 2)
+		So(watcher.finalizing[1].orig, ShouldEqual, "film.torrent")
+		So(watcher.finalizing[1].outFile, ShouldEqual, "Film")
+	})
+
+	Convey("Test payloads are found in the completed dir while the client is down", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		fake := &fakeClient{err: errors.New("connection refused")}
+		watcher.client = fake
+
+		watcher.track(state.Record{Name: "Film.2010.torrent", OrigPath: "test/watch/movies/Film.2010.torrent", InfoHash: "abc123"})
+		os.Mkdir("test/complete/Film.2010", os.ModePerm)
+		watcher.rescan()
+
+		askClient(watcher)
+		So(watcher.clientDown, ShouldBeTrue)
+		So(watcher.finalizing, ShouldHaveLength, 1)
+		So(watcher.finalizing[0].outFile, ShouldEqual, "Film.2010")
+	})
+
+	Convey("Test a slow client doesn't hold up the watcher", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		fake := &fakeClient{torrents: map[string]*client.Torrent{}, block: make(chan bool)}
+		watcher.client = fake
+		watcher.track(state.Record{Name: "film.torrent", OrigPath: "test/watch/movies/film.torrent", InfoHash: "abc123"})
+		watcher.run(watcher.WatchForCompletion)
+
+		So(watcher.do(watcher.askClient), ShouldBeNil)
+		start := time.Now()
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(status.ActiveFiles, ShouldHaveLength, 1)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		close(fake.block)
+		So(watcher.Close(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,427 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/classify"
+	"github.com/MondayHopscotch/SuperScope/extract"
+	"github.com/MondayHopscotch/SuperScope/link"
+	"github.com/MondayHopscotch/SuperScope/release"
+	"github.com/MondayHopscotch/SuperScope/routing"
+	"github.com/MondayHopscotch/SuperScope/tv"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// placement is a single file or directory from the completed dir and where it should end up in the media dir
+type placement struct {
+	src  string
+	dest string
+}
+
+// finalize verifies and places a completed payload, returning the category it was routed to and the directory it
+// was placed in. These are empty if finalizing failed before the payload was routed. If the watcher is closed while
+// a move is waiting to be retried, the error is context.Canceled
+func (w *SimpleWatcher) finalize(doneFile Finalizer) (string, string, error) {
+	settings := w.Settings()
+	payload := path.Join(w.completedDir, doneFile.outFile)
+
+	stat, err := os.Stat(payload)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to stat %v: %v", payload, err)
+	}
+
+	if settings.VerifyPieces {
+		info := doneFile.info
+		if info != nil && len(info.Pieces) == 0 {
+			// piece hashes aren't saved with the record, so after a restart they're read back from the torrent
+			meta, err := w.Store.LoadTorrent(doneFile.orig)
+			if err != nil {
+				log.Println("Unable to read piece hashes for ", doneFile.orig, ": ", err)
+				info = nil
+			} else {
+				info = &meta.Info
+			}
+		}
+		if info == nil {
+			log.Println("No torrent metadata for ", doneFile.orig, ", unable to verify ", payload)
+		} else {
+			log.Println("Verifying ", payload, " against torrent piece hashes")
+			err = info.Verify(payload)
+			if err != nil {
+				return "", "", fmt.Errorf("%v failed verification: %v", payload, err)
+			}
+			log.Println("Verified ", payload)
+		}
+	}
+
+	route := w.route(doneFile.origPath)
+	vars := routing.Vars{
+		Category:  route.Name,
+		SubDir:    path.Dir(w.relativeToRoot(doneFile.origPath)),
+		Name:      util.RemoveExtension(path.Base(doneFile.origPath)),
+		Completed: doneFile.outFile,
+	}
+	if vars.SubDir == "." {
+		vars.SubDir = ""
+	}
+	vars.Title, vars.Year = util.ReleaseTitle(doneFile.outFile)
+	vars = withRelease(vars, release.Parse(doneFile.outFile))
+	finalRestingPlace, err := route.DestinationDir(w.mediaDir, vars)
+	if err != nil {
+		return route.Name, "", fmt.Errorf("unable to determine destination for %v: %v", doneFile.outFile, err)
+	}
+	log.Println("Completed file ", doneFile.outFile, " is in category '", route.Name, "' using strategy ", route.Strategy)
+
+	files, err := payloadContents(route.Strategy, doneFile, payload, stat, w.stagingDir)
+	if err != nil {
+		return route.Name, finalRestingPlace, err
+	}
+	if route.Strategy != routing.StrategyFolder {
+		files = filterContents(route, files, payload, path.Join(w.stagingDir, doneFile.outFile))
+	}
+	placements, err := selectPlacements(route.Strategy, doneFile, payload, stat, files, finalRestingPlace)
+	if err != nil {
+		return route.Name, finalRestingPlace, err
+	}
+	if len(placements) == 0 {
+		return route.Name, finalRestingPlace, fmt.Errorf("nothing to place from %v", payload)
+	}
+	placements, err = renamePlacements(route, vars, placements, finalRestingPlace)
+	if err != nil {
+		return route.Name, finalRestingPlace, err
+	}
+	if route.Strategy != routing.StrategyFolder {
+		placements = append(placements, sidecarPlacements(route, placements, files)...)
+	}
+	sort.Slice(placements, func(i, j int) bool {
+		return placements[i].dest < placements[j].dest
+	})
+
+	for _, p := range placements {
+		p, placed, err := resolveCollision(p)
+		if err != nil {
+			return route.Name, finalRestingPlace, err
+		}
+		if placed {
+			log.Println(p.dest, " is already in place")
+			continue
+		}
+
+		destDir := filepath.Dir(p.dest)
+		log.Println("Ensure directory exists: ", destDir)
+		err = os.MkdirAll(destDir, os.ModePerm)
+		if err != nil {
+			return route.Name, finalRestingPlace, fmt.Errorf("failed to create parent directories for %v: %v", p.dest, err)
+		}
+
+		err = w.place(route.LinkMode, p, time.Duration(settings.MoveTimeout))
+		if err != nil {
+			return route.Name, finalRestingPlace, err
+		}
+	}
+	return route.Name, finalRestingPlace, nil
+}
+
+// payloadContents returns the size of every file the strategy can choose from, keyed by path. Every strategy but
+// folder looks inside archives in the payload, which are extracted under stagingDir
+func payloadContents(strategy routing.Strategy, doneFile Finalizer, payload string, stat os.FileInfo, stagingDir string) (map[string]int64, error) {
+	files := map[string]int64{payload: stat.Size()}
+	if strategy == routing.StrategyFolder {
+		return files, nil
+	}
+	if stat.IsDir() {
+		var err error
+		files, err = payloadFiles(payload)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read completed file directory %v: %v", payload, err)
+		}
+	}
+	// a payload that's a single archive is extracted as if it were alone in a directory
+	root := payload
+	if !stat.IsDir() {
+		root = path.Dir(payload)
+	}
+	return extractArchives(files, root, path.Join(stagingDir, doneFile.outFile))
+}
+
+// filterContents leaves out the files route doesn't want placed, like samples, trailers and junk. roots are the
+// directories the files are under, for matching the route's patterns against their path within the payload
+func filterContents(route *routing.Route, files map[string]int64, roots ...string) map[string]int64 {
+	kinds := classify.Classify(files, int64(route.MinSize), roots...)
+	wanted := make(map[string]int64, len(files))
+	for file, size := range files {
+		if route.Allows(classify.RelativePath(file, roots...), kinds[file]) {
+			wanted[file] = size
+		} else {
+			log.Println("Leaving out ", file, " (", kinds[file], ")")
+		}
+	}
+	return wanted
+}
+
+// selectPlacements decides what to take from files, the payload's contents, according to strategy
+func selectPlacements(strategy routing.Strategy, doneFile Finalizer, payload string, stat os.FileInfo, files map[string]int64, destDir string) ([]placement, error) {
+	if strategy == routing.StrategyFolder {
+		return []placement{{src: payload, dest: path.Join(destDir, doneFile.outFile)}}, nil
+	}
+	if strategy == routing.StrategyTV {
+		return episodePlacements(doneFile, files, destDir)
+	}
+	if _, ok := files[payload]; ok && !stat.IsDir() {
+		return []placement{{src: payload, dest: path.Join(destDir, doneFile.outFile)}}, nil
+	}
+
+	switch strategy {
+	case routing.StrategyLargest:
+		// media files are preferred, so a larger disc image or archive isn't taken for the feature
+		var largest string
+		var largestSize int64 = -1
+		largestIsMedia := false
+		for file, size := range files {
+			isMedia := util.IsMediaFile(file)
+			if (isMedia && !largestIsMedia) || (isMedia == largestIsMedia && size > largestSize) {
+				largest, largestSize, largestIsMedia = file, size, isMedia
+			}
+		}
+		if largest == "" {
+			return nil, fmt.Errorf("completed directory %v is empty", payload)
+		}
+		originalFileName := path.Base(doneFile.origPath)
+		compFileName := util.RemoveExtension(originalFileName) + path.Ext(largest)
+		log.Println("File to move: ", compFileName)
+		return []placement{{src: largest, dest: path.Join(destDir, compFileName)}}, nil
+	case routing.StrategyMedia:
+		placements := make([]placement, 0)
+		for file := range files {
+			if util.IsMediaFile(file) {
+				placements = append(placements, placement{src: file, dest: path.Join(destDir, path.Base(file))})
+			}
+		}
+		return placements, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %v", strategy)
+	}
+}
+
+// episodePlacements places every video file with an episode marker under <Show>/Season NN. The show name comes
+// from the payload name if possible so a whole season pack ends up under the same show, and if two files claim the
+// same episode the larger one wins
+func episodePlacements(doneFile Finalizer, files map[string]int64, destDir string) ([]placement, error) {
+	packShow := tv.ParseShow(doneFile.outFile)
+	if packShow == "" {
+		packShow = tv.ParseShow(util.RemoveExtension(path.Base(doneFile.origPath)))
+	}
+
+	byDest := make(map[string]placement, 0)
+	for file, size := range files {
+		if !util.IsMediaFile(file) {
+			continue
+		}
+		episode, ok := tv.Parse(path.Base(file))
+		if !ok {
+			log.Println("No episode marker found in ", file, ", skipping it")
+			continue
+		}
+
+		show := packShow
+		if show == "" {
+			show = episode.Show
+		}
+		if show == "" {
+			log.Println("Unable to work out the show for ", file, ", skipping it")
+			continue
+		}
+
+		dest := path.Join(destDir, show, episode.SeasonDir(), episode.FileName(show, path.Ext(file)))
+		if existing, ok := byDest[dest]; ok && files[existing.src] >= size {
+			continue
+		}
+		byDest[dest] = placement{src: file, dest: dest}
+	}
+
+	placements := make([]placement, 0, len(byDest))
+	for _, p := range byDest {
+		placements = append(placements, p)
+	}
+	if len(placements) == 0 {
+		return nil, fmt.Errorf("no episodes found in %v", doneFile.outFile)
+	}
+	return placements, nil
+}
+
+// withRelease fills in the release attributes of vars that r has
+func withRelease(vars routing.Vars, r release.Release) routing.Vars {
+	for _, attribute := range []struct {
+		value string
+		dest  *string
+	}{
+		{r.Resolution, &vars.Resolution},
+		{r.Source, &vars.Source},
+		{r.Codec, &vars.Codec},
+		{r.Group, &vars.Group},
+		{r.Edition, &vars.Edition},
+	} {
+		if attribute.value != "" {
+			*attribute.dest = attribute.value
+		}
+	}
+	return vars
+}
+
+// renamePlacements names each placement with the route's naming template, if it has one, using what can be
+// parsed from the placed file's own name on top of the payload's vars
+func renamePlacements(route *routing.Route, vars routing.Vars, placements []placement, destDir string) ([]placement, error) {
+	if route.Naming == "" {
+		return placements, nil
+	}
+
+	renamed := make([]placement, 0, len(placements))
+	for _, p := range placements {
+		fileVars := withRelease(vars, release.Parse(path.Base(p.src)))
+		fileVars.File = path.Base(p.src)
+		if stat, err := os.Stat(p.src); err == nil && !stat.IsDir() {
+			fileVars.Ext = path.Ext(p.src)
+			fileVars.File = util.RemoveExtension(fileVars.File)
+		}
+		if episode, ok := tv.Parse(path.Base(p.src)); ok {
+			fileVars.Season = episode.Season
+			fileVars.Episode = episode.Code()
+		}
+
+		name, _, err := route.FileName(fileVars)
+		if err != nil {
+			return nil, fmt.Errorf("unable to name %v: %v", p.src, err)
+		}
+		renamed = append(renamed, placement{src: p.src, dest: path.Join(destDir, name)})
+	}
+	return renamed, nil
+}
+
+// resolveCollision stops p from replacing something already in the media dir by giving it the next free name. If
+// what's there is already p.src, from an earlier attempt at finalizing, it returns true as there's nothing to do
+func resolveCollision(p placement) (placement, bool, error) {
+	if _, err := os.Lstat(p.dest); os.IsNotExist(err) {
+		return p, false, nil
+	}
+
+	srcStat, err := os.Stat(p.src)
+	if err != nil {
+		return p, false, nil
+	}
+	if destStat, err := os.Stat(p.dest); err == nil && os.SameFile(srcStat, destStat) {
+		return p, true, nil
+	}
+
+	dest, err := util.UniquePath(p.dest, srcStat.IsDir())
+	if err != nil {
+		return p, false, fmt.Errorf("unable to find a free name for %v: %v", p.dest, err)
+	}
+	if dest != p.dest {
+		log.Println(p.dest, " already exists, placing ", p.src, " at ", dest, " instead")
+	}
+	return placement{src: p.src, dest: dest}, false, nil
+}
+
+// extractArchives swaps the archives among files for what they contain, extracted into staging at the same path
+// they have under root. An earlier extraction into staging is reused, so finalizing again doesn't extract everything
+// twice. Archives we can't read are left out with a warning
+func extractArchives(files map[string]int64, root string, staging string) (map[string]int64, error) {
+	paths := make([]string, 0, len(files))
+	for file := range files {
+		paths = append(paths, file)
+	}
+	archives := extract.Find(paths)
+	if len(archives) == 0 {
+		return files, nil
+	}
+
+	result := make(map[string]int64, 0)
+	for file, size := range files {
+		if !extract.IsPart(file) {
+			result[file] = size
+		}
+	}
+
+	if _, err := os.Stat(staging); os.IsNotExist(err) {
+		// extract somewhere temporary first so a failure part way through isn't mistaken for a finished extraction
+		partial := staging + ".partial"
+		os.RemoveAll(partial)
+		for _, archive := range archives {
+			relDir, err := filepath.Rel(root, filepath.Dir(archive.Path))
+			if err != nil {
+				return nil, fmt.Errorf("failed to extract %v: %v", archive.Path, err)
+			}
+			_, err = extract.Extract(archive, filepath.Join(partial, relDir))
+			if err == extract.ErrUnsupported {
+				log.Println("Unable to extract ", archive.Path, ": ", err)
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to extract %v: %v", archive.Path, err)
+			}
+		}
+		err = os.MkdirAll(partial, os.ModePerm)
+		if err == nil {
+			err = os.Rename(partial, staging)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to stage files extracted into %v: %v", staging, err)
+		}
+	} else {
+		log.Println("Using files already extracted to ", staging)
+	}
+
+	extracted, err := payloadFiles(staging)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read extracted files in %v: %v", staging, err)
+	}
+	for file, size := range extracted {
+		result[file] = size
+	}
+	return result, nil
+}
+
+// payloadFiles returns the size of every regular file under dir, keyed by path
+func payloadFiles(dir string) (map[string]int64, error) {
+	files := make(map[string]int64, 0)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files[filepath.ToSlash(file)] = info.Size()
+		}
+		return nil
+	})
+	return files, err
+}
+
+// place puts p.src at p.dest. Moves are retried until moveTimeout, as the client may still have the file open, or
+// until the watcher is closed, which returns context.Canceled
+func (w *SimpleWatcher) place(linkMode string, p placement, moveTimeout time.Duration) error {
+	mode := link.Mode(linkMode)
+	if mode == "" {
+		mode = link.DefaultMode()
+	}
+
+	var err error
+	start := time.Now()
+	for {
+		err = link.Place(mode, p.src, p.dest)
+		if err == nil || mode != link.Move || time.Since(start) >= moveTimeout {
+			break
+		}
+		if !w.wait(time.Second * 5) {
+			return context.Canceled
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %v completed file %v: %v", mode, p.src, err)
+	}
+	return nil
+}
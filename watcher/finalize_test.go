@@ -0,0 +1,328 @@
+package watcher
+
+import (
+	"archive/zip"
+	"context"
+	"github.com/MondayHopscotch/SuperScope/classify"
+	"github.com/MondayHopscotch/SuperScope/routing"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFinalize(t *testing.T) {
+
+	Convey("Test media strategy places every media file", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "music", Dir: "music", Strategy: routing.StrategyMedia, Destination: "Music/{{.Name}}"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		os.MkdirAll("test/complete/album/CD2", os.ModePerm)
+		ioutil.WriteFile("test/complete/album/01.flac", []byte("1"), os.ModePerm)
+		ioutil.WriteFile("test/complete/album/CD2/02.flac", []byte("2"), os.ModePerm)
+		ioutil.WriteFile("test/complete/album/album.nfo", []byte("info"), os.ModePerm)
+
+		category, destination, err := watcher.finalize(Finalizer{orig: "album.torrent", origPath: "test/watch/music/album.torrent", outFile: "album"})
+		So(err, ShouldBeNil)
+		So(category, ShouldEqual, "music")
+		So(destination, ShouldEqual, "test/media/Music/album")
+
+		_, err = os.Lstat("test/media/Music/album/01.flac")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/Music/album/02.flac")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/Music/album/album.nfo")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test folder strategy places the whole payload", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "tv", Dir: "tv", Strategy: routing.StrategyFolder}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		os.MkdirAll("test/complete/Show Season 1", os.ModePerm)
+		ioutil.WriteFile("test/complete/Show Season 1/ep1.mkv", []byte("1"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "show.torrent", origPath: "test/watch/tv/show.torrent", outFile: "Show Season 1"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/tv/Show Season 1")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test uncategorized paths are no longer mistaken for tv", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		os.MkdirAll("test/watch/documentaries/tvnz", os.ModePerm)
+		So(watcher.route("test/watch/documentaries/tvnz/doc.torrent").Name, ShouldEqual, "")
+		So(watcher.route("test/watch/tv/show.torrent").Name, ShouldEqual, "tv")
+	})
+
+	Convey("Test finalizing an empty directory fails", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		os.MkdirAll("test/complete/empty", os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "empty.torrent", origPath: "test/watch/movies/empty.torrent", outFile: "empty"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test tv strategy places a season pack by episode", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		os.MkdirAll("test/complete/Show.Name.S01.720p-GRP/Sample", os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.Name.S01.720p-GRP/show.name.s01e01.720p-grp.mkv", []byte("episode one"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.Name.S01.720p-GRP/show.name.s01e02e03.720p-grp.mkv", []byte("episodes two and three"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.Name.S01.720p-GRP/Sample/show.name.s01e01.sample.mkv", []byte("s"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.Name.S01.720p-GRP/show.name.nfo", []byte("info"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "pack.torrent", origPath: "test/watch/tv/pack.torrent", outFile: "Show.Name.S01.720p-GRP"})
+		So(err, ShouldBeNil)
+
+		target, err := os.Readlink("test/media/tv/Show Name/Season 01/Show Name - S01E01.mkv")
+		So(err, ShouldBeNil)
+		So(target, ShouldEndWith, "show.name.s01e01.720p-grp.mkv")
+
+		_, err = os.Lstat("test/media/tv/Show Name/Season 01/Show Name - S01E02-E03.mkv")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test tv strategy places a single episode file", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		ioutil.WriteFile("test/complete/The.Daily.Show.2020.01.31.mkv", []byte("e"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "daily.torrent", origPath: "test/watch/tv/daily.torrent", outFile: "The.Daily.Show.2020.01.31.mkv"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/tv/The Daily Show/Season 2020/The Daily Show - 2020-01-31.mkv")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test tv strategy fails without episodes", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		os.MkdirAll("test/complete/Extras", os.ModePerm)
+		ioutil.WriteFile("test/complete/Extras/interview.mkv", []byte("e"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "extras.torrent", origPath: "test/watch/tv/extras.torrent", outFile: "Extras"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test naming template renames placed files", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{
+			Name:     "movies",
+			Dir:      "movies",
+			Strategy: routing.StrategyLargest,
+			Naming:   "{{.Title}} ({{.Year}})/{{.Title}} ({{.Year}}) - {{.Resolution}}{{.Ext}}",
+		}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		os.MkdirAll("test/complete/Movie.Name.2010.1080p.BluRay.x264-GRP", os.ModePerm)
+		ioutil.WriteFile("test/complete/Movie.Name.2010.1080p.BluRay.x264-GRP/movie.mkv", []byte("the movie"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Movie.Name.2010.1080p.BluRay.x264-GRP/sample.mkv", []byte("s"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/movie.torrent", outFile: "Movie.Name.2010.1080p.BluRay.x264-GRP"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/movies/Movie Name (2010)/Movie Name (2010) - 1080p.mkv")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test placing over an existing file", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "movies", Dir: "movies", Strategy: routing.StrategyFolder, LinkMode: "copy"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		ioutil.WriteFile("test/complete/film.mkv", []byte("new film"), os.ModePerm)
+		os.MkdirAll("test/media/movies", os.ModePerm)
+		ioutil.WriteFile("test/media/movies/film.mkv", []byte("old film"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "film.torrent", origPath: "test/watch/movies/film.torrent", outFile: "film.mkv"})
+		So(err, ShouldBeNil)
+
+		data, _ := ioutil.ReadFile("test/media/movies/film.mkv")
+		So(string(data), ShouldEqual, "old film")
+		data, _ = ioutil.ReadFile("test/media/movies/film (2).mkv")
+		So(string(data), ShouldEqual, "new film")
+	})
+
+	Convey("Test finalizing again skips what is already in place", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "movies", Dir: "movies", Strategy: routing.StrategyFolder, LinkMode: "hardlink"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		ioutil.WriteFile("test/complete/film.mkv", []byte("film"), os.ModePerm)
+		doneFile := Finalizer{orig: "film.torrent", origPath: "test/watch/movies/film.torrent", outFile: "film.mkv"}
+
+		_, _, err := watcher.finalize(doneFile)
+		So(err, ShouldBeNil)
+		_, _, err = watcher.finalize(doneFile)
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/movies/film (2).mkv")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test archives in the payload are extracted and placed", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		os.MkdirAll("test/complete/Movie.2010.1080p-GRP", os.ModePerm)
+		writeZip("test/complete/Movie.2010.1080p-GRP/movie.zip", "movie.2010.1080p-grp.mkv", "the whole movie, much larger than the archive")
+		ioutil.WriteFile("test/complete/Movie.2010.1080p-GRP/movie.nfo", []byte("info"), os.ModePerm)
+
+		doneFile := Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/movie.torrent", outFile: "Movie.2010.1080p-GRP"}
+		_, _, err := watcher.finalize(doneFile)
+		So(err, ShouldBeNil)
+
+		data, err := ioutil.ReadFile("test/media/movies/movie.mkv")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "the whole movie, much larger than the archive")
+		_, err = os.Stat("test/complete/Movie.2010.1080p-GRP/movie.zip")
+		So(err, ShouldBeNil)
+		_, err = os.Stat("test/complete/.superscope-staging/Movie.2010.1080p-GRP/movie.2010.1080p-grp.mkv")
+		So(err, ShouldBeNil)
+
+		// the staged files are reused rather than extracted again
+		os.Remove("test/complete/Movie.2010.1080p-GRP/movie.zip")
+		_, _, err = watcher.finalize(doneFile)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test a single archive payload is extracted", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.StagingDir = "test/staging"
+		cfg.Categories = []routing.Rule{{Name: "music", Dir: "music", Strategy: routing.StrategyMedia, Destination: "Music"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		writeZip("test/complete/album.zip", "01.flac", "1")
+
+		_, _, err := watcher.finalize(Finalizer{orig: "album.torrent", origPath: "test/watch/music/album.torrent", outFile: "album.zip"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/Music/01.flac")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/Music/album.zip")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test a single archive payload is staged under its own name", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.StagingDir = "test/staging"
+		cfg.Categories = []routing.Rule{{Name: "movies", Dir: "movies", Strategy: routing.StrategyLargest, Destination: "Movies"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		writeZip("test/complete/Film.2010.zip", "film.mkv", "the whole film")
+
+		_, _, err := watcher.finalize(Finalizer{orig: "Film.2010.torrent", origPath: "test/watch/movies/Film.2010.torrent", outFile: "Film.2010.zip"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Stat("test/staging/Film.2010.zip/film.mkv")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/Movies/Film.2010.mkv")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/Movies/Film.2010.zip")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test the staging dir is never a completed payload", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		So(watcher.isStagingDir(".superscope-staging"), ShouldBeTrue)
+		So(watcher.isStagingDir("Movie.2010.1080p-GRP"), ShouldBeFalse)
+	})
+
+	Convey("Test largest strategy ignores extras, samples and disc images", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		payload := "test/complete/Movie.2010.1080p-GRP"
+		os.MkdirAll(payload+"/Extras", os.ModePerm)
+		os.MkdirAll(payload+"/Sample", os.ModePerm)
+		ioutil.WriteFile(payload+"/movie.mkv", []byte("the whole movie"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Extras/making of the whole movie.mkv", []byte("the making of the whole movie"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Sample/sample.mkv", []byte("s"), os.ModePerm)
+		ioutil.WriteFile(payload+"/movie.iso", []byte("the whole movie on a disc, with menus"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/Movie.torrent", outFile: "Movie.2010.1080p-GRP"})
+		So(err, ShouldBeNil)
+
+		data, _ := ioutil.ReadFile("test/media/movies/Movie.mkv")
+		So(string(data), ShouldEqual, "the whole movie")
+	})
+
+	Convey("Test media strategy places the kinds a category asks for", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "movies", Dir: "movies", Strategy: routing.StrategyMedia, Kinds: []classify.Kind{classify.Media, classify.Extra}, Exclude: []string{"*deleted*"}}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		payload := "test/complete/Movie"
+		os.MkdirAll(payload+"/Extras", os.ModePerm)
+		ioutil.WriteFile(payload+"/movie.mkv", []byte("the whole movie"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Extras/interview.mkv", []byte("an interview"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Extras/deleted scene.mkv", []byte("a deleted scene"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Movie.Trailer.mkv", []byte("a trailer"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/Movie.torrent", outFile: "Movie"})
+		So(err, ShouldBeNil)
+
+		_, err = os.Lstat("test/media/movies/movie.mkv")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/movies/interview.mkv")
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/movies/deleted scene.mkv")
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Lstat("test/media/movies/Movie.Trailer.mkv")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test closing stops placing a payload", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		// the move keeps failing as there's nothing to move, so it would retry for an hour
+		placed := make(chan error, 1)
+		watcher.run(func() {
+			placed <- watcher.place("move", placement{src: "test/complete/missing.avi", dest: "test/media/missing.avi"}, time.Hour)
+		})
+		time.Sleep(time.Millisecond * 100)
+
+		start := time.Now()
+		So(watcher.Close(), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second*5)
+		So(<-placed, ShouldEqual, context.Canceled)
+
+		// a payload already being finalized is still placed, as long as it doesn't have to wait
+		ioutil.WriteFile("test/complete/film.avi", []byte("film"), os.ModePerm)
+		_, _, err := watcher.finalize(Finalizer{orig: "film.torrent", origPath: "test/watch/movies/film.torrent", outFile: "film.avi"})
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/movies/film.avi")
+		So(err, ShouldBeNil)
+	})
+}
+
+func writeZip(name string, file string, content string) {
+	archive, _ := os.Create(name)
+	writer := zip.NewWriter(archive)
+	entry, _ := writer.Create(file)
+	entry.Write([]byte(content))
+	writer.Close()
+	archive.Close()
+}
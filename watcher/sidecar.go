@@ -0,0 +1,160 @@
+package watcher
+
+import (
+	"github.com/MondayHopscotch/SuperScope/routing"
+	"github.com/MondayHopscotch/SuperScope/tv"
+	"github.com/MondayHopscotch/SuperScope/util"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// artwork are image names media servers look for in a movie's directory, so they're placed without renaming
+var artwork = map[string]bool{
+	"poster": true, "folder": true, "cover": true, "fanart": true, "backdrop": true, "banner": true, "logo": true,
+	"clearart": true, "clearlogo": true, "disc": true, "discart": true, "landscape": true, "thumb": true,
+}
+
+// languages maps the language names and codes found in subtitle names to ISO 639-1 codes
+var languages = map[string]string{
+	"en": "en", "eng": "en", "english": "en",
+	"fr": "fr", "fre": "fr", "fra": "fr", "french": "fr",
+	"de": "de", "ger": "de", "deu": "de", "german": "de",
+	"es": "es", "spa": "es", "spanish": "es",
+	"it": "it", "ita": "it", "italian": "it",
+	"pt": "pt", "por": "pt", "portuguese": "pt",
+	"nl": "nl", "dut": "nl", "nld": "nl", "dutch": "nl",
+	"sv": "sv", "swe": "sv", "swedish": "sv",
+	"no": "no", "nor": "no", "norwegian": "no",
+	"da": "da", "dan": "da", "danish": "da",
+	"fi": "fi", "fin": "fi", "finnish": "fi",
+	"pl": "pl", "pol": "pl", "polish": "pl",
+	"cs": "cs", "cze": "cs", "ces": "cs", "czech": "cs",
+	"hu": "hu", "hun": "hu", "hungarian": "hu",
+	"el": "el", "gre": "el", "ell": "el", "greek": "el",
+	"tr": "tr", "tur": "tr", "turkish": "tr",
+	"ru": "ru", "rus": "ru", "russian": "ru",
+	"ar": "ar", "ara": "ar", "arabic": "ar",
+	"he": "he", "heb": "he", "hebrew": "he",
+	"ja": "ja", "jpn": "ja", "japanese": "ja",
+	"zh": "zh", "chi": "zh", "zho": "zh", "chinese": "zh",
+	"ko": "ko", "kor": "ko", "korean": "ko",
+}
+
+// subtitleFlags are kept in subtitle names after the language, as media servers understand them
+var subtitleFlags = map[string]bool{"forced": true, "sdh": true, "cc": true, "hi": true, "default": true}
+
+var nameTokens = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// sidecarPlacements places every file matching the route's sidecar patterns next to the placed media file it
+// belongs to, renamed to match it
+func sidecarPlacements(route *routing.Route, placements []placement, files map[string]int64) []placement {
+	placed := make(map[string]bool, len(placements))
+	for _, p := range placements {
+		placed[p.src] = true
+	}
+
+	sidecars := make([]string, 0)
+	for file := range files {
+		if !placed[file] && route.IsSidecar(file) {
+			sidecars = append(sidecars, file)
+		}
+	}
+	sort.Strings(sidecars)
+
+	result := make([]placement, 0, len(sidecars))
+	for _, file := range sidecars {
+		owner, ok := sidecarOwner(file, placements)
+		if !ok {
+			log.Println("Unable to tell which file ", file, " belongs to, skipping it")
+			continue
+		}
+		result = append(result, placement{src: file, dest: path.Join(path.Dir(owner.dest), sidecarName(file, owner))})
+	}
+	return result
+}
+
+// sidecarOwner finds the placement a sidecar belongs to: the one whose name it starts with, the one for the same
+// episode, or the only one there is
+func sidecarOwner(file string, placements []placement) (placement, bool) {
+	var owner placement
+	longest := -1
+	for _, p := range placements {
+		stem := util.RemoveExtension(path.Base(p.src))
+		if hasStemPrefix(path.Base(file), stem) && len(stem) > longest {
+			owner, longest = p, len(stem)
+		}
+	}
+	if longest >= 0 {
+		return owner, true
+	}
+
+	if episode, ok := tv.Parse(path.Base(file)); ok {
+		found := 0
+		for _, p := range placements {
+			if other, ok := tv.Parse(path.Base(p.src)); ok && other.Code() == episode.Code() {
+				owner = p
+				found++
+			}
+		}
+		if found == 1 {
+			return owner, true
+		}
+	}
+
+	if len(placements) == 1 {
+		return placements[0], true
+	}
+	return placement{}, false
+}
+
+// hasStemPrefix returns true if name starts with stem followed by a separator, ignoring case
+func hasStemPrefix(name string, stem string) bool {
+	if len(name) <= len(stem) || !strings.EqualFold(name[:len(stem)], stem) {
+		return false
+	}
+	return nameTokens.MatchString(name[len(stem) : len(stem)+1])
+}
+
+// sidecarName renames a sidecar to match the placed file it belongs to, keeping whatever followed the original
+// file's name, like the .en.forced of Movie.en.forced.srt. Otherwise languages and flags found in its name are
+// kept, so Subs/2_English.srt becomes Movie Name.en.srt. Artwork keeps its name
+func sidecarName(file string, owner placement) string {
+	base := path.Base(file)
+	stem, ext := util.RemoveExtension(base), strings.ToLower(path.Ext(base))
+	if artwork[strings.ToLower(stem)] {
+		return base
+	}
+
+	destStem := util.RemoveExtension(path.Base(owner.dest))
+	srcStem := util.RemoveExtension(path.Base(owner.src))
+	if strings.EqualFold(stem, srcStem) {
+		return destStem + ext
+	}
+	if hasStemPrefix(base, srcStem) {
+		return destStem + stem[len(srcStem):] + ext
+	}
+
+	// words from the media file's name aren't languages, like the No of No.Country.For.Old.Men.srt
+	ownerWords := make(map[string]bool, 0)
+	for _, word := range nameTokens.Split(strings.ToLower(srcStem), -1) {
+		ownerWords[word] = true
+	}
+
+	suffix := ""
+	seen := make(map[string]bool, 0)
+	for _, word := range nameTokens.Split(strings.ToLower(stem), -1) {
+		tag := languages[word]
+		if subtitleFlags[word] {
+			tag = word
+		}
+		if tag == "" || ownerWords[word] || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		suffix += "." + tag
+	}
+	return destStem + suffix + ext
+}
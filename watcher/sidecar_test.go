@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"github.com/MondayHopscotch/SuperScope/routing"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSidecar(t *testing.T) {
+
+	Convey("Test sidecar names follow the placed file", t, func() {
+		movie := placement{src: "complete/Movie.2010/movie.mkv", dest: "media/movies/Movie (2010).mkv"}
+		cases := []struct {
+			file     string
+			expected string
+		}{
+			{"complete/Movie.2010/movie.srt", "Movie (2010).srt"},
+			{"complete/Movie.2010/movie.en.forced.srt", "Movie (2010).en.forced.srt"},
+			{"complete/Movie.2010/movie.NFO", "Movie (2010).nfo"},
+			{"complete/Movie.2010/movie-poster.jpg", "Movie (2010)-poster.jpg"},
+			{"complete/Movie.2010/Subs/2_English.srt", "Movie (2010).en.srt"},
+			{"complete/Movie.2010/Subs/French.Forced.ass", "Movie (2010).fr.forced.ass"},
+			{"complete/Movie.2010/Subs/eng.SDH.srt", "Movie (2010).en.sdh.srt"},
+			{"complete/Movie.2010/poster.jpg", "poster.jpg"},
+		}
+		for _, c := range cases {
+			So(sidecarName(c.file, movie), ShouldEqual, c.expected)
+		}
+
+		// words from the movie's own name aren't languages
+		noCountry := placement{src: "complete/No.Country.For.Old.Men.2007.mkv", dest: "media/No Country.mkv"}
+		So(sidecarName("complete/Subs/No.Country.For.Old.Men.English.srt", noCountry), ShouldEqual, "No Country.en.srt")
+	})
+
+	Convey("Test sidecars find the file they belong to", t, func() {
+		placements := []placement{
+			{src: "pack/show.s01e01.720p.mkv", dest: "media/Show/Season 01/Show - S01E01.mkv"},
+			{src: "pack/show.s01e02.720p.mkv", dest: "media/Show/Season 01/Show - S01E02.mkv"},
+		}
+
+		owner, ok := sidecarOwner("pack/show.s01e01.720p.en.srt", placements)
+		So(ok, ShouldBeTrue)
+		So(owner, ShouldResemble, placements[0])
+
+		owner, ok = sidecarOwner("pack/Subs/Show.S01E02.English.srt", placements)
+		So(ok, ShouldBeTrue)
+		So(owner, ShouldResemble, placements[1])
+
+		_, ok = sidecarOwner("pack/show.nfo", placements)
+		So(ok, ShouldBeFalse)
+
+		owner, ok = sidecarOwner("pack/show.nfo", placements[:1])
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("Test sidecars are placed with the largest file", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		payload := "test/complete/Movie.2010.1080p-GRP"
+		os.MkdirAll(payload+"/Subs", os.ModePerm)
+		ioutil.WriteFile(payload+"/movie.2010.1080p-grp.mkv", []byte("the whole movie"), os.ModePerm)
+		ioutil.WriteFile(payload+"/movie.2010.1080p-grp.nfo", []byte("info"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Subs/2_English.srt", []byte("subs"), os.ModePerm)
+		ioutil.WriteFile(payload+"/Subs/3_English.forced.srt", []byte("forced subs"), os.ModePerm)
+		ioutil.WriteFile(payload+"/poster.jpg", []byte("art"), os.ModePerm)
+		ioutil.WriteFile(payload+"/RARBG.txt", []byte("ad"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/Movie.torrent", outFile: "Movie.2010.1080p-GRP"})
+		So(err, ShouldBeNil)
+
+		for _, file := range []string{"Movie.mkv", "Movie.nfo", "Movie.en.srt", "Movie.en.forced.srt", "poster.jpg"} {
+			_, err = os.Lstat("test/media/movies/" + file)
+			So(err, ShouldBeNil)
+		}
+		_, err = os.Lstat("test/media/movies/RARBG.txt")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Test an empty sidecar list places none", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.Categories = []routing.Rule{{Name: "movies", Dir: "movies", Strategy: routing.StrategyLargest, Sidecars: []string{}}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		os.MkdirAll("test/complete/Movie", os.ModePerm)
+		ioutil.WriteFile("test/complete/Movie/movie.mkv", []byte("the whole movie"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Movie/movie.srt", []byte("subs"), os.ModePerm)
+
+		_, _, err := watcher.finalize(Finalizer{orig: "movie.torrent", origPath: "test/watch/movies/Movie.torrent", outFile: "Movie"})
+		So(err, ShouldBeNil)
+		_, err = os.Lstat("test/media/movies/Movie.srt")
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,177 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/match"
+	"github.com/MondayHopscotch/SuperScope/metrics"
+	"github.com/MondayHopscotch/SuperScope/state"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyLimit is how many finished records Status reports
+const historyLimit = 50
+
+var (
+	errNotResponding = errors.New("completion watcher is not responding")
+	errClosed        = errors.New("watcher is closed")
+)
+
+// requestTimeout is how long a request waits for the WatchForCompletion goroutine to pick it up
+var requestTimeout = time.Second * 10
+
+// Status is a snapshot of what the watcher is doing
+type Status struct {
+	WatchedDirs []string       `json:"watchedDirs"`
+	ActiveFiles []ActiveStatus `json:"activeFiles"`
+	IgnoreFiles []string       `json:"ignoreFiles"`
+	// History is the most recently linked, failed or cancelled records, newest first
+	History []state.Record `json:"history"`
+}
+
+type ActiveStatus struct {
+	state.Record
+	Age string `json:"age"`
+	// Ambiguous lists the completed entries that were too close to call, best first
+	Ambiguous []match.Result `json:"ambiguous,omitempty"`
+}
+
+// do runs request on the WatchForCompletion goroutine and waits for it to finish
+func (w *SimpleWatcher) do(request func()) error {
+	done := make(chan bool)
+	select {
+	case w.requests <- func() {
+		request()
+		close(done)
+	}:
+	case <-time.After(requestTimeout):
+		return errNotResponding
+	case <-w.ctx.Done():
+		return errClosed
+	}
+	<-done
+	return nil
+}
+
+func (w *SimpleWatcher) Status() (Status, error) {
+	status := Status{
+		WatchedDirs: make([]string, 0),
+		ActiveFiles: make([]ActiveStatus, 0),
+		History:     make([]state.Record, 0),
+	}
+
+	err := w.do(func() {
+		for dir := range w.WatchedDirs {
+			status.WatchedDirs = append(status.WatchedDirs, dir)
+		}
+		for _, record := range w.ActiveFiles {
+			age := time.Since(record.ConsumedAt).Round(time.Second)
+			status.ActiveFiles = append(status.ActiveFiles, ActiveStatus{Record: record, Age: age.String(), Ambiguous: w.Ambiguous[record.Name]})
+		}
+		status.IgnoreFiles = make([]string, 0, len(w.IgnoreFiles))
+		for compFile := range w.IgnoreFiles {
+			status.IgnoreFiles = append(status.IgnoreFiles, compFile)
+		}
+	})
+	if err != nil {
+		return status, err
+	}
+
+	sort.Strings(status.WatchedDirs)
+	sort.Strings(status.IgnoreFiles)
+	sort.Slice(status.ActiveFiles, func(i, j int) bool {
+		return status.ActiveFiles[i].ConsumedAt.Before(status.ActiveFiles[j].ConsumedAt)
+	})
+
+	records := w.Store.All()
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].UpdatedAt.After(records[j].UpdatedAt)
+	})
+	for _, record := range records {
+		if !record.Pending() && len(status.History) < historyLimit {
+			status.History = append(status.History, record)
+		}
+	}
+	return status, nil
+}
+
+// CancelTracking stops looking for the completed payload of the named torrent
+func (w *SimpleWatcher) CancelTracking(name string) error {
+	var err error
+	doErr := w.do(func() {
+		_, ok := w.ActiveFiles[name]
+		if !ok {
+			err = fmt.Errorf("%v is not being tracked", name)
+			return
+		}
+		delete(w.ActiveFiles, name)
+		delete(w.Ambiguous, name)
+		metrics.ActiveTracked.Dec()
+		err = w.Store.SetStatus(name, state.StatusCancelled, nil)
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// ForceMatch finalizes the torrent with the given name or infohash with completed, an entry in the completed dir,
+// skipping matching entirely
+func (w *SimpleWatcher) ForceMatch(name string, completed string) error {
+	if completed == "" || path.Base(completed) != completed {
+		return fmt.Errorf("%q is not an entry in the completed dir", completed)
+	}
+	_, err := os.Stat(path.Join(w.completedDir, completed))
+	if err != nil {
+		return err
+	}
+
+	doErr := w.do(func() {
+		record, ok := w.findActive(name)
+		if !ok {
+			err = fmt.Errorf("%v is not being tracked", name)
+			return
+		}
+		w.complete(record, completed)
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// CompletePayload is ForceMatch for a payload path, as a client's on-complete command would report it. The path
+// must be in the completed dir, and is finalized from its top level entry there
+func (w *SimpleWatcher) CompletePayload(key string, payloadPath string) error {
+	completedDir, err := filepath.Abs(w.completedDir)
+	if err != nil {
+		return err
+	}
+	payloadPath, err = filepath.Abs(payloadPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(completedDir, payloadPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%v is not in the completed dir %v", payloadPath, completedDir)
+	}
+	return w.ForceMatch(key, strings.SplitN(filepath.ToSlash(rel), "/", 2)[0])
+}
+
+// findActive looks up a tracked record by name, falling back to its infohash
+func (w *SimpleWatcher) findActive(key string) (state.Record, bool) {
+	if record, ok := w.ActiveFiles[key]; ok {
+		return record, true
+	}
+	for _, record := range w.ActiveFiles {
+		if record.InfoHash != "" && strings.EqualFold(record.InfoHash, key) {
+			return record, true
+		}
+	}
+	return state.Record{}, false
+}
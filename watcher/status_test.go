@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"github.com/MondayHopscotch/SuperScope/state"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+
+	Convey("Test status snapshot", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.WatchedDirs["test/watch"] = true
+		watcher.IgnoreFiles["old.avi"] = true
+		watcher.ActiveFiles["a.torrent"] = state.Record{Name: "a.torrent", OrigPath: "test/watch/a.torrent", ConsumedAt: time.Now().Add(-time.Minute)}
+		watcher.Store.Put(state.Record{Name: "done.torrent", Status: state.StatusLinked})
+		watcher.Store.Put(state.Record{Name: "waiting.torrent", Status: state.StatusConsumed})
+
+		watcher.run(watcher.WatchForCompletion)
+
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(status.WatchedDirs, ShouldResemble, []string{"test/watch"})
+		So(status.IgnoreFiles, ShouldResemble, []string{"old.avi"})
+		So(len(status.ActiveFiles), ShouldEqual, 1)
+		So(status.ActiveFiles[0].Age, ShouldEqual, "1m0s")
+		So(len(status.History), ShouldEqual, 1)
+		So(status.History[0].Name, ShouldEqual, "done.torrent")
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test cancel tracking", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "a.torrent", Status: state.StatusConsumed})
+
+		watcher.run(watcher.WatchForCompletion)
+
+		So(watcher.CancelTracking("unknown.torrent"), ShouldNotBeNil)
+		So(watcher.CancelTracking("a.torrent"), ShouldBeNil)
+
+		record, _ := watcher.Store.Get("a.torrent")
+		So(record.Status, ShouldEqual, state.StatusCancelled)
+
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(len(status.ActiveFiles), ShouldEqual, 0)
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test force match", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "a.torrent", OrigPath: "test/watch/movies/a.torrent", Status: state.StatusConsumed})
+		f, _ := os.Create("test/complete/Unrelated Name.avi")
+		f.Close()
+
+		watcher.run(watcher.WatchForCompletion)
+
+		So(watcher.ForceMatch("a.torrent", "missing.avi"), ShouldNotBeNil)
+		So(watcher.ForceMatch("a.torrent", "../escape"), ShouldNotBeNil)
+
+		matched := make(chan error)
+		go func() {
+			matched <- watcher.ForceMatch("a.torrent", "Unrelated Name.avi")
+		}()
+		finalizer := <-watcher.DoneFiles
+		So(<-matched, ShouldBeNil)
+		So(finalizer.orig, ShouldEqual, "a.torrent")
+		So(finalizer.outFile, ShouldEqual, "Unrelated Name.avi")
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test status answers while a payload is being finalized", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "a.torrent", OrigPath: "test/watch/movies/a.torrent"})
+		watcher.track(state.Record{Name: "b.torrent", OrigPath: "test/watch/movies/b.torrent"})
+		ioutil.WriteFile("test/complete/First.avi", nil, os.ModePerm)
+		ioutil.WriteFile("test/complete/Second.avi", nil, os.ModePerm)
+
+		// stands in for ProcessCompletions stuck placing the first payload
+		finalizing := make(chan Finalizer)
+		go func() {
+			finalizing <- <-watcher.DoneFiles
+		}()
+		watcher.run(watcher.WatchForCompletion)
+		defer watcher.Close()
+
+		So(watcher.ForceMatch("a.torrent", "First.avi"), ShouldBeNil)
+		So(watcher.ForceMatch("b.torrent", "Second.avi"), ShouldBeNil)
+		So((<-finalizing).orig, ShouldEqual, "a.torrent")
+
+		start := time.Now()
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(status.ActiveFiles, ShouldBeEmpty)
+		So(status.IgnoreFiles, ShouldResemble, []string{"First.avi", "Second.avi"})
+
+		finalizer := <-watcher.DoneFiles
+		So(finalizer.orig, ShouldEqual, "b.torrent")
+	})
+
+	Convey("Test completing a payload by infohash and path", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "It.torrent", OrigPath: "test/watch/movies/It.torrent", InfoHash: "abcdef", Status: state.StatusConsumed})
+		os.MkdirAll("test/complete/It (2017)/Subs", os.ModePerm)
+
+		watcher.run(watcher.WatchForCompletion)
+
+		So(watcher.CompletePayload("abcdef", "test/complete"), ShouldNotBeNil)
+		So(watcher.CompletePayload("abcdef", "test/media/It (2017)"), ShouldNotBeNil)
+		So(watcher.CompletePayload("Up.torrent", "test/complete/It (2017)"), ShouldNotBeNil)
+
+		matched := make(chan error)
+		go func() {
+			matched <- watcher.CompletePayload("ABCDEF", "test/complete/It (2017)/Subs")
+		}()
+		finalizer := <-watcher.DoneFiles
+		So(<-matched, ShouldBeNil)
+		So(finalizer.orig, ShouldEqual, "It.torrent")
+		So(finalizer.outFile, ShouldEqual, "It (2017)")
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test requests time out without a completion watcher", t, func() {
+		defer func(timeout time.Duration) { requestTimeout = timeout }(requestTimeout)
+		requestTimeout = time.Millisecond * 10
+
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		_, err := watcher.Status()
+		So(err, ShouldEqual, errNotResponding)
+	})
+}
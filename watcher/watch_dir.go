@@ -1,18 +1,32 @@
 package watcher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	"github.com/MondayHopscotch/SuperScope/client"
+	"github.com/MondayHopscotch/SuperScope/config"
+	"github.com/MondayHopscotch/SuperScope/hooks"
+	"github.com/MondayHopscotch/SuperScope/library"
+	"github.com/MondayHopscotch/SuperScope/magnet"
+	"github.com/MondayHopscotch/SuperScope/match"
+	"github.com/MondayHopscotch/SuperScope/metrics"
+	"github.com/MondayHopscotch/SuperScope/routing"
+	"github.com/MondayHopscotch/SuperScope/settle"
+	"github.com/MondayHopscotch/SuperScope/state"
+	"github.com/MondayHopscotch/SuperScope/torrent"
 	"github.com/MondayHopscotch/SuperScope/util"
 	"github.com/fsnotify/fsnotify"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,28 +40,65 @@ type SimpleWatcher struct {
 	dropOffDir   string
 	completedDir string
 	mediaDir     string
+	stagingDir   string
 	watcher      *fsnotify.Watcher
-	WatchedDirs  map[string]bool
-	ActiveFiles  map[string]string
+	compWatch    *fsnotify.Watcher
+	settling     *settle.Detector
+	index        *match.Index
+	// WatchedDirs, ActiveFiles, Ambiguous and IgnoreFiles belong to the WatchForCompletion goroutine once it's
+	// running. Anything else changes them by sending it requests
+	WatchedDirs map[string]bool
+	ActiveFiles map[string]state.Record
+	// Ambiguous holds the candidates for active files whose payload was too close to call
+	Ambiguous map[string][]match.Result
+	// finalizing are completions waiting for ProcessCompletions, oldest first. Queueing them here, also owned by
+	// WatchForCompletion, means it never waits on a payload being finalized
+	finalizing []Finalizer
+	// rankings are what the candidates for each active file last scored, also owned by WatchForCompletion
+	rankings map[string]ranking
+	// clientTorrents is what the torrent client last said about the torrents it was asked about, by infohash, nil
+	// for those it doesn't have. clientDown is set when it couldn't be asked. Both are owned by WatchForCompletion
+	clientTorrents map[string]*client.Torrent
+	clientDown     bool
+	// askingClient is set while the torrent client is being asked about the active files
+	askingClient bool
 
-	IgnoreFiles []string
+	// Store persists ActiveFiles so tracking survives a restart. Defaults to an in-memory store
+	Store *state.Store
 
-	EventsDone          chan bool
-	WatcherDone         chan bool
-	FinalizerDone       chan bool
-	FilesDone           chan bool
-	CompleteWatcherDone chan bool
+	// config holds the tunables, which can be swapped out by Reload while the watcher is running
+	configLock sync.RWMutex
+	config     config.Config
+	router     *routing.Router
+	hooks      *hooks.Dispatcher
+	libraries  []library.Notifier
+	client     client.Client
+
+	// IgnoreFiles are entries in the completed dir that are never a payload, like ones already finalized
+	IgnoreFiles map[string]bool
+
+	// ctx is cancelled by Close, telling every goroutine to stop once whatever it's in the middle of is safe
+	ctx    context.Context
+	cancel context.CancelFunc
+	// running counts the goroutines Close waits for, including torrents being consumed and payloads being placed
+	running sync.WaitGroup
 
 	Adds      chan string
 	Removes   chan string
 	Files     chan string
 	DoneFiles chan Finalizer
+
+	// requests are run by the WatchForCompletion goroutine, which owns the watcher's state
+	requests chan func()
+	// compChanges are the names of entries in the completed dir that just changed
+	compChanges chan string
 }
 
 type Finalizer struct {
 	orig     string
 	origPath string
 	outFile  string
+	info     *torrent.Info
 }
 
 func NewWatcher(root string, dropOff string, completed string, media string) Watcher {
@@ -55,26 +106,73 @@ func NewWatcher(root string, dropOff string, completed string, media string) Wat
 }
 
 func NewSimpleWatcher(root string, dropOff string, completed string, media string) *SimpleWatcher {
+	cfg := config.Default()
+	cfg.RootDir = root
+	cfg.DropDir = dropOff
+	cfg.CompletedDir = completed
+	cfg.MediaDir = media
+	return NewSimpleWatcherFromConfig(cfg)
+}
+
+// defaultStagingDir is where archives are extracted, inside the completed dir, when the config doesn't say
+const defaultStagingDir = ".superscope-staging"
+
+func NewSimpleWatcherFromConfig(cfg config.Config) *SimpleWatcher {
+	router, err := cfg.Router()
+	if err != nil {
+		log.Println("Invalid categories, every payload will be placed whole: ", err)
+		router, _ = routing.NewRouter(nil)
+	}
+	dispatcher, err := cfg.Dispatcher()
+	if err != nil {
+		log.Println("Invalid hooks, none will be run: ", err)
+		dispatcher = nil
+	}
+	notifiers, err := cfg.Notifiers()
+	if err != nil {
+		log.Println("Invalid libraries, none will be refreshed: ", err)
+	}
+	torrentClient, err := cfg.TorrentClient()
+	if err != nil {
+		log.Println("Invalid torrent client, using the drop dir instead: ", err)
+	}
+
+	stagingDir := cfg.StagingDir
+	if stagingDir == "" {
+		stagingDir = filepath.Join(cfg.CompletedDir, defaultStagingDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SimpleWatcher{
-		rootDir:      root,
-		dropOffDir:   dropOff,
-		completedDir: completed,
-		mediaDir:     media,
-
-		EventsDone:          make(chan bool),
-		WatcherDone:         make(chan bool),
-		FinalizerDone:       make(chan bool),
-		FilesDone:           make(chan bool),
-		CompleteWatcherDone: make(chan bool),
-		Adds:                make(chan string, 10),
-		Removes:             make(chan string, 10),
-		Files:               make(chan string, 10),
-		DoneFiles:           make(chan Finalizer, 0),
+		rootDir:      cfg.RootDir,
+		dropOffDir:   cfg.DropDir,
+		completedDir: cfg.CompletedDir,
+		mediaDir:     cfg.MediaDir,
+		stagingDir:   stagingDir,
+		settling:     settle.NewDetector(),
+		index:        match.NewIndex(),
+		config:       cfg,
+		router:       router,
+		hooks:        dispatcher,
+		libraries:    notifiers,
+		client:       torrentClient,
+
+		ctx:         ctx,
+		cancel:      cancel,
+		Adds:        make(chan string, 10),
+		Removes:     make(chan string, 10),
+		Files:       make(chan string, 10),
+		DoneFiles:   make(chan Finalizer, 0),
+		requests:    make(chan func()),
+		compChanges: make(chan string, 64),
 
 		WatchedDirs: make(map[string]bool, 0),
-		ActiveFiles: make(map[string]string, 0),
+		ActiveFiles: make(map[string]state.Record, 0),
+		Ambiguous:   make(map[string][]match.Result, 0),
 
-		IgnoreFiles: make([]string, 0),
+		Store: state.NewMemoryStore(),
+
+		IgnoreFiles: make(map[string]bool, 0),
 	}
 }
 
@@ -98,19 +196,26 @@ func (w *SimpleWatcher) Watch() {
 
 	log.Println("Finished scan. Adding directories to watcher")
 
-	existingFiles, err := util.GetExistingFiles(w.completedDir)
+	resumed := w.resumeTracking()
+
+	log.Println("Scanning completed directory for pre-existing files")
+
+	err = w.rescan()
 	if err != nil {
 		log.Fatal("Unable to determine existing completed files: ", err)
 		return
 	}
+	matched := w.activeMatches()
+	for _, existing := range w.index.Names() {
+		// anything that finished while we were down still needs to be matched, so don't ignore it
+		if matched[existing] {
+			log.Println("Not ignoring ", existing, ": it may belong to a resumed torrent")
+			continue
+		}
+		w.ignore(existing)
+	}
 
-	log.Println("Adding files to ignore list: ", existingFiles)
-
-	w.IgnoreFiles = append(w.IgnoreFiles, existingFiles...)
-
-	log.Println("Scanning completed directory for pre-existing files")
-
-	log.Println("Finished scanning completed directory")
+	log.Println("Finished scanning completed directory. Ignoring ", len(w.IgnoreFiles), " files")
 
 	for _, dir := range startingDirs {
 		log.Println("Adding ", dir, " as root directory to watch")
@@ -119,6 +224,7 @@ func (w *SimpleWatcher) Watch() {
 			log.Fatal("Failed to add root dir: ", err)
 		}
 		w.WatchedDirs[dir] = true
+		metrics.WatchedDirs.Inc()
 	}
 
 	if err != nil {
@@ -127,24 +233,195 @@ func (w *SimpleWatcher) Watch() {
 
 	log.Println("Directories added. Starting watcher")
 
-	go w.handleEvents()
+	err = w.watchCompleted()
+	if err != nil {
+		log.Println("Unable to watch the completed dir for changes, polling it instead: ", err)
+	}
+
+	w.run(w.handleEvents)
+
+	w.run(w.handleFSWatcher)
+
+	w.run(w.handleFilesFound)
+
+	w.finalizing = append(w.finalizing, resumed...)
+
+	w.run(w.WatchForCompletion)
+
+	w.run(w.ProcessCompletions)
+}
+
+// run starts f on its own goroutine, which Close waits for. f must return soon after w.ctx is done
+func (w *SimpleWatcher) run(f func()) {
+	w.running.Add(1)
+	go func() {
+		defer w.running.Done()
+		f()
+	}()
+}
+
+// resumeTracking loads pending records from the Store. Consumed records go back into ActiveFiles, and
+// records that were matched but never linked are returned so they can be finalized again
+func (w *SimpleWatcher) resumeTracking() []Finalizer {
+	resumed := make([]Finalizer, 0)
+	for _, record := range w.Store.Pending() {
+		switch record.Status {
+		case state.StatusConsumed:
+			log.Println("Resuming tracking of ", record.Name)
+			w.ActiveFiles[record.Name] = record
+			metrics.ActiveTracked.Inc()
+		case state.StatusCompleted:
+			log.Println("Resuming finalization of ", record.Name, ": ", record.Completed)
+			resumed = append(resumed, Finalizer{orig: record.Name, origPath: record.OrigPath, outFile: record.Completed, info: record.Info})
+			w.ignore(record.Completed)
+		}
+	}
+	return resumed
+}
+
+// Settings returns a copy of the watcher's current config
+func (w *SimpleWatcher) Settings() config.Config {
+	w.configLock.RLock()
+	defer w.configLock.RUnlock()
+	return w.config
+}
+
+// route finds the category for a torrent from its original path
+func (w *SimpleWatcher) route(origPath string) *routing.Route {
+	w.configLock.RLock()
+	defer w.configLock.RUnlock()
+	return w.router.Route(w.relativeToRoot(origPath))
+}
+
+// fire runs the hooks for event with the details of record
+func (w *SimpleWatcher) fire(event hooks.Event, record state.Record, category string, destination string, err error) {
+	w.configLock.RLock()
+	dispatcher := w.hooks
+	w.configLock.RUnlock()
+
+	payload := hooks.Payload{
+		Event:       event,
+		Name:        record.Name,
+		OrigPath:    record.OrigPath,
+		InfoHash:    record.InfoHash,
+		DisplayName: record.DisplayName,
+		Completed:   record.Completed,
+		Category:    category,
+		Destination: destination,
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	dispatcher.Fire(payload)
+}
+
+// refreshLibraries asks every media server to rescan dir
+func (w *SimpleWatcher) refreshLibraries(dir string) {
+	w.configLock.RLock()
+	notifiers := w.libraries
+	w.configLock.RUnlock()
+
+	library.RefreshAll(notifiers, dir)
+}
+
+// relativeToRoot returns file's path relative to the watch root, or just its base name if it isn't under the root
+func (w *SimpleWatcher) relativeToRoot(file string) string {
+	rel, err := filepath.Rel(w.rootDir, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(file)
+	}
+	return filepath.ToSlash(rel)
+}
 
-	go w.handleFSWatcher()
+// Reload swaps in new tunables without restarting anything. The directories, state, staging, HTTP address and
+// socket can't be changed while running, so any change to them is reported and ignored
+func (w *SimpleWatcher) Reload(cfg config.Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+	router, err := cfg.Router()
+	if err != nil {
+		return err
+	}
+	dispatcher, err := cfg.Dispatcher()
+	if err != nil {
+		return err
+	}
+	notifiers, err := cfg.Notifiers()
+	if err != nil {
+		return err
+	}
+	torrentClient, err := cfg.TorrentClient()
+	if err != nil {
+		return err
+	}
 
-	go w.handleFilesFound()
+	if cfg.RootDir != w.rootDir || cfg.DropDir != w.dropOffDir || cfg.CompletedDir != w.completedDir || cfg.MediaDir != w.mediaDir {
+		log.Println("Directory changes require a restart, keeping the current directories")
+	}
+	cfg.RootDir = w.rootDir
+	cfg.DropDir = w.dropOffDir
+	cfg.CompletedDir = w.completedDir
+	cfg.MediaDir = w.mediaDir
 
-	go w.WatchForCompletion()
+	w.configLock.Lock()
+	current := w.config
+	if cfg.StateDir != current.StateDir || cfg.StagingDir != current.StagingDir || cfg.HTTPAddr != current.HTTPAddr || cfg.Socket != current.Socket {
+		log.Println("State, staging, HTTP address and socket changes require a restart, keeping the current ones")
+	}
+	cfg.StateDir = current.StateDir
+	cfg.StagingDir = current.StagingDir
+	cfg.HTTPAddr = current.HTTPAddr
+	cfg.Socket = current.Socket
+	w.config = cfg
+	w.router = router
+	w.hooks = dispatcher
+	w.libraries = notifiers
+	w.client = torrentClient
+	w.configLock.Unlock()
+	w.Store.SetRetention(time.Duration(cfg.History))
 
-	go w.ProcessCompletions()
+	log.Println("Config reloaded")
+	return nil
 }
 
+// Close stops the watcher, waiting up to the shutdown timeout for torrents being consumed and payloads being placed.
+// Anything still pending is left in the Store to be picked up on the next start. Every problem shutting down is
+// reported in the returned error
 func (w *SimpleWatcher) Close() error {
-	w.watcher.Close()
-	w.EventsDone <- true
-	w.WatcherDone <- true
-	w.FinalizerDone <- true
-	w.FilesDone <- true
-	w.CompleteWatcherDone <- true
+	problems := make([]string, 0)
+	w.cancel()
+	if w.watcher != nil {
+		if err := w.watcher.Close(); err != nil {
+			problems = append(problems, fmt.Sprintf("unable to stop watching the watch dir: %v", err))
+		}
+	}
+	if w.compWatch != nil {
+		if err := w.compWatch.Close(); err != nil {
+			problems = append(problems, fmt.Sprintf("unable to stop watching the completed dir: %v", err))
+		}
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		w.running.Wait()
+		w.hooks.Wait()
+		close(stopped)
+	}()
+	timeout := time.Duration(w.Settings().ShutdownTimeout)
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		problems = append(problems, fmt.Sprintf("gave up waiting for work in flight after %v", timeout))
+	}
+
+	if err := w.Store.Save(); err != nil {
+		problems = append(problems, fmt.Sprintf("unable to save tracking state: %v", err))
+	}
+	if len(problems) > 0 {
+		return errors.New("unclean shutdown: " + strings.Join(problems, "; "))
+	}
 	return nil
 }
 
@@ -198,30 +475,55 @@ func (w *SimpleWatcher) handleFSWatcher() {
 				log.Println("Error adding watch dir ", newWatch, err)
 				continue
 			}
+			metrics.WatchedDirs.Inc()
+			if !w.request(func() { w.WatchedDirs[newWatch] = true }) {
+				return
+			}
 		case oldWatch := <-w.Removes:
 			err := w.watcher.Remove(oldWatch)
 			if err != nil {
 				log.Println("Error removing watch dir ", oldWatch, err)
 				continue
 			}
-		case <-w.WatcherDone:
+			metrics.WatchedDirs.Dec()
+			if !w.request(func() { delete(w.WatchedDirs, oldWatch) }) {
+				return
+			}
+		case <-w.ctx.Done():
 			return
 		}
 	}
 }
 
+// request hands change to the WatchForCompletion goroutine without waiting for it to run. It returns false if the
+// watcher is closed first, in which case the caller should stop
+func (w *SimpleWatcher) request(change func()) bool {
+	select {
+	case w.requests <- change:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
 func (w *SimpleWatcher) handleEvents() {
-	handleEventsForChans(w.EventsDone, w.watcher.Events, w.Adds, w.Removes, w.Files)
+	isIgnored := func(name string) bool {
+		return util.HasIgnoredPrefix(name, w.Settings().IgnorePrefixes)
+	}
+	handleEventsForChans(w.ctx, w.watcher.Events, w.Adds, w.Removes, w.Files, isIgnored)
 }
 
-func handleEventsForChans(done chan bool, eventIn <-chan fsnotify.Event, adds chan<- string, removes chan<- string, files chan<- string) {
+func handleEventsForChans(ctx context.Context, eventIn <-chan fsnotify.Event, adds chan<- string, removes chan<- string, files chan<- string, isIgnored func(string) bool) {
 	log.Println("Event handler starting up")
 	for {
 		select {
-		case event := <-eventIn:
+		case event, ok := <-eventIn:
+			if !ok {
+				return
+			}
 			log.Println("\tevent:", event)
 			if event.Op&fsnotify.Create == fsnotify.Create {
-				if util.IsNewFile(event.Name) {
+				if isIgnored(event.Name) {
 					continue
 				}
 				stat, err := os.Stat(event.Name)
@@ -232,27 +534,49 @@ func handleEventsForChans(done chan bool, eventIn <-chan fsnotify.Event, adds ch
 
 				if stat.IsDir() {
 					log.Println("Need new watcher for ", event.Name)
-					adds <- event.Name
+					forward(ctx, adds, event.Name)
 				} else {
-					if util.IsTorrent(event.Name) {
+					if util.IsTorrent(event.Name) || util.IsMagnet(event.Name) {
 						log.Println("New file for consumption ", event.Name)
-						files <- event.Name
+						metrics.TorrentsSeen.Inc()
+						forward(ctx, files, event.Name)
 					}
 				}
 			}
-		case <-done:
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// wait sleeps for delay before something is retried, returning false if the watcher is closed first
+func (w *SimpleWatcher) wait(delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// forward sends name on out, unless ctx is done first
+func forward(ctx context.Context, out chan<- string, name string) {
+	select {
+	case out <- name:
+	case <-ctx.Done():
+	}
+}
+
 func (w *SimpleWatcher) handleFilesFound() {
 	log.Println("File consumer starting up")
 	for {
 		select {
 		case file := <-w.Files:
-			go w.consumeFileWithTimeout(file, time.Minute*30)
-		case <-w.FilesDone:
+			timeout := time.Duration(w.Settings().ConsumeTimeout)
+			w.run(func() {
+				w.consumeFileWithTimeout(file, timeout)
+			})
+		case <-w.ctx.Done():
 			return
 		}
 	}
@@ -263,117 +587,635 @@ func (w *SimpleWatcher) consumeFileWithTimeout(file string, timeout time.Duratio
 	file = backSlash.ReplaceAllString(file, "/")
 	base := filepath.Base(file)
 	log.Println("Consuming file: ", base)
-	err := util.MoveFileWithTimeout(file, path.Join(w.dropOffDir, base), timeout)
+	start := time.Now()
+
+	if torrentClient := w.torrentClient(); torrentClient != nil {
+		w.addToClientWithTimeout(torrentClient, file, timeout, start)
+		return
+	}
+
+	if util.IsMagnet(file) {
+		w.consumeMagnetWithTimeout(file, timeout)
+		return
+	}
+
+	// read the metadata now, as the client is free to delete the torrent once it's in the drop dir
+	data, err := ioutil.ReadFile(file)
+	var meta *torrent.MetaInfo
+	if err == nil {
+		meta, err = torrent.Parse(data)
+	}
 	if err != nil {
+		log.Println("Unable to read torrent metadata for ", base, ", falling back to name matching: ", err)
+	}
+
+	attempts, err := util.MoveFileWithContext(w.ctx, file, path.Join(w.dropOffDir, base), timeout)
+	if attempts > 1 {
+		metrics.ConsumeRetries.Add(float64(attempts - 1))
+	}
+	if err == context.Canceled {
+		log.Println("Stopped consuming ", file, " to shut down, it was left where it is")
+	} else if err != nil {
 		log.Println("Failed to consume file before timeout reached for: ", file)
+		metrics.ConsumeFailures.Inc()
 	} else {
-		w.ActiveFiles[base] = file
+		record := state.Record{Name: base, OrigPath: file, Status: state.StatusConsumed, ConsumedAt: time.Now()}
+		if meta != nil {
+			record.InfoHash = meta.InfoHash
+			record.Info = &meta.Info
+			w.keepTorrent(base, data)
+		}
+		w.startTracking(record)
+		w.fire(hooks.EventConsumed, record, "", "", nil)
+		metrics.TorrentsConsumed.Inc()
+		metrics.ConsumeDuration.Observe(time.Since(start).Seconds())
 		log.Println("Finished consuming: ", base)
 	}
 }
 
+// consumeMagnetWithTimeout reads a magnet link from file, writes it to the drop dir in the client's preferred
+// format and removes the original. The file may still be being written, so reading is retried until timeout
+func (w *SimpleWatcher) consumeMagnetWithTimeout(file string, timeout time.Duration) {
+	base := filepath.Base(file)
+
+	var link *magnet.Link
+	var err error
+	start := time.Now()
+	for time.Since(start) < timeout {
+		link, err = magnet.ParseFile(file)
+		if err == nil || err == magnet.ErrNoMagnet {
+			break
+		}
+		metrics.ConsumeRetries.Inc()
+		if !w.wait(time.Second * 5) {
+			log.Println("Stopped consuming ", file, " to shut down, it was left where it is")
+			return
+		}
+	}
+	if err != nil {
+		log.Println("Failed to read magnet link before timeout reached for: ", file, ": ", err)
+		metrics.ConsumeFailures.Inc()
+		return
+	}
+
+	dropName := util.RemoveExtension(base)
+	var data []byte
+	if w.Settings().MagnetFormat == config.MagnetFormatTorrent {
+		dropName += ".torrent"
+		data, err = bencode.Encode(map[string]interface{}{"magnet-uri": link.URI})
+		if err != nil {
+			log.Println("Failed to encode magnet link for ", base, ": ", err)
+			metrics.ConsumeFailures.Inc()
+			return
+		}
+	} else {
+		dropName += ".magnet"
+		data = []byte(link.URI + "\n")
+	}
+
+	// write under a temporary name so the client never sees a partial file
+	tmpFile := path.Join(w.dropOffDir, dropName+".tmp")
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, path.Join(w.dropOffDir, dropName))
+	}
+	if err != nil {
+		log.Println("Failed to drop off magnet link for ", base, ": ", err)
+		metrics.ConsumeFailures.Inc()
+		return
+	}
+
+	err = os.Remove(file)
+	if err != nil {
+		log.Println("Unable to remove consumed magnet file ", file, ": ", err)
+	}
+
+	record := state.Record{
+		Name:        base,
+		OrigPath:    file,
+		Status:      state.StatusConsumed,
+		InfoHash:    link.InfoHash,
+		DisplayName: link.DisplayName,
+		ConsumedAt:  time.Now(),
+	}
+	w.startTracking(record)
+	w.fire(hooks.EventConsumed, record, "", "", nil)
+	metrics.TorrentsConsumed.Inc()
+	metrics.ConsumeDuration.Observe(time.Since(start).Seconds())
+	log.Println("Finished consuming magnet: ", base, " (", link.InfoHash, ")")
+}
+
+// keepTorrent saves a copy of a consumed .torrent to verify its payload against after a restart, if verifying is on
+func (w *SimpleWatcher) keepTorrent(name string, data []byte) {
+	if !w.Settings().VerifyPieces {
+		return
+	}
+	err := w.Store.SaveTorrent(name, data)
+	if err != nil {
+		log.Println("Unable to keep a copy of ", name, " to verify its payload against: ", err)
+	}
+}
+
+// startTracking hands record, just consumed on another goroutine, to the WatchForCompletion goroutine to track
+func (w *SimpleWatcher) startTracking(record state.Record) {
+	if w.request(func() { w.track(record) }) {
+		return
+	}
+	// the torrent has already been consumed, so make sure it's tracked on the next start
+	err := w.Store.Put(record)
+	if err != nil {
+		log.Println("Failed to save tracking state for ", record.Name, ": ", err)
+	}
+}
+
+// track starts watching for the completion of record and saves it to the Store. Only the WatchForCompletion
+// goroutine, or Watch before starting it, may call it
+func (w *SimpleWatcher) track(record state.Record) {
+	if _, ok := w.ActiveFiles[record.Name]; !ok {
+		metrics.ActiveTracked.Inc()
+	}
+	w.ActiveFiles[record.Name] = record
+	err := w.Store.Put(record)
+	if err != nil {
+		log.Println("Failed to save tracking state for ", record.Name, ": ", err)
+	}
+}
+
+// activeMatches lists the entries in the completed dir that match any file we are tracking
+func (w *SimpleWatcher) activeMatches() map[string]bool {
+	matched := make(map[string]bool, 0)
+	threshold := w.Settings().MatchThreshold
+	for _, record := range w.ActiveFiles {
+		for _, candidate := range w.index.Search(searchNames(record)...) {
+			if score, _ := w.score(record, candidate); score >= threshold {
+				matched[candidate.Name] = true
+			}
+		}
+	}
+	return matched
+}
+
+// searchNames are the names record's payload could have, which are all score looks at
+func searchNames(record state.Record) []string {
+	if record.Info != nil {
+		return []string{record.Info.Name}
+	}
+	if util.IsMagnet(record.Name) {
+		return []string{record.DisplayName, record.InfoHash}
+	}
+	return []string{util.RemoveExtension(record.Name)}
+}
+
+// score rates how likely candidate in the completed dir is the payload for record, from 0 to 1, and whether it's
+// certain. When we have the torrent's metadata a payload with the right name and file set is certain, and one with
+// the right name but anything else wrong is still downloading. Magnets are certain on their display name or
+// infohash. Everything else is scored on its name, and on its size when we know what to expect
+func (w *SimpleWatcher) score(record state.Record, candidate match.Candidate) (float64, bool) {
+	// scores under the threshold are never picked, so don't spend time working them out
+	threshold := w.Settings().MatchThreshold
+	if record.Info != nil {
+		payload := path.Join(w.completedDir, candidate.Name)
+		err := record.Info.MatchesPayload(payload)
+		if err == nil {
+			return 1, true
+		}
+		if record.Info.Name == candidate.Name {
+			log.Println("Payload ", candidate.Name, " is not complete yet: ", err)
+			return 0, false
+		}
+
+		// the client may have renamed the payload, so fall back to its name and check the size is plausible
+		score := match.ScoreAbove(record.Info.Name, candidate, 0, threshold)
+		if score < threshold {
+			return score, false
+		}
+		candidate.Size = payloadSize(payload)
+		return match.Score(record.Info.Name, candidate, record.Info.TotalLength()), false
+	}
+	if util.IsMagnet(record.Name) {
+		if candidate.Name == record.DisplayName || strings.EqualFold(candidate.Name, record.InfoHash) {
+			return 1, true
+		}
+		if record.DisplayName == "" {
+			return 0, false
+		}
+		return match.ScoreAbove(record.DisplayName, candidate, 0, threshold), false
+	}
+	return match.ScoreAbove(util.RemoveExtension(record.Name), candidate, 0, threshold), false
+}
+
+// payloadSize is the total size of the file or directory at payload, or 0 if it can't be read
+func payloadSize(payload string) int64 {
+	stat, err := os.Stat(payload)
+	if err != nil {
+		return 0
+	}
+	if !stat.IsDir() {
+		return stat.Size()
+	}
+	files, err := payloadFiles(payload)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, size := range files {
+		total += size
+	}
+	return total
+}
+
+// changeDelay is how long after a change in the completed dir it's checked, so a burst of changes is checked once
+var changeDelay = time.Millisecond * 500
+
 func (w *SimpleWatcher) WatchForCompletion() {
 	log.Println("Completion watcher starting up")
-	nonAlphaNum := regexp.MustCompile("[^a-zA-Z0-9]")
+	poll := time.After(w.pollInterval())
+	// check fires when the completed dir needs checking before the next poll, because something in it changed or a
+	// payload is waiting to settle
+	var check <-chan time.Time
 	for {
+		// only offer the oldest queued completion while there is one
+		var doneFiles chan Finalizer
+		var next Finalizer
+		if len(w.finalizing) > 0 {
+			doneFiles = w.DoneFiles
+			next = w.finalizing[0]
+		}
+
 		select {
-		case <-w.CompleteWatcherDone:
+		case <-w.ctx.Done():
+			if len(w.finalizing) > 0 {
+				log.Println("Shutting down with ", len(w.finalizing), " payloads waiting to be finalized, they will be on the next start")
+			}
 			return
-		default:
-			time.Sleep(5 * time.Second)
-			completedFiles, err := ioutil.ReadDir(w.completedDir)
-			if err != nil {
-				log.Println("Unable to read completedDir: ", err)
+		case doneFiles <- next:
+			w.finalizing = w.finalizing[1:]
+		case request := <-w.requests:
+			request()
+		case compFile := <-w.compChanges:
+			w.updateEntry(compFile)
+			if check == nil && !w.isIgnored(compFile) {
+				check = time.After(changeDelay)
+			}
+		case <-check:
+			check = nil
+			w.matchCompletions()
+		case <-poll:
+			w.checkForCompletions()
+			w.askClient()
+			poll = time.After(w.pollInterval())
+		}
+
+		window := time.Duration(w.Settings().SettleWindow)
+		if check == nil && window > 0 && w.settling.Pending() > 0 {
+			check = time.After(window)
+		}
+	}
+}
+
+// pollInterval is how long until the completed dir is next checked regardless of any changes. While they're being
+// watched that's only to catch what they missed, unless the torrent client needs asking about progress
+func (w *SimpleWatcher) pollInterval() time.Duration {
+	settings := w.Settings()
+	if w.compWatch == nil || w.torrentClient() != nil {
+		return time.Duration(settings.PollInterval)
+	}
+	return time.Duration(settings.RescanInterval)
+}
+
+// watchCompleted watches the completed dir and every directory in it, so payloads are matched as soon as they
+// appear and are known to be changing between polls
+func (w *SimpleWatcher) watchCompleted() error {
+	compWatch, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = compWatch.Add(w.completedDir)
+	if err != nil {
+		compWatch.Close()
+		return err
+	}
+	w.compWatch = compWatch
+	w.watchCompletedTree(w.completedDir)
+	w.run(w.handleCompletedEvents)
+	return nil
+}
+
+// watchCompletedTree watches every directory under dir, except the staging dir
+func (w *SimpleWatcher) watchCompletedTree(dir string) {
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if filepath.Clean(file) == filepath.Clean(w.stagingDir) {
+			return filepath.SkipDir
+		}
+		if file == w.completedDir {
+			return nil
+		}
+		err = w.compWatch.Add(file)
+		if err != nil {
+			log.Println("Unable to watch ", file, " for changes: ", err)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (w *SimpleWatcher) handleCompletedEvents() {
+	log.Println("Completed dir event handler starting up")
+	for {
+		select {
+		case event, ok := <-w.compWatch.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
 				continue
 			}
-			pendingRemoves := make([]string, 0)
-			for activeFile, fullPath := range w.ActiveFiles {
-				withoutExt := activeFile[0 : len(activeFile)-len(filepath.Ext(activeFile))]
-				fileTokens := nonAlphaNum.Split(strings.ToLower(withoutExt), -1)
-				log.Println("File Tokens (", len(fileTokens), ": ", fileTokens)
-				for _, compFile := range completedFiles {
-					if util.DoTokensMatch([]string{compFile.Name()}, w.IgnoreFiles) {
-						continue
-					}
-					compTokens := nonAlphaNum.Split(strings.ToLower(compFile.Name()), -1)
-					log.Println("Compare Tokens: ", compTokens)
-					if util.DoTokensMatch(fileTokens, compTokens) {
-						log.Println("Found completed match for ", activeFile, ": ", compFile.Name())
-						pendingRemoves = append(pendingRemoves, activeFile)
-						w.DoneFiles <- Finalizer{orig: activeFile, origPath: fullPath, outFile: compFile.Name()}
-						log.Println("Adding file to ignore list: ", compFile.Name())
-						w.IgnoreFiles = append(w.IgnoreFiles, compFile.Name())
-						break
-					}
+			compFile := w.completedEntry(event.Name)
+			if compFile == "" || w.isStagingDir(compFile) {
+				continue
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+					w.watchCompletedTree(event.Name)
 				}
 			}
-			for _, remove := range pendingRemoves {
-				delete(w.ActiveFiles, remove)
+			w.settling.Touch(compFile)
+			select {
+			case w.compChanges <- compFile:
+			default:
+				// the rescan will pick it up
+			}
+		case err, ok := <-w.compWatch.Errors:
+			if !ok {
+				return
 			}
+			log.Println("Error watching the completed dir: ", err)
 		}
 	}
 }
 
+// completedEntry is the name of the entry in the completed dir that file is in, or "" if it isn't in it
+func (w *SimpleWatcher) completedEntry(file string) string {
+	rel, err := filepath.Rel(w.completedDir, file)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+}
+
+// isIgnored returns true if compFile, an entry in the completed dir, is never a payload for an active file
+func (w *SimpleWatcher) isIgnored(compFile string) bool {
+	return w.IgnoreFiles[compFile] || w.isStagingDir(compFile)
+}
+
+// ignore stops compFile being considered as the payload of anything
+func (w *SimpleWatcher) ignore(compFile string) {
+	w.IgnoreFiles[compFile] = true
+	w.index.Remove(compFile)
+}
+
+// updateEntry brings the index and ignore list up to date after compFile, an entry in the completed dir, changed
+func (w *SimpleWatcher) updateEntry(compFile string) {
+	stat, err := os.Stat(filepath.Join(w.completedDir, compFile))
+	if os.IsNotExist(err) {
+		delete(w.IgnoreFiles, compFile)
+		w.index.Remove(compFile)
+		return
+	}
+	if err == nil && !w.isIgnored(compFile) {
+		w.index.Add(match.Candidate{Name: compFile, Dir: stat.IsDir()})
+	}
+}
+
+// rescan brings the index and ignore list in line with everything in the completed dir, in case a change was missed
+func (w *SimpleWatcher) rescan() error {
+	completedFiles, err := ioutil.ReadDir(w.completedDir)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(completedFiles))
+	for _, compFile := range completedFiles {
+		present[compFile.Name()] = true
+		if !w.isIgnored(compFile.Name()) {
+			w.index.Add(match.Candidate{Name: compFile.Name(), Dir: compFile.IsDir()})
+		}
+	}
+	for compFile := range w.IgnoreFiles {
+		if !present[compFile] {
+			delete(w.IgnoreFiles, compFile)
+		}
+	}
+	for _, compFile := range w.index.Names() {
+		if !present[compFile] {
+			w.index.Remove(compFile)
+		}
+	}
+	w.settling.Retain(present)
+	return nil
+}
+
+// isStagingDir returns true if compFile, an entry in the completed dir, is where archives are extracted to, which
+// must never be mistaken for a payload
+func (w *SimpleWatcher) isStagingDir(compFile string) bool {
+	return filepath.Clean(filepath.Join(w.completedDir, compFile)) == filepath.Clean(w.stagingDir)
+}
+
+// checkForCompletions rescans the completed dir and looks for the payloads of active files in it
+func (w *SimpleWatcher) checkForCompletions() {
+	err := w.rescan()
+	if err != nil {
+		log.Println("Unable to read completedDir: ", err)
+		return
+	}
+	w.matchCompletions()
+}
+
+// matchCompletions looks for the payloads of active files among the entries in the index, completing those found
+func (w *SimpleWatcher) matchCompletions() {
+	type pick struct {
+		record state.Record
+		best   match.Result
+	}
+	picks := make([]pick, 0)
+	// only the rankings of files still active are kept for the next check
+	rankings := make(map[string]ranking, len(w.ActiveFiles))
+	defer func() {
+		w.rankings = rankings
+	}()
+	matcher := w.Settings().Matcher()
+	torrentClient := w.torrentClient()
+	for activeFile, record := range w.ActiveFiles {
+		if torrentClient != nil && w.checkClient(torrentClient, record) {
+			continue
+		}
+
+		ranked := w.rank(activeFile, record, rankings)
+		best, ambiguous := matcher.Pick(ranked)
+		if len(ambiguous) > 0 {
+			w.reportAmbiguous(activeFile, ambiguous)
+			continue
+		}
+		delete(w.Ambiguous, activeFile)
+		if best != nil {
+			picks = append(picks, pick{record: record, best: *best})
+		}
+	}
+
+	// when two torrents want the same entry, the more confident match wins and the other waits for something else
+	sort.Slice(picks, func(i, j int) bool {
+		if picks[i].best.Certain != picks[j].best.Certain {
+			return picks[i].best.Certain
+		}
+		return picks[i].best.Score > picks[j].best.Score
+	})
+	claimed := make(map[string]bool, len(picks))
+	for _, p := range picks {
+		if claimed[p.best.Name] {
+			log.Println("Completed entry ", p.best.Name, " is a better match for another torrent than ", p.record.Name)
+			continue
+		}
+		claimed[p.best.Name] = true
+		if !w.settled(p.best.Name) {
+			continue
+		}
+		log.Println("Found completed match for ", p.record.Name, ": ", p.best.Name, " (score ", fmt.Sprintf("%.2f", p.best.Score), ")")
+		w.complete(p.record, p.best.Name)
+	}
+}
+
+// ranking is how the candidates for an active file scored, and what that depended on
+type ranking struct {
+	names      []string
+	threshold  float64
+	candidates []match.Candidate
+	ranked     []match.Result
+}
+
+// rank scores the candidates in the index for record, adding the result to rankings. Scoring a torrent by name gives
+// the same result until its candidates change, so the last ranking is reused until then. Torrents with metadata are
+// always scored again, as that checks their payload, which may still be downloading
+func (w *SimpleWatcher) rank(activeFile string, record state.Record, rankings map[string]ranking) []match.Result {
+	names := searchNames(record)
+	candidates := w.index.Search(names...)
+	threshold := w.Settings().MatchThreshold
+	if last, ok := w.rankings[activeFile]; ok && record.Info == nil && last.threshold == threshold &&
+		sameNames(last.names, names) && sameCandidates(last.candidates, candidates) {
+		rankings[activeFile] = last
+		return last.ranked
+	}
+
+	ranked := match.Rank(candidates, func(candidate match.Candidate) (float64, bool) {
+		return w.score(record, candidate)
+	})
+	if record.Info == nil {
+		rankings[activeFile] = ranking{names: names, threshold: threshold, candidates: candidates, ranked: ranked}
+	}
+	return ranked
+}
+
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameCandidates(a []match.Candidate, b []match.Candidate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// settled returns true once compFile has stopped changing, or has kept changing for longer than we're willing to wait
+func (w *SimpleWatcher) settled(compFile string) bool {
+	settings := w.Settings()
+	payload := filepath.Join(w.completedDir, compFile)
+	return w.settling.Check(compFile, payload, time.Duration(settings.SettleWindow), time.Duration(settings.SettleMaxWait)) != settle.Settling
+}
+
+// reportAmbiguous keeps the candidates too close to call for activeFile so they show up in Status, logging them
+// whenever they change
+func (w *SimpleWatcher) reportAmbiguous(activeFile string, ambiguous []match.Result) {
+	names := make([]string, 0, len(ambiguous))
+	for _, result := range ambiguous {
+		names = append(names, fmt.Sprintf("%v (%.2f)", result.Name, result.Score))
+	}
+	previous := make([]string, 0, len(w.Ambiguous[activeFile]))
+	for _, result := range w.Ambiguous[activeFile] {
+		previous = append(previous, fmt.Sprintf("%v (%.2f)", result.Name, result.Score))
+	}
+	if strings.Join(names, ", ") != strings.Join(previous, ", ") {
+		log.Println("Not guessing the payload of ", activeFile, ", candidates are too close: ", strings.Join(names, ", "))
+	}
+	w.Ambiguous[activeFile] = ambiguous
+}
+
+// complete stops tracking record and queues it to be finalized with compFile as its payload
+func (w *SimpleWatcher) complete(record state.Record, compFile string) {
+	delete(w.ActiveFiles, record.Name)
+	delete(w.Ambiguous, record.Name)
+	metrics.ActiveTracked.Dec()
+	if !record.ConsumedAt.IsZero() {
+		metrics.CompletionDuration.Observe(time.Since(record.ConsumedAt).Seconds())
+	}
+	record.Status = state.StatusCompleted
+	record.Completed = compFile
+	err := w.Store.Put(record)
+	if err != nil {
+		log.Println("Failed to save tracking state for ", record.Name, ": ", err)
+	}
+	w.fire(hooks.EventCompleted, record, "", "", nil)
+	w.finalizing = append(w.finalizing, Finalizer{orig: record.Name, origPath: record.OrigPath, outFile: compFile, info: record.Info})
+	log.Println("Adding file to ignore list: ", compFile)
+	w.ignore(compFile)
+}
+
 func (w *SimpleWatcher) ProcessCompletions() {
 	for {
 		select {
 		case doneFile := <-w.DoneFiles:
-			compFileName := doneFile.outFile
-			compFileWithPath := path.Join(w.completedDir, doneFile.outFile)
-
-			// here we need to check if it's a directory and do things appropriately (if it's a dir, then we find the file we care about
-			stat, err := os.Stat(compFileWithPath)
-			if err != nil {
-				log.Println("Error stat'ing ", compFileWithPath, ": ", err)
+			start := time.Now()
+			category, destination, err := w.finalize(doneFile)
+			if err == context.Canceled {
+				log.Println("Stopped finalizing ", doneFile.orig, " to shut down, it will be finished on the next start")
+				continue
 			}
-
-			finalRestingPlace := util.DetermineFinalLocation(w.rootDir, w.mediaDir, doneFile.origPath)
-			log.Println("Ensure directory exists: ", finalRestingPlace)
-			err = os.MkdirAll(finalRestingPlace, os.ModePerm)
-			if err != nil {
-				log.Println("Failed to create parent directories for ", finalRestingPlace, ": ", err)
-				return
+			metrics.FinalizeDuration.Observe(time.Since(start).Seconds())
+			record := state.Record{Name: doneFile.orig, OrigPath: doneFile.origPath, Completed: doneFile.outFile}
+			if stored, ok := w.Store.Get(doneFile.orig); ok {
+				record = stored
 			}
-
-			if stat.IsDir() {
-				if strings.Contains(strings.ToLower(doneFile.origPath), "tv") {
-					// move the whole folder?
-					log.Println("Completed file is TV")
-				} else if strings.Contains(strings.ToLower(doneFile.origPath), "movies") {
-					// move the largest file
-					log.Println("Completed file is Movies")
-					allFiles, err := ioutil.ReadDir(compFileWithPath)
-					if err != nil {
-						log.Println("Unable to read completed file directory ", compFileWithPath, ": ", err)
-					}
-					var largestFile os.FileInfo
-					for _, fileInfo := range allFiles {
-						if largestFile == nil || fileInfo.Size() > largestFile.Size() {
-							largestFile = fileInfo
-						}
-					}
-					originalFileName := path.Base(doneFile.origPath)
-					compFileName = util.RemoveExtension(originalFileName) + path.Ext(largestFile.Name())
-					log.Println("File to move: ", compFileName)
-					compFileWithPath = path.Join(compFileWithPath, largestFile.Name())
-				} else {
-					log.Println("Completed file of unknown category")
-				}
-			}
-
-			if runtime.GOOS == "windows" {
-				err = util.MoveFileWithTimeout(compFileWithPath, path.Join(finalRestingPlace, compFileName), time.Minute*5)
-				if err != nil {
-					log.Println("Failed to move completed file: ", compFileName, ": ", err)
-				}
+			if err != nil {
+				log.Println("Failed to finalize ", doneFile.orig, ": ", err)
+				metrics.Finalizations.WithLabelValues(string(state.StatusFailed)).Inc()
+				w.fire(hooks.EventFailed, record, category, destination, err)
+				err = w.Store.SetStatus(doneFile.orig, state.StatusFailed, err)
 			} else {
-				lnCmd := exec.Command("ln", "-s", compFileWithPath, path.Join(finalRestingPlace, compFileName))
-				err = lnCmd.Run()
-				if err != nil {
-					log.Println("Failed to link completed file: ", compFileName, ": ", err)
-				}
+				metrics.Finalizations.WithLabelValues(string(state.StatusLinked)).Inc()
+				w.fire(hooks.EventLinked, record, category, destination, nil)
+				w.run(func() {
+					w.refreshLibraries(destination)
+				})
+				err = w.Store.SetStatus(doneFile.orig, state.StatusLinked, nil)
+			}
+			if err != nil {
+				log.Println("Failed to save tracking state for ", doneFile.orig, ": ", err)
 			}
-		case <-w.FinalizerDone:
+		case <-w.ctx.Done():
 			return
 		}
 	}
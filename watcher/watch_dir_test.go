@@ -1,10 +1,30 @@
 package watcher
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MondayHopscotch/SuperScope/bencode"
+	"github.com/MondayHopscotch/SuperScope/config"
+	"github.com/MondayHopscotch/SuperScope/hooks"
+	"github.com/MondayHopscotch/SuperScope/library"
+	"github.com/MondayHopscotch/SuperScope/metrics"
+	"github.com/MondayHopscotch/SuperScope/state"
+	"github.com/MondayHopscotch/SuperScope/torrent"
+	"github.com/MondayHopscotch/SuperScope/util"
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDirectoryWatcher(t *testing.T) {
@@ -22,19 +42,92 @@ func TestDirectoryWatcher(t *testing.T) {
 
 	})
 
+	Convey("Test watch resumes tracking from the state store", t, func() {
+		resetTestDir()
+		store, err := state.OpenStore("test/state")
+		So(err, ShouldBeNil)
+		err = store.Put(state.Record{Name: "show.torrent", OrigPath: "test/watch/movies/show.torrent", Status: state.StatusConsumed})
+		So(err, ShouldBeNil)
+
+		completedFile, err := os.Create("test/complete/show.avi")
+		So(err, ShouldBeNil)
+		completedFile.Close()
+		otherFile, err := os.Create("test/complete/other.avi")
+		So(err, ShouldBeNil)
+		otherFile.Close()
+
+		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
+		watcher.Store = store
+		watcher.Watch()
+
+		So(activeFiles(watcher), ShouldContainKey, "show.torrent")
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(status.IgnoreFiles, ShouldContain, "other.avi")
+		So(status.IgnoreFiles, ShouldNotContain, "show.avi")
+
+		go func() {
+			for range watcher.DoneFiles {
+			}
+		}()
+		watcher.Close()
+	})
+
+	Convey("Test reload swaps tunables but keeps directories", t, func() {
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		cfg := testConfig()
+		cfg.RootDir = "elsewhere"
+		cfg.PollInterval = config.Duration(time.Minute)
+		cfg.VerifyPieces = true
+		So(watcher.Reload(cfg), ShouldBeNil)
+
+		settings := watcher.Settings()
+		So(settings.RootDir, ShouldEqual, "test/watch")
+		So(settings.PollInterval, ShouldEqual, config.Duration(time.Minute))
+		So(settings.VerifyPieces, ShouldBeTrue)
+	})
+
+	Convey("Test reload applies a changed history but keeps settings needing a restart", t, func() {
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		cfg := testConfig()
+		cfg.History = config.Duration(time.Hour)
+		cfg.StagingDir = "elsewhere"
+		cfg.HTTPAddr = "localhost:9999"
+		cfg.Socket = "elsewhere.sock"
+		So(watcher.Reload(cfg), ShouldBeNil)
+
+		So(watcher.Store.Retention, ShouldEqual, time.Hour)
+		settings := watcher.Settings()
+		So(settings.History, ShouldEqual, config.Duration(time.Hour))
+		So(settings.StagingDir, ShouldEqual, testConfig().StagingDir)
+		So(settings.HTTPAddr, ShouldEqual, testConfig().HTTPAddr)
+		So(settings.Socket, ShouldEqual, testConfig().Socket)
+	})
+
+	Convey("Test reload rejects invalid config", t, func() {
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+
+		cfg := testConfig()
+		cfg.PollInterval = 0
+		So(watcher.Reload(cfg), ShouldNotBeNil)
+		So(watcher.Settings().PollInterval, ShouldEqual, testConfig().PollInterval)
+	})
+
 	Convey("Handle events recognizes creates", t, func() {
 		resetTestDir()
 		testFile := "test/watch/movies/test.torrent"
 		_, err := os.Create(testFile)
 		So(err, ShouldBeNil)
 
-		done := make(chan bool, 10)
+		ctx, cancel := context.WithCancel(context.Background())
 		eventIn := make(chan fsnotify.Event, 10)
 		adds := make(chan string, 10)
 		files := make(chan string)
 		removes := make(chan string, 10)
 
-		go handleEventsForChans(done, eventIn, adds, removes, files)
+		go handleEventsForChans(ctx, eventIn, adds, removes, files, util.IsNewFile)
 
 		createDirEvent := fsnotify.Event{Name: "test", Op: fsnotify.Create}
 
@@ -52,7 +145,7 @@ func TestDirectoryWatcher(t *testing.T) {
 
 		So(file, ShouldEqual, testFile)
 
-		done <- true
+		cancel()
 	})
 
 	Convey("Test Build dirs", t, func() {
@@ -84,7 +177,7 @@ func TestDirectoryWatcher(t *testing.T) {
 
 		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
 
-		watcher.ActiveFiles["test"] = "testPath"
+		watcher.ActiveFiles["test"] = state.Record{Name: "test", OrigPath: "testPath"}
 
 		testFilePath := "test/complete/test.avi"
 		testFile, err := os.Create(testFilePath)
@@ -93,24 +186,298 @@ func TestDirectoryWatcher(t *testing.T) {
 		err = testFile.Close()
 		So(err, ShouldBeNil)
 
-		go watcher.WatchForCompletion()
+		watcher.run(watcher.WatchForCompletion)
 		finalizer := <-watcher.DoneFiles
 		So(finalizer.origPath, ShouldEqual, "testPath")
 		So(finalizer.orig, ShouldEqual, "test")
 		So(finalizer.outFile, ShouldEqual, "test.avi")
 
-		watcher.CompleteWatcherDone <- true
+		So(watcher.Close(), ShouldBeNil)
 
 		So(watcher.ActiveFiles, ShouldNotContainKey, "test")
 
 	})
 
+	Convey("Test consuming a torrent reads its metadata", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
+		serveRequests(watcher)
+
+		data, err := bencode.Encode(map[string]interface{}{
+			"info": map[string]interface{}{"name": "Real Name", "piece length": int64(16384), "length": int64(3)},
+		})
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile("test/watch/movies/abc.torrent", data, os.ModePerm)
+		So(err, ShouldBeNil)
+
+		consumed := testutil.ToFloat64(metrics.TorrentsConsumed)
+		watcher.consumeFileWithTimeout("test/watch/movies/abc.torrent", time.Second)
+		So(testutil.ToFloat64(metrics.TorrentsConsumed), ShouldEqual, consumed+1)
+
+		_, err = os.Stat("test/drop/abc.torrent")
+		So(err, ShouldBeNil)
+		active := activeFiles(watcher)
+		So(active, ShouldContainKey, "abc.torrent")
+		So(active["abc.torrent"].Info.Name, ShouldEqual, "Real Name")
+		So(active["abc.torrent"].InfoHash, ShouldNotBeEmpty)
+	})
+
+	Convey("Handle events forwards magnet files", t, func() {
+		resetTestDir()
+		testFile := "test/watch/tv/show.magnet"
+		_, err := os.Create(testFile)
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		eventIn := make(chan fsnotify.Event, 10)
+		files := make(chan string, 10)
+
+		go handleEventsForChans(ctx, eventIn, make(chan string, 10), make(chan string, 10), files, util.IsNewFile)
+
+		eventIn <- fsnotify.Event{Name: testFile, Op: fsnotify.Create}
+		So(<-files, ShouldEqual, testFile)
+
+		cancel()
+	})
+
+	Convey("Test consuming a magnet file", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
+		serveRequests(watcher)
+
+		uri := "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Some.Show.S01"
+		err := ioutil.WriteFile("test/watch/tv/show.magnet", []byte(uri), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		watcher.consumeFileWithTimeout("test/watch/tv/show.magnet", time.Second)
+
+		_, err = os.Stat("test/watch/tv/show.magnet")
+		So(err, ShouldNotBeNil)
+		dropped, err := ioutil.ReadFile("test/drop/show.magnet")
+		So(err, ShouldBeNil)
+		So(string(dropped), ShouldEqual, uri+"\n")
+
+		active := activeFiles(watcher)
+		So(active, ShouldContainKey, "show.magnet")
+		So(active["show.magnet"].DisplayName, ShouldEqual, "Some.Show.S01")
+		So(active["show.magnet"].InfoHash, ShouldEqual, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a")
+	})
+
+	Convey("Test consuming a magnet file as a torrent", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.MagnetFormat = config.MagnetFormatTorrent
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		serveRequests(watcher)
+
+		uri := "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+		err := ioutil.WriteFile("test/watch/tv/show.url", []byte("[InternetShortcut]\nURL="+uri+"\n"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		watcher.consumeFileWithTimeout("test/watch/tv/show.url", time.Second)
+
+		dropped, err := ioutil.ReadFile("test/drop/show.torrent")
+		So(err, ShouldBeNil)
+		decoded, err := bencode.Decode(dropped)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, map[string]interface{}{"magnet-uri": uri})
+	})
+
+	Convey("Test consuming a web shortcut gives up right away", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		serveRequests(watcher)
+
+		err := ioutil.WriteFile("test/watch/tv/site.url", []byte("[InternetShortcut]\nURL=https://example.com/\n"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		start := time.Now()
+		watcher.consumeFileWithTimeout("test/watch/tv/site.url", time.Hour)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		_, err = os.Stat("test/watch/tv/site.url")
+		So(err, ShouldBeNil)
+		So(activeFiles(watcher), ShouldBeEmpty)
+	})
+
+	Convey("Test magnet completion matched by display name", t, func() {
+		resetTestDir()
+
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.ActiveFiles["show.magnet"] = state.Record{
+			Name:        "show.magnet",
+			OrigPath:    "test/watch/tv/show.magnet",
+			InfoHash:    "c12fe1c06bba254a9dc9f519b335aa7c1367a88a",
+			DisplayName: "Some.Show.S01",
+		}
+
+		err := ioutil.WriteFile("test/complete/show", []byte("abc"), os.ModePerm)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile("test/complete/Some.Show.S01", []byte("abc"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		watcher.run(watcher.WatchForCompletion)
+		finalizer := <-watcher.DoneFiles
+		So(finalizer.orig, ShouldEqual, "show.magnet")
+		So(finalizer.outFile, ShouldEqual, "Some.Show.S01")
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test completion matched by torrent metadata", t, func() {
+		resetTestDir()
+
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.ActiveFiles["abc.torrent"] = state.Record{
+			Name:     "abc.torrent",
+			OrigPath: "test/watch/movies/abc.torrent",
+			Info:     &torrent.Info{Name: "Real Name", Length: 3},
+		}
+
+		err := ioutil.WriteFile("test/complete/abc", []byte("abc"), os.ModePerm)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile("test/complete/Real Name", []byte("abc"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		watcher.run(watcher.WatchForCompletion)
+		finalizer := <-watcher.DoneFiles
+		So(finalizer.orig, ShouldEqual, "abc.torrent")
+		So(finalizer.outFile, ShouldEqual, "Real Name")
+		So(finalizer.info.Name, ShouldEqual, "Real Name")
+
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test a verified payload is not held up by a look-alike", t, func() {
+		resetTestDir()
+
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{
+			Name:     "abc.torrent",
+			OrigPath: "test/watch/movies/abc.torrent",
+			Info:     &torrent.Info{Name: "Real Name", Length: 3},
+		})
+		ioutil.WriteFile("test/complete/Real Name", []byte("abc"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Real Name.mkv", []byte("abc"), os.ModePerm)
+
+		watcher.checkForCompletions()
+		So(watcher.Ambiguous, ShouldBeEmpty)
+		So(watcher.finalizing, ShouldHaveLength, 1)
+		So(watcher.finalizing[0].outFile, ShouldEqual, "Real Name")
+	})
+
+	Convey("Test completion picks the best candidate and reports ambiguity", t, func() {
+		resetTestDir()
+
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "Show.S01E01.720p.HDTV-AAA.torrent", OrigPath: "test/watch/tv/a.torrent"})
+		watcher.track(state.Record{Name: "Show.S01E01.torrent", OrigPath: "test/watch/tv/b.torrent"})
+		watcher.track(state.Record{Name: "It.torrent", OrigPath: "test/watch/movies/It.torrent"})
+
+		ioutil.WriteFile("test/complete/Show.S01E01.1080p.WEB-BBB.mkv", []byte("b"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.S01E01.720p.HDTV-AAA.mkv", []byte("a"), os.ModePerm)
+		ioutil.WriteFile("test/complete/It Follows (2014).mkv", []byte("c"), os.ModePerm)
+
+		watcher.checkForCompletions()
+
+		So(watcher.finalizing, ShouldHaveLength, 1)
+		So(watcher.finalizing[0].orig, ShouldEqual, "Show.S01E01.720p.HDTV-AAA.torrent")
+		So(watcher.finalizing[0].outFile, ShouldEqual, "Show.S01E01.720p.HDTV-AAA.mkv")
+
+		// the bare episode name fits both releases equally, and "It" is too short to trust
+		So(watcher.ActiveFiles, ShouldContainKey, "It.torrent")
+		So(watcher.Ambiguous, ShouldNotContainKey, "It.torrent")
+		So(watcher.ActiveFiles, ShouldContainKey, "Show.S01E01.torrent")
+		So(watcher.Ambiguous["Show.S01E01.torrent"], ShouldHaveLength, 2)
+
+		watcher.run(watcher.WatchForCompletion)
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		for _, active := range status.ActiveFiles {
+			if active.Name == "Show.S01E01.torrent" {
+				So(active.Ambiguous, ShouldHaveLength, 2)
+			}
+		}
+		So(watcher.Close(), ShouldBeNil)
+	})
+
+	Convey("Test completion waits for the payload to settle", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.SettleWindow = config.Duration(time.Millisecond * 300)
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		watcher.track(state.Record{Name: "Film.torrent", OrigPath: "test/watch/movies/Film.torrent"})
+
+		os.Mkdir("test/complete/Film", os.ModePerm)
+		ioutil.WriteFile("test/complete/Film/Film.mkv", []byte("still copying"), os.ModePerm)
+
+		watcher.checkForCompletions()
+		So(watcher.ActiveFiles, ShouldContainKey, "Film.torrent")
+
+		time.Sleep(time.Millisecond * 150)
+		ioutil.WriteFile("test/complete/Film/Film.sample.mkv", []byte("s"), os.ModePerm)
+		watcher.checkForCompletions()
+		So(watcher.ActiveFiles, ShouldContainKey, "Film.torrent")
+
+		time.Sleep(time.Millisecond * 350)
+		watcher.checkForCompletions()
+		So(watcher.finalizing, ShouldHaveLength, 1)
+		So(watcher.finalizing[0].outFile, ShouldEqual, "Film")
+	})
+
+	Convey("Test changes anywhere in the completed dir are seen", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		So(watcher.watchCompleted(), ShouldBeNil)
+		defer watcher.compWatch.Close()
+
+		os.MkdirAll("test/complete/Show/Season 1", os.ModePerm)
+		So(<-watcher.compChanges, ShouldEqual, "Show")
+
+		time.Sleep(time.Millisecond * 100)
+		for len(watcher.compChanges) > 0 {
+			<-watcher.compChanges
+		}
+		ioutil.WriteFile("test/complete/Show/Season 1/Show.S01E01.mkv", []byte("e"), os.ModePerm)
+		select {
+		case compFile := <-watcher.compChanges:
+			So(compFile, ShouldEqual, "Show")
+		case <-time.After(time.Second):
+			So("no change seen", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Test completions are matched as soon as they appear", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.PollInterval = config.Duration(time.Hour)
+		cfg.RescanInterval = config.Duration(time.Hour * 2)
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		So(watcher.pollInterval(), ShouldEqual, time.Hour)
+		So(watcher.watchCompleted(), ShouldBeNil)
+		defer watcher.compWatch.Close()
+		So(watcher.pollInterval(), ShouldEqual, time.Hour*2)
+
+		watcher.track(state.Record{Name: "Film.torrent", OrigPath: "test/watch/movies/Film.torrent"})
+		watcher.run(watcher.WatchForCompletion)
+
+		os.Mkdir("test/complete/Film", os.ModePerm)
+		ioutil.WriteFile("test/complete/Film/Film.mkv", []byte("film"), os.ModePerm)
+		select {
+		case finalizer := <-watcher.DoneFiles:
+			So(finalizer.outFile, ShouldEqual, "Film")
+		case <-time.After(time.Second * 5):
+			So("no completion", ShouldBeEmpty)
+		}
+		So(watcher.Close(), ShouldBeNil)
+	})
+
 	Convey("Test processing completed single file", t, func() {
 		resetTestDir()
 
 		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
 
-		go watcher.ProcessCompletions()
+		watcher.run(watcher.ProcessCompletions)
 
 		outFilePath := "test/complete/file.avi"
 		startFile, err := os.Create(outFilePath)
@@ -119,7 +486,7 @@ func TestDirectoryWatcher(t *testing.T) {
 
 		finalFile := Finalizer{orig: "file.torrent", origPath: "test/watch/movies/file.torrent", outFile: "file.avi"}
 		watcher.DoneFiles <- finalFile
-		watcher.FinalizerDone <- true
+		So(watcher.Close(), ShouldBeNil)
 
 		_, err = os.Stat("test/media/movies/file.avi")
 		So(err, ShouldBeNil)
@@ -130,7 +497,7 @@ func TestDirectoryWatcher(t *testing.T) {
 
 		watcher := NewSimpleWatcher("test/watch", "test/drop", "test/complete", "test/media")
 
-		go watcher.ProcessCompletions()
+		watcher.run(watcher.ProcessCompletions)
 
 		os.MkdirAll("test/complete/fileDir", os.ModePerm)
 		outFilePath := "test/complete/fileDir/file.avi"
@@ -140,11 +507,468 @@ func TestDirectoryWatcher(t *testing.T) {
 
 		finalFile := Finalizer{orig: "file.torrent", origPath: "test/watch/movies/file.torrent", outFile: "fileDir"}
 		watcher.DoneFiles <- finalFile
-		watcher.FinalizerDone <- true
+		So(watcher.Close(), ShouldBeNil)
 
 		_, err = os.Stat("test/media/movies/file.avi")
 		So(err, ShouldBeNil)
 	})
+
+	Convey("Test hooks fire as a torrent is consumed and linked", t, func() {
+		resetTestDir()
+
+		var lock sync.Mutex
+		events := make([]hooks.Payload, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload hooks.Payload
+			json.NewDecoder(r.Body).Decode(&payload)
+			lock.Lock()
+			events = append(events, payload)
+			lock.Unlock()
+		}))
+		defer server.Close()
+
+		cfg := testConfig()
+		cfg.Hooks = []hooks.Hook{{URL: server.URL}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		serveRequests(watcher)
+
+		ioutil.WriteFile("test/watch/movies/file.torrent", []byte("not really a torrent"), os.ModePerm)
+		watcher.consumeFileWithTimeout("test/watch/movies/file.torrent", time.Second)
+		watcher.hooks.Wait()
+
+		watcher.run(watcher.ProcessCompletions)
+		ioutil.WriteFile("test/complete/file.avi", []byte("movie"), os.ModePerm)
+		watcher.DoneFiles <- Finalizer{orig: "file.torrent", origPath: "test/watch/movies/file.torrent", outFile: "file.avi"}
+		So(watcher.Close(), ShouldBeNil)
+		watcher.hooks.Wait()
+
+		So(events, ShouldHaveLength, 2)
+		So(events[0].Event, ShouldEqual, hooks.EventConsumed)
+		So(events[0].Name, ShouldEqual, "file.torrent")
+		So(events[1].Event, ShouldEqual, hooks.EventLinked)
+		So(events[1].Category, ShouldEqual, "movies")
+		So(events[1].Destination, ShouldEqual, "test/media/movies")
+	})
+
+	Convey("Test libraries are refreshed once a payload is linked", t, func() {
+		resetTestDir()
+
+		refreshed := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			refreshed <- r.URL.Query().Get("path")
+		}))
+		defer server.Close()
+
+		cfg := testConfig()
+		cfg.Libraries = []library.Config{{Type: library.TypePlex, URL: server.URL, Section: "1"}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+
+		watcher.run(watcher.ProcessCompletions)
+		ioutil.WriteFile("test/complete/film.avi", []byte("movie"), os.ModePerm)
+		watcher.DoneFiles <- Finalizer{orig: "film.torrent", origPath: "test/watch/movies/film.torrent", outFile: "film.avi"}
+		So(watcher.Close(), ShouldBeNil)
+
+		expected, _ := filepath.Abs("test/media/movies")
+		select {
+		case dir := <-refreshed:
+			So(dir, ShouldEqual, expected)
+		case <-time.After(time.Second * 5):
+			So("library was never refreshed", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Test processing a payload that fails verification", t, func() {
+		resetTestDir()
+
+		cfg := testConfig()
+		cfg.VerifyPieces = true
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		watcher.Store.Put(state.Record{Name: "file.torrent", Status: state.StatusCompleted})
+
+		watcher.run(watcher.ProcessCompletions)
+
+		err := ioutil.WriteFile("test/complete/file.avi", []byte("corrupt"), os.ModePerm)
+		So(err, ShouldBeNil)
+
+		info := &torrent.Info{Name: "file.avi", Length: 7, PieceLength: 16384, Pieces: make([]byte, 20)}
+		finalFile := Finalizer{orig: "file.torrent", origPath: "test/watch/movies/file.torrent", outFile: "file.avi", info: info}
+		watcher.DoneFiles <- finalFile
+		So(watcher.Close(), ShouldBeNil)
+
+		_, err = os.Lstat("test/media/movies/file.avi")
+		So(err, ShouldNotBeNil)
+
+		record, _ := watcher.Store.Get("file.torrent")
+		So(record.Status, ShouldEqual, state.StatusFailed)
+		So(record.Error, ShouldContainSubstring, "verification")
+	})
+
+	Convey("Test verifying a resumed payload against the kept torrent", t, func() {
+		resetTestDir()
+
+		cfg := testConfig()
+		cfg.VerifyPieces = true
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		store, err := state.OpenStore("test/state")
+		So(err, ShouldBeNil)
+		watcher.Store = store
+		data, _ := bencode.Encode(map[string]interface{}{
+			"info": map[string]interface{}{"name": "file.avi", "piece length": int64(16384), "pieces": string(make([]byte, 20)), "length": int64(7)},
+		})
+		So(store.SaveTorrent("file.torrent", data), ShouldBeNil)
+		store.Put(state.Record{Name: "file.torrent", Status: state.StatusCompleted})
+		ioutil.WriteFile("test/complete/file.avi", []byte("corrupt"), os.ModePerm)
+
+		watcher.run(watcher.ProcessCompletions)
+		// a resumed record's info has no piece hashes, as they aren't saved with it
+		info := &torrent.Info{Name: "file.avi", Length: 7, PieceLength: 16384}
+		watcher.DoneFiles <- Finalizer{orig: "file.torrent", origPath: "test/watch/movies/file.torrent", outFile: "file.avi", info: info}
+		So(watcher.Close(), ShouldBeNil)
+
+		record, _ := watcher.Store.Get("file.torrent")
+		So(record.Status, ShouldEqual, state.StatusFailed)
+		So(record.Error, ShouldContainSubstring, "does not match its hash")
+	})
+
+	Convey("Test the index and ignore list follow the completed dir", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		ioutil.WriteFile("test/complete/old.avi", []byte("o"), os.ModePerm)
+		ioutil.WriteFile("test/complete/new.avi", []byte("n"), os.ModePerm)
+		watcher.IgnoreFiles["old.avi"] = true
+		watcher.IgnoreFiles["gone.avi"] = true
+
+		So(watcher.rescan(), ShouldBeNil)
+		So(watcher.index.Names(), ShouldResemble, []string{"new.avi"})
+		So(watcher.IgnoreFiles, ShouldResemble, map[string]bool{"old.avi": true})
+
+		os.Mkdir("test/complete/Film", os.ModePerm)
+		watcher.updateEntry("Film")
+		So(watcher.index.Has("Film"), ShouldBeTrue)
+
+		os.Remove("test/complete/old.avi")
+		watcher.updateEntry("old.avi")
+		So(watcher.IgnoreFiles, ShouldBeEmpty)
+
+		watcher.ignore("new.avi")
+		So(watcher.index.Has("new.avi"), ShouldBeFalse)
+		So(watcher.IgnoreFiles, ShouldContainKey, "new.avi")
+	})
+
+	Convey("Test torrents are only scored again once their candidates change", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "Show.S01E01.torrent", OrigPath: "test/watch/tv/a.torrent"})
+		watcher.track(state.Record{Name: "file.torrent", OrigPath: "test/watch/movies/file.torrent", Info: &torrent.Info{Name: "Show.S01E01.mkv", Length: 1}})
+		ioutil.WriteFile("test/complete/Show.S01E01.1080p.WEB-BBB.mkv", []byte("b"), os.ModePerm)
+		ioutil.WriteFile("test/complete/Show.S01E01.720p.HDTV-AAA.mkv", []byte("a"), os.ModePerm)
+
+		watcher.checkForCompletions()
+		So(watcher.Ambiguous["Show.S01E01.torrent"], ShouldHaveLength, 2)
+		ranked := watcher.rankings["Show.S01E01.torrent"].ranked
+		So(ranked, ShouldHaveLength, 2)
+		// the payload of a torrent with metadata is checked every time, as it may still be downloading
+		So(watcher.rankings, ShouldNotContainKey, "file.torrent")
+
+		watcher.matchCompletions()
+		So(&watcher.rankings["Show.S01E01.torrent"].ranked[0], ShouldPointTo, &ranked[0])
+
+		os.Remove("test/complete/Show.S01E01.1080p.WEB-BBB.mkv")
+		watcher.updateEntry("Show.S01E01.1080p.WEB-BBB.mkv")
+		watcher.matchCompletions()
+		So(watcher.rankings["Show.S01E01.torrent"].ranked, ShouldHaveLength, 1)
+		So(watcher.Ambiguous, ShouldNotContainKey, "Show.S01E01.torrent")
+	})
+
+	Convey("Test consuming and completing many torrents at once", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.run(watcher.WatchForCompletion)
+		defer watcher.Close()
+
+		const count = 50
+		done := make(chan Finalizer, count)
+		go func() {
+			for finalizer := range watcher.DoneFiles {
+				done <- finalizer
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			file := fmt.Sprintf("test/watch/movies/film%d.torrent", i)
+			ioutil.WriteFile(file, []byte("not really a torrent"), os.ModePerm)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				watcher.consumeFileWithTimeout(file, time.Second)
+			}()
+			go func(i int) {
+				defer wg.Done()
+				ioutil.WriteFile(fmt.Sprintf("test/complete/film%d.avi", i), []byte("movie"), os.ModePerm)
+				select {
+				case watcher.compChanges <- fmt.Sprintf("film%d.avi", i):
+				default:
+				}
+			}(i)
+		}
+
+		// hammer the state from outside while it's changing
+		stop := make(chan bool)
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				watcher.Status()
+				watcher.CancelTracking("missing.torrent")
+			}
+		}()
+		wg.Wait()
+
+		completed := make(map[string]string, count)
+		for len(completed) < count {
+			select {
+			case finalizer := <-done:
+				completed[finalizer.orig] = finalizer.outFile
+			case <-time.After(time.Second * 10):
+				So(len(completed), ShouldEqual, count)
+				return
+			}
+		}
+		close(stop)
+
+		So(completed["film7.torrent"], ShouldEqual, "film7.avi")
+		So(activeFiles(watcher), ShouldBeEmpty)
+		status, err := watcher.Status()
+		So(err, ShouldBeNil)
+		So(status.IgnoreFiles, ShouldHaveLength, count)
+	})
+
+	Convey("Test directories added and removed at once are all recorded", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		fsWatcher, err := fsnotify.NewWatcher()
+		So(err, ShouldBeNil)
+		watcher.watcher = fsWatcher
+		watcher.run(watcher.WatchForCompletion)
+		defer watcher.Close()
+		watcher.run(watcher.handleFSWatcher)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			dir := fmt.Sprintf("test/watch/dir%d", i)
+			os.Mkdir(dir, os.ModePerm)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				watcher.Adds <- dir
+			}()
+			go func() {
+				defer wg.Done()
+				watcher.Status()
+			}()
+		}
+		wg.Wait()
+		So(waitForWatchedDirs(watcher, 20), ShouldContain, "test/watch/dir19")
+
+		watcher.Removes <- "test/watch/dir0"
+		So(waitForWatchedDirs(watcher, 19), ShouldNotContain, "test/watch/dir0")
+	})
+
+	Convey("Test closing with a completion nobody is waiting for", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.track(state.Record{Name: "film.torrent", OrigPath: "test/watch/movies/film.torrent"})
+		ioutil.WriteFile("test/complete/film.avi", []byte("movie"), os.ModePerm)
+		watcher.run(watcher.WatchForCompletion)
+
+		// nothing is finalizing, so the completion stays queued
+		for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 10) {
+			if record, _ := watcher.Store.Get("film.torrent"); record.Status == state.StatusCompleted {
+				break
+			}
+		}
+		So(watcher.Close(), ShouldBeNil)
+
+		record, _ := watcher.Store.Get("film.torrent")
+		So(record.Status, ShouldEqual, state.StatusCompleted)
+		So(record.Completed, ShouldEqual, "film.avi")
+		_, err := watcher.Status()
+		So(err, ShouldEqual, errClosed)
+	})
+
+	Convey("Test closing stops consuming at the next retry", t, func() {
+		resetTestDir()
+		watcher := NewSimpleWatcherFromConfig(testConfig())
+		watcher.dropOffDir = "test/missing"
+		ioutil.WriteFile("test/watch/movies/film.torrent", []byte("not really a torrent"), os.ModePerm)
+		watcher.run(func() {
+			watcher.consumeFileWithTimeout("test/watch/movies/film.torrent", time.Hour)
+		})
+		time.Sleep(time.Millisecond * 100)
+
+		start := time.Now()
+		So(watcher.Close(), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second*5)
+		_, err := os.Stat("test/watch/movies/film.torrent")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test closing waits for hooks in flight", t, func() {
+		resetTestDir()
+		finished := make(chan bool, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 200)
+			finished <- true
+		}))
+		defer server.Close()
+
+		cfg := testConfig()
+		cfg.Hooks = []hooks.Hook{{URL: server.URL}}
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		watcher.fire(hooks.EventLinked, state.Record{Name: "film.torrent"}, "movies", "test/media/movies", nil)
+
+		So(watcher.Close(), ShouldBeNil)
+		So(finished, ShouldHaveLength, 1)
+	})
+
+	Convey("Test closing gives up after the shutdown timeout", t, func() {
+		resetTestDir()
+		cfg := testConfig()
+		cfg.ShutdownTimeout = config.Duration(time.Millisecond * 100)
+		watcher := NewSimpleWatcherFromConfig(cfg)
+		stuck := make(chan bool)
+		defer close(stuck)
+		watcher.run(func() {
+			<-stuck
+		})
+
+		err := watcher.Close()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "gave up waiting for work in flight after 100ms")
+	})
+}
+
+// waitForWatchedDirs waits a while for watcher to be watching count dirs, returning the ones it's watching
+func waitForWatchedDirs(watcher *SimpleWatcher, count int) []string {
+	var status Status
+	var err error
+	for start := time.Now(); time.Since(start) < time.Second*5; time.Sleep(time.Millisecond * 10) {
+		status, err = watcher.Status()
+		So(err, ShouldBeNil)
+		if len(status.WatchedDirs) == count {
+			break
+		}
+	}
+	So(status.WatchedDirs, ShouldHaveLength, count)
+	return status.WatchedDirs
+}
+
+// serveRequests runs the requests sent to watcher, as WatchForCompletion would, without checking for completions
+func serveRequests(watcher *SimpleWatcher) {
+	go func() {
+		for request := range watcher.requests {
+			request()
+		}
+	}()
+}
+
+// activeFiles copies watcher's ActiveFiles on the goroutine that owns them
+func activeFiles(watcher *SimpleWatcher) map[string]state.Record {
+	active := make(map[string]state.Record, 0)
+	err := watcher.do(func() {
+		for name, record := range watcher.ActiveFiles {
+			active[name] = record
+		}
+	})
+	So(err, ShouldBeNil)
+	return active
+}
+
+// benchmarkWords are put together into titles for benchmark release names
+var benchmarkWords = strings.Fields(`the a of and night day house city man woman dark light blue red black white
+	last first great little big lost found king queen war peace star sun moon river sea mountain road home story
+	secret life death love time world dream fire ice stone iron gold silver ghost shadow wolf dragon bird tiger
+	heart mind soul blood storm wind rain snow summer winter spring autumn north south east west street bridge
+	tower garden island forest desert valley lake station hotel school doctor detective captain agent hunter
+	prince princess brother sister father mother son daughter friend enemy stranger angel devil saint pirate
+	empire kingdom republic nation planet galaxy machine robot signal code circle square line point edge`)
+
+// benchmarkName makes a plausible release name from seed in one of resolutions, half of them episodes and half films
+func benchmarkName(seed int, resolutions []string) string {
+	random := rand.New(rand.NewSource(int64(seed)))
+	words := make([]string, 0, 3)
+	for i := 0; i < 1+random.Intn(3); i++ {
+		words = append(words, strings.Title(benchmarkWords[random.Intn(len(benchmarkWords))]))
+	}
+	title := strings.Join(words, ".")
+	tags := resolutions[random.Intn(len(resolutions))] + "." + []string{"WEB-DL", "BluRay", "HDTV"}[random.Intn(3)] +
+		"." + []string{"x264", "x265"}[random.Intn(2)] + "-" + fmt.Sprintf("GRP%d", random.Intn(20))
+	if seed%2 == 0 {
+		return fmt.Sprintf("%v.S%02dE%02d.%v", title, 1+random.Intn(8), 1+random.Intn(20), tags)
+	}
+	return fmt.Sprintf("%v.%d.%v", title, 1950+random.Intn(70), tags)
+}
+
+// maxBenchmarkCandidates is the most of the 10k entries any benchmark torrent should be scored against. Each title word
+// is in about 2% of the entries, and titles have up to three of them
+const maxBenchmarkCandidates = 600
+
+// benchmarkWatcher has 10k entries in the completed dir and 500 active files, none of them finished, which is what
+// every check looks like while waiting for downloads. The active files are in other resolutions so they can't match
+func benchmarkWatcher(b *testing.B) *SimpleWatcher {
+	resetTestDir()
+	watcher := NewSimpleWatcherFromConfig(testConfig())
+	for i := 0; i < 10000; i++ {
+		err := ioutil.WriteFile(fmt.Sprintf("test/complete/%v.%d.mkv", benchmarkName(i, []string{"720p", "1080p", "2160p"}), i), nil, os.ModePerm)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		name := benchmarkName(1000000+i, []string{"480p", "576p"})
+		watcher.track(state.Record{Name: name + ".torrent", OrigPath: "test/watch/" + name + ".torrent"})
+	}
+
+	// checks only stay cheap as the completed dir grows if the index narrows down what each torrent is scored against
+	err := watcher.rescan()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for name, record := range watcher.ActiveFiles {
+		if found := len(watcher.index.Search(searchNames(record)...)); found > maxBenchmarkCandidates {
+			b.Fatalf("%v has %d candidates, expected at most %d", name, found, maxBenchmarkCandidates)
+		}
+	}
+	return watcher
+}
+
+func BenchmarkCheckForCompletions(b *testing.B) {
+	watcher := benchmarkWatcher(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		watcher.checkForCompletions()
+	}
+}
+
+func BenchmarkMatchCompletions(b *testing.B) {
+	watcher := benchmarkWatcher(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		watcher.matchCompletions()
+	}
+}
+
+func BenchmarkUpdateEntry(b *testing.B) {
+	watcher := benchmarkWatcher(b)
+	names := watcher.index.Names()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		watcher.updateEntry(names[i%len(names)])
+	}
 }
 
 func resetTestDir() {
@@ -162,3 +986,14 @@ func resetTestDir() {
 
 	os.Mkdir("test/media", os.ModePerm)
 }
+
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.RootDir = "test/watch"
+	cfg.DropDir = "test/drop"
+	cfg.CompletedDir = "test/complete"
+	cfg.MediaDir = "test/media"
+	cfg.PollInterval = config.Duration(time.Millisecond * 100)
+	cfg.SettleWindow = 0
+	return cfg
+}